@@ -1,6 +1,9 @@
 package main
 
 import (
+	"errors"
+	"fmt"
+	"io/fs"
 	"time"
 
 	"github.com/spf13/cobra"
@@ -10,9 +13,12 @@ import (
 )
 
 var (
-	cmd *cobra.Command
-	to  string
-	msg string
+	cmd       *cobra.Command
+	to        string
+	msg       string
+	rulesFile string
+	account   string
+	mode      string
 )
 
 func init() {
@@ -24,9 +30,16 @@ func init() {
 
 	cmd.PersistentFlags().StringVarP(&to, "to", "t", "", "email address to receive the forward")
 	cmd.PersistentFlags().StringVarP(&msg, "file", "f", "", "the file name of the message to forward")
+	cmd.PersistentFlags().StringVar(&rulesFile, "rules", mail.DefaultPrimaryRulesConfigPath(), "the rules file containing the smtp configuration")
+	cmd.PersistentFlags().StringVar(&account, "smtp", "", "the name of the SMTP account to send as")
+	cmd.PersistentFlags().StringVar(&mode, "mode", mail.ForwardInline, "the forwarding mode: inline, redirect, or attach")
 }
 
 func RunForward(cmd *cobra.Command, args []string) {
+	if !mail.IsForwardMode(mode) {
+		panic(fmt.Errorf("unknown forwarding mode %q", mode))
+	}
+
 	m := mail.NewFileMessage(msg)
 
 	as := make(addr.AddressList, 1)
@@ -36,7 +49,20 @@ func RunForward(cmd *cobra.Command, args []string) {
 		panic(err)
 	}
 
-	err = m.ForwardTo(as, time.Now())
+	var smtp *mail.SMTPConfig
+	rf, err := mail.LoadRulesFile(rulesFile)
+	if err == nil {
+		smtp = rf.SMTP
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		panic(err)
+	}
+
+	mailer, err := smtp.Mailer(account, mail.DefaultCredentials)
+	if err != nil {
+		panic(err)
+	}
+
+	_, err = m.ForwardTo(mailer, mode, as, time.Now())
 	if err != nil {
 		panic(err)
 	}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+
+	"github.com/zostay/dotfiles-go/internal/mail"
+)
+
+func RunDedupe(cmd *cobra.Command, args []string) {
+	filter := newFilter()
+
+	sets, err := filter.FindDuplicates(folders)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	extras := 0
+	for _, ds := range sets {
+		fmt.Println(ds.Key)
+		fmt.Printf("  keep   %s\n", ds.Keep().Filename())
+		for _, m := range ds.Extras() {
+			fmt.Printf("  remove %s\n", m.Filename())
+			extras++
+		}
+	}
+
+	fmt.Printf("Found %d duplicated messages with %d extra copies.\n", len(sets), extras)
+
+	if !mergeDupes {
+		return
+	}
+
+	actions := make(mail.ActionsSummary)
+	for _, ds := range sets {
+		err := filter.MergeDuplicates(actions, ds)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+		}
+	}
+
+	fmt.Print(actions)
+}
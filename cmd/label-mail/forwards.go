@@ -0,0 +1,43 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+
+	"github.com/zostay/dotfiles-go/internal/fssafe"
+	"github.com/zostay/dotfiles-go/internal/mail"
+)
+
+func RunForwardsList(cmd *cobra.Command, args []string) {
+	ledger := mail.NewForwardLedger(fssafe.NewFileSystemLoaderSaver(mail.DefaultForwardLedgerPath()))
+
+	items, err := ledger.History()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	for _, item := range items {
+		queued := ""
+		if item.Failed {
+			queued = " (failed, queued " + item.Queued + ")"
+		} else if item.Queued != "" {
+			queued = " (queued " + item.Queued + ")"
+		}
+
+		fmt.Printf("%s  %-8s  %s -> %s%s\n",
+			item.Sent.Local().Format("2006-01-02 15:04"),
+			item.Mode,
+			item.Key,
+			item.To,
+			queued,
+		)
+		if item.Subject != "" {
+			fmt.Printf("    %s\n", item.Subject)
+		}
+	}
+
+	fmt.Printf("Found %d forwards.\n", len(items))
+}
@@ -0,0 +1,24 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+)
+
+func RunFsck(cmd *cobra.Command, args []string) {
+	filter := newFilter()
+
+	report, err := filter.Fsck(folders, fsckRepair)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	fmt.Print(report)
+
+	if report.Unrepaired() > 0 {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,20 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+)
+
+func RunIndex(cmd *cobra.Command, args []string) {
+	filter := newFilter()
+
+	report, err := filter.UpdateIndex()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	fmt.Print(report)
+}
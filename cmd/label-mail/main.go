@@ -11,7 +11,7 @@ import (
 	_ "github.com/zostay/go-addr/pkg/addr/encoding"
 	_ "github.com/zostay/go-email/pkg/email/encoding"
 
-	"github.com/zostay/dotfiles-go/internal/keeper"
+	"github.com/zostay/dotfiles-go/internal/dotfiles"
 	"github.com/zostay/dotfiles-go/internal/mail"
 )
 
@@ -31,11 +31,21 @@ var (
 	vacuumFirst    bool
 	vacuumOnly     bool
 	version        bool
+	mergeDupes     bool
+	forceFlush     bool
+	spamFolders    []string
+	environments   []string
+	unusedDays     int
+	tuiLimit       int
+	statsJSON      bool
+	fsckRepair     bool
+
+	suggestMinSupport    int
+	suggestMinConfidence float64
+	appendSuggestions    bool
 )
 
 func init() {
-	keeper.RequiresSecretKeeper()
-
 	cmd = &cobra.Command{
 		Use:   "label-mail",
 		Short: "Sort my email in the local MailDir",
@@ -54,25 +64,196 @@ func init() {
 	cmd.PersistentFlags().BoolVar(&vacuumFirst, "vacuum-first", false, "vacuum the Mail directory before filtering")
 	cmd.PersistentFlags().BoolVar(&vacuumOnly, "vacuum-only", false, "vacuum the Mail directory without filtering")
 	cmd.PersistentFlags().BoolVar(&version, "version", false, "show the version information for the program")
+	cmd.PersistentFlags().StringSliceVar(&environments, "env", []string{}, "select the rule environments to use instead of the configured ones")
+
+	dedupeCmd := &cobra.Command{
+		Use:   "dedupe",
+		Short: "Find copies of the same message across folders",
+		Args:  cobra.NoArgs,
+		Run:   RunDedupe,
+	}
+
+	dedupeCmd.Flags().BoolVar(&mergeDupes, "merge", false, "merge keywords into one copy and remove the other copies")
+
+	cmd.AddCommand(dedupeCmd)
+
+	forwardsCmd := &cobra.Command{
+		Use:   "forwards",
+		Short: "Work with the record of forwarded messages",
+	}
+
+	forwardsListCmd := &cobra.Command{
+		Use:   "list",
+		Short: "List what messages were forwarded where and when",
+		Args:  cobra.NoArgs,
+		Run:   RunForwardsList,
+	}
+
+	forwardsCmd.AddCommand(forwardsListCmd)
+	cmd.AddCommand(forwardsCmd)
+
+	queueCmd := &cobra.Command{
+		Use:   "queue",
+		Short: "Work with outgoing mail waiting to be retried",
+	}
+
+	queueListCmd := &cobra.Command{
+		Use:   "list",
+		Short: "List the queued outgoing mail",
+		Args:  cobra.NoArgs,
+		Run:   RunQueueList,
+	}
+
+	queueFlushCmd := &cobra.Command{
+		Use:   "flush",
+		Short: "Retry sending the queued outgoing mail that is due",
+		Args:  cobra.NoArgs,
+		Run:   RunQueueFlush,
+	}
+
+	queueFlushCmd.Flags().BoolVar(&forceFlush, "force", false, "retry every queued message, even those not yet due")
+
+	queueDropCmd := &cobra.Command{
+		Use:   "drop <id>...",
+		Short: "Remove messages from the queue without sending them",
+		Args:  cobra.MinimumNArgs(1),
+		Run:   RunQueueDrop,
+	}
+
+	queueCmd.AddCommand(queueListCmd, queueFlushCmd, queueDropCmd)
+	cmd.AddCommand(queueCmd)
+
+	trainCmd := &cobra.Command{
+		Use:   "train",
+		Short: "Train the spam classifier from the messages already filed",
+		Args:  cobra.NoArgs,
+		Run:   RunTrain,
+	}
+
+	trainCmd.Flags().StringSliceVar(&spamFolders, "spam-folder", mail.DefaultSpamFolders, "folders holding examples of spam")
+
+	cmd.AddCommand(trainCmd)
+
+	rulesCmd := &cobra.Command{
+		Use:   "rules",
+		Short: "Work with the filtering rules",
+	}
+
+	rulesStatsCmd := &cobra.Command{
+		Use:   "stats",
+		Short: "Show how often each rule has matched and which rules no longer match",
+		Args:  cobra.NoArgs,
+		Run:   RunRulesStats,
+	}
+
+	rulesStatsCmd.Flags().IntVar(&unusedDays, "unused-days", 90, "list rules that have not matched in this many days (0 to skip)")
+
+	rulesSuggestCmd := &cobra.Command{
+		Use:   "suggest",
+		Short: "Propose rules for labels already applied consistently by sender, domain, or list",
+		Args:  cobra.NoArgs,
+		Run:   RunRulesSuggest,
+	}
+
+	rulesSuggestCmd.Flags().IntVar(&suggestMinSupport, "min-support", mail.DefaultSuggestMinSupport, "the fewest labeled messages needed to suggest a rule")
+	rulesSuggestCmd.Flags().Float64Var(&suggestMinConfidence, "min-confidence", mail.DefaultSuggestMinConfidence, "the smallest share of messages that must carry the label to suggest a rule")
+	rulesSuggestCmd.Flags().BoolVar(&appendSuggestions, "append", false, "append the suggested rules to the local rules file")
+
+	rulesCmd.AddCommand(rulesStatsCmd, rulesSuggestCmd)
+	cmd.AddCommand(rulesCmd)
+
+	tuiCmd := &cobra.Command{
+		Use:   "tui [folder]",
+		Short: "Browse recent messages, see which rules match, and add new rules",
+		Args:  cobra.MaximumNArgs(1),
+		Run:   RunTUI,
+	}
+
+	tuiCmd.Flags().IntVar(&tuiLimit, "limit", 200, "the number of recent messages to list")
+
+	cmd.AddCommand(tuiCmd)
+
+	searchCmd := &cobra.Command{
+		Use:   "search <expr>...",
+		Short: "List the messages matching a search expression, e.g., from_domain:example.com keyword:Friends",
+		Args:  cobra.MinimumNArgs(1),
+		Run:   RunSearch,
+	}
+
+	cmd.AddCommand(searchCmd)
+
+	indexCmd := &cobra.Command{
+		Use:   "index",
+		Short: "Build or update the full-text index used by search and contains rules",
+		Args:  cobra.NoArgs,
+		Run:   RunIndex,
+	}
+
+	cmd.AddCommand(indexCmd)
+
+	statsCmd := &cobra.Command{
+		Use:   "stats",
+		Short: "Count the messages in each folder and report problems found in the mail root",
+		Args:  cobra.NoArgs,
+		Run:   RunStats,
+	}
+
+	statsCmd.Flags().BoolVar(&statsJSON, "json", false, "print the stats as JSON")
+
+	cmd.AddCommand(statsCmd)
+
+	fsckCmd := &cobra.Command{
+		Use:   "fsck",
+		Short: "Check the maildir folders for structural problems and junk left behind",
+		Args:  cobra.NoArgs,
+		Run:   RunFsck,
+	}
+
+	fsckCmd.Flags().BoolVar(&fsckRepair, "repair", false, "repair the problems that can be repaired safely")
+
+	cmd.AddCommand(fsckCmd)
 }
 
-func RunLabelMail(cmd *cobra.Command, args []string) {
-	if version {
-		fmt.Printf("label-mail v%s\n", VersionNumber)
-		return
+// newFilter constructs the mail.Filter configured from the command-line flags.
+func newFilter() *mail.Filter {
+	filter, err := loadFilter()
+	if err != nil {
+		panic(err)
 	}
 
+	return filter
+}
+
+// loadFilter works like newFilter, but returns an error rather than panicking
+// when the rules cannot be loaded.
+func loadFilter() (*mail.Filter, error) {
 	if mailDir == "" {
-		panic(errors.New("maildir did not work"))
+		return nil, errors.New("maildir did not work")
+	}
+
+	if len(environments) > 0 {
+		dotfiles.OverrideEnvironments(environments...)
 	}
 
 	filter, err := mail.NewFilter(mailDir, rulesFile, localRulesFile)
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
 
 	filter.SetDebugLevel(verbose)
 	filter.SetDryRun(dryRun)
+	filter.SetAllowSendingEmail(allowSending)
+
+	return filter, nil
+}
+
+func RunLabelMail(cmd *cobra.Command, args []string) {
+	if version {
+		fmt.Printf("label-mail v%s\n", VersionNumber)
+		return
+	}
+
+	filter := newFilter()
 
 	if !allMail {
 		filter.LimitFilterToRecent(2 * time.Hour)
@@ -95,20 +276,42 @@ func RunLabelMail(cmd *cobra.Command, args []string) {
 	}
 
 	if vacuumFirst {
-		err := filter.Vacuum()
+		report, err := filter.Vacuum()
 		if err != nil {
 			fmt.Fprintln(os.Stderr, err)
 		}
+
+		fmt.Print(report)
 	}
 
-	var actions mail.ActionsSummary
-	if !vacuumOnly {
-		actions, err = filter.LabelMessages(folders)
+	if vacuumOnly {
+		return
+	}
+
+	if allowSending {
+		report, err := filter.FlushQueue(false)
 		if err != nil {
 			fmt.Fprintln(os.Stderr, err)
+		} else if len(report.Sent) > 0 || len(report.Failed) > 0 || len(report.GaveUp) > 0 {
+			fmt.Println(report)
 		}
 	}
 
+	actions, err := filter.LabelMessages(folders)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+	}
+
+	err = filter.SaveRuleStats()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+	}
+
+	_, err = filter.RefreshIndex()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+	}
+
 	fmt.Print(actions)
 }
 
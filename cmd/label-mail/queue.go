@@ -0,0 +1,91 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"github.com/zostay/dotfiles-go/internal/mail"
+)
+
+func RunQueueList(cmd *cobra.Command, args []string) {
+	q := mail.NewQueue(mail.DefaultQueuePath())
+
+	es, err := q.Entries()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	failed, err := q.Failed()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	for _, e := range es {
+		fmt.Println(e.ID)
+		fmt.Printf("  %s -> %s\n", e.From, strings.Join(e.To, ", "))
+		fmt.Printf("  attempts %d, next attempt %s\n", e.Attempts, e.NextAttempt.Local().Format("2006-01-02 15:04"))
+		if e.LastError != "" {
+			fmt.Printf("  last error: %s\n", e.LastError)
+		}
+	}
+
+	for _, e := range failed {
+		fmt.Printf("%s (given up)\n", e.ID)
+		fmt.Printf("  %s -> %s\n", e.From, strings.Join(e.To, ", "))
+		fmt.Printf("  attempts %d, queued %s\n", e.Attempts, e.Created.Local().Format("2006-01-02 15:04"))
+		if e.LastError != "" {
+			fmt.Printf("  last error: %s\n", e.LastError)
+		}
+	}
+
+	fmt.Printf("Found %d queued messages, %d given up.\n", len(es), len(failed))
+}
+
+func RunQueueFlush(cmd *cobra.Command, args []string) {
+	filter := newFilter()
+
+	report, err := filter.FlushQueue(forceFlush)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	for _, e := range report.Sent {
+		fmt.Printf("  sent   %s\n", e.ID)
+	}
+
+	for _, f := range report.Failed {
+		fmt.Printf("  failed %s: %v\n", f.Entry.ID, f.Err)
+	}
+
+	for _, f := range report.GaveUp {
+		fmt.Printf("  gave up %s: %v\n", f.Entry.ID, f.Err)
+	}
+
+	fmt.Println(report)
+}
+
+func RunQueueDrop(cmd *cobra.Command, args []string) {
+	q := mail.NewQueue(mail.DefaultQueuePath())
+
+	failed := false
+	for _, id := range args {
+		err := q.Drop(id)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			failed = true
+			continue
+		}
+
+		fmt.Printf("  dropped %s\n", id)
+	}
+
+	if failed {
+		os.Exit(1)
+	}
+}
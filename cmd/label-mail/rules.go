@@ -0,0 +1,90 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
+
+	"github.com/zostay/dotfiles-go/internal/mail"
+)
+
+func RunRulesStats(cmd *cobra.Command, args []string) {
+	filter := newFilter()
+	rules := filter.AllRules()
+
+	items, err := filter.RuleStats().Report(rules)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	lastHit := func(t time.Time) string {
+		if t.IsZero() {
+			return "never"
+		}
+		return t.Local().Format("2006-01-02 15:04")
+	}
+
+	fmt.Printf("%7s  %7s  %-16s  %s\n", "MATCHED", "ACTED", "LAST HIT", "RULE")
+	for _, item := range items {
+		fmt.Printf("%7d  %7d  %-16s  %s\n", item.Matched, item.Acted, lastHit(item.LastMatched), item.Rule)
+	}
+
+	fmt.Printf("Found %d rules.\n", len(items))
+
+	if unusedDays <= 0 {
+		return
+	}
+
+	since := time.Now().Add(-time.Duration(unusedDays) * 24 * time.Hour)
+	unused, err := filter.RuleStats().Unused(rules, since)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	if len(unused) == 0 {
+		return
+	}
+
+	fmt.Printf("\nRules with no matches in %d days:\n", unusedDays)
+	for _, item := range unused {
+		fmt.Printf("  %-16s  %s\n", lastHit(item.LastMatched), item.Rule)
+	}
+}
+
+func RunRulesSuggest(cmd *cobra.Command, args []string) {
+	filter := newFilter()
+
+	suggestions, err := filter.SuggestRules(suggestMinSupport, suggestMinConfidence)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	for _, s := range suggestions {
+		bs, err := yaml.Marshal([]*mail.RawRule{s.Rule})
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+
+		fmt.Printf("# %s\n%s\n", s, bs)
+
+		if appendSuggestions && !dryRun {
+			err := mail.AppendRawRule(localRulesFile, s.Rule)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+		}
+	}
+
+	fmt.Printf("Found %d suggested rules.\n", len(suggestions))
+	if appendSuggestions && !dryRun && len(suggestions) > 0 {
+		fmt.Printf("Added them to %s.\n", localRulesFile)
+	}
+}
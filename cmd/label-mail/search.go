@@ -0,0 +1,50 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"github.com/zostay/dotfiles-go/internal/mail"
+)
+
+func RunSearch(cmd *cobra.Command, args []string) {
+	filter := newFilter()
+
+	q, err := mail.ParseSearch(args...)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	msgs, err := filter.Search(q, folders)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	for _, m := range msgs {
+		date := "unknown date"
+		if d, err := m.Date(); err == nil {
+			date = d.Local().Format("2006-01-02 15:04")
+		}
+
+		from := ""
+		if addrs, err := m.AddressList("From"); err == nil && len(addrs) > 0 {
+			from = addrs.Flatten()[0].Address()
+		}
+
+		subject, _ := m.Subject()
+		keywords, _ := m.Keywords()
+
+		fmt.Printf("%-16s  %-30s  %s\n", date, from, subject)
+		fmt.Printf("    %s\n", m.Filename())
+		if len(keywords) > 0 {
+			fmt.Printf("    Keywords: %s\n", strings.Join(keywords, ", "))
+		}
+	}
+
+	fmt.Printf("Found %d messages.\n", len(msgs))
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/zostay/dotfiles-go/internal/mail"
+)
+
+// statsKeywords is the number of keywords listed in the stats table.
+const statsKeywords = 20
+
+// formatSize returns the size in bytes in a short, human readable form.
+func formatSize(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%dB", n)
+	}
+
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+
+	return fmt.Sprintf("%.1f%c", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+// formatDate returns the date of a message or a dash if there is none.
+func formatDate(t *time.Time) string {
+	if t == nil {
+		return "-"
+	}
+	return t.Local().Format("2006-01-02")
+}
+
+func RunStats(cmd *cobra.Command, args []string) {
+	filter := newFilter()
+
+	stats, err := filter.Stats(folders)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	if statsJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(stats); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	row := func(name string, fs *mail.FolderStats) {
+		fmt.Printf("%-30s  %7d  %7d  %7d  %7d  %8s  %-10s  %-10s  %9d\n",
+			name, fs.Total, fs.New, fs.Cur, fs.Unread, formatSize(fs.Size),
+			formatDate(fs.Oldest), formatDate(fs.Newest), len(fs.Anomalies))
+	}
+
+	fmt.Printf("%-30s  %7s  %7s  %7s  %7s  %8s  %-10s  %-10s  %9s\n",
+		"FOLDER", "TOTAL", "NEW", "CUR", "UNREAD", "SIZE", "OLDEST", "NEWEST", "ANOMALIES")
+	for _, fs := range stats.Folders {
+		row(fs.Folder, fs)
+	}
+	row("TOTAL", stats.Totals)
+
+	if kcs := stats.Totals.KeywordCounts(); len(kcs) > 0 {
+		fmt.Println("\nKeywords:")
+		for i, kc := range kcs {
+			if i == statsKeywords {
+				fmt.Printf("  ... and %d more\n", len(kcs)-i)
+				break
+			}
+			fmt.Printf("  %7d  %s\n", kc.Count, kc.Keyword)
+		}
+	}
+
+	if len(stats.Totals.Anomalies) > 0 {
+		fmt.Println("\nAnomalies:")
+		for _, a := range stats.Totals.Anomalies {
+			fmt.Printf("  %s\n", a)
+		}
+	}
+}
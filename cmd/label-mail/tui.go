@@ -0,0 +1,422 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/mattn/go-runewidth"
+	"github.com/nsf/termbox-go"
+	"github.com/spf13/cobra"
+
+	"github.com/zostay/dotfiles-go/internal/mail"
+)
+
+// tuiRow is the summary of a message shown in the message list.
+type tuiRow struct {
+	msg     *mail.Message
+	date    string
+	from    string
+	subject string
+	listID  string
+	matched []string // descriptions of the matching rules, found on first use
+	checked bool     // set once matched has been found
+}
+
+// tuiField is one line of the new rule form.
+type tuiField struct {
+	name    string // the name of the field in the rules file
+	value   string
+	enabled bool
+}
+
+// tui holds the state of the label-mail tui command.
+type tui struct {
+	filter *mail.Filter
+	folder string
+
+	rows     []*tuiRow
+	sel, top int
+
+	form     []*tuiField // the new rule form, when editing
+	formSel  int
+	status   string
+	quitting bool
+}
+
+func RunTUI(cmd *cobra.Command, args []string) {
+	folder := "INBOX"
+	if len(args) > 0 {
+		folder = args[0]
+	} else if len(folders) > 0 {
+		folder = folders[0]
+	}
+
+	t := &tui{folder: folder}
+	if err := t.load(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	if err := termbox.Init(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	defer termbox.Close()
+
+	for !t.quitting {
+		t.draw()
+
+		ev := termbox.PollEvent()
+		switch ev.Type {
+		case termbox.EventKey:
+			if t.form != nil {
+				t.editKey(ev)
+			} else {
+				t.browseKey(ev)
+			}
+		case termbox.EventError:
+			t.status = ev.Err.Error()
+		}
+	}
+}
+
+// load reads the rules and the recent messages in the folder.
+func (t *tui) load() error {
+	filter, err := loadFilter()
+	if err != nil {
+		return err
+	}
+
+	msgs, err := filter.RecentMessages(t.folder, tuiLimit)
+	if err != nil {
+		return err
+	}
+
+	rows := make([]*tuiRow, len(msgs))
+	for i, m := range msgs {
+		row := &tuiRow{msg: m}
+		if date, err := m.Date(); err == nil {
+			row.date = date.Local().Format("2006-01-02 15:04")
+		}
+		if from, err := m.AddressList("From"); err == nil && len(from) > 0 {
+			row.from = from.Flatten()[0].Address()
+		}
+		row.subject, _ = m.Subject()
+		row.listID, _ = m.ListID()
+		rows[i] = row
+	}
+
+	t.filter = filter
+	t.rows = rows
+	if t.sel >= len(rows) {
+		t.sel = 0
+	}
+
+	return nil
+}
+
+// selected returns the selected row or nil if the folder is empty.
+func (t *tui) selected() *tuiRow {
+	if t.sel < len(t.rows) {
+		return t.rows[t.sel]
+	}
+	return nil
+}
+
+// matches returns the descriptions of the rules matching the row's message.
+func (t *tui) matches(row *tuiRow) []string {
+	if row.checked {
+		return row.matched
+	}
+
+	row.checked = true
+	rules, err := t.filter.MatchingRules(row.msg)
+	if err != nil {
+		row.matched = []string{"error: " + err.Error()}
+		return row.matched
+	}
+
+	for _, r := range rules {
+		row.matched = append(row.matched, r.Describe())
+	}
+	return row.matched
+}
+
+// browseKey handles a key press while browsing the message list.
+func (t *tui) browseKey(ev termbox.Event) {
+	_, h := termbox.Size()
+	page := t.listHeight(h)
+
+	t.status = ""
+	switch {
+	case ev.Ch == 'q' || ev.Key == termbox.KeyEsc || ev.Key == termbox.KeyCtrlC:
+		t.quitting = true
+	case ev.Ch == 'j' || ev.Key == termbox.KeyArrowDown:
+		t.move(1)
+	case ev.Ch == 'k' || ev.Key == termbox.KeyArrowUp:
+		t.move(-1)
+	case ev.Key == termbox.KeyPgdn || ev.Key == termbox.KeySpace:
+		t.move(page)
+	case ev.Key == termbox.KeyPgup:
+		t.move(-page)
+	case ev.Ch == 'r':
+		if err := t.load(); err != nil {
+			t.status = err.Error()
+		} else {
+			t.status = "Reloaded."
+		}
+	case ev.Ch == 'n' || ev.Key == termbox.KeyEnter:
+		t.startForm()
+	}
+}
+
+// move changes the selected message by n rows.
+func (t *tui) move(n int) {
+	t.sel += n
+	if t.sel >= len(t.rows) {
+		t.sel = len(t.rows) - 1
+	}
+	if t.sel < 0 {
+		t.sel = 0
+	}
+}
+
+// startForm opens the new rule form prefilled from the selected message.
+func (t *tui) startForm() {
+	row := t.selected()
+	if row == nil {
+		return
+	}
+
+	r, err := mail.RuleFromMessage(row.msg)
+	if err != nil {
+		t.status = err.Error()
+		return
+	}
+
+	t.form = []*tuiField{
+		{"folder", t.folder, false},
+		{"from", row.from, r.From != ""},
+		{"subject", row.subject, false},
+		{"list_id", row.listID, r.ListID != ""},
+		{"label", "", true},
+		{"move", "", false},
+	}
+	t.formSel = len(t.form) - 2
+}
+
+// editKey handles a key press while editing the new rule form.
+func (t *tui) editKey(ev termbox.Event) {
+	f := t.form[t.formSel]
+
+	t.status = ""
+	switch ev.Key {
+	case termbox.KeyEsc, termbox.KeyCtrlC:
+		t.form = nil
+	case termbox.KeyArrowDown:
+		t.formSel = (t.formSel + 1) % len(t.form)
+	case termbox.KeyArrowUp:
+		t.formSel = (t.formSel + len(t.form) - 1) % len(t.form)
+	case termbox.KeyTab:
+		f.enabled = !f.enabled
+	case termbox.KeyBackspace, termbox.KeyBackspace2:
+		if rs := []rune(f.value); len(rs) > 0 {
+			f.value = string(rs[:len(rs)-1])
+		}
+	case termbox.KeyCtrlU:
+		f.value = ""
+	case termbox.KeyEnter:
+		t.saveForm()
+	case termbox.KeySpace:
+		f.value += " "
+		f.enabled = true
+	default:
+		if ev.Ch != 0 {
+			f.value += string(ev.Ch)
+			f.enabled = true
+		}
+	}
+}
+
+// saveForm appends the rule in the form to the local rules file and reloads
+// the rules.
+func (t *tui) saveForm() {
+	r := &mail.RawRule{}
+	matches, actions := 0, 0
+	for _, f := range t.form {
+		v := strings.TrimSpace(f.value)
+		if !f.enabled || v == "" {
+			continue
+		}
+
+		switch f.name {
+		case "folder":
+			r.Folder = v
+		case "from":
+			r.From = v
+		case "subject":
+			r.Subject = v
+		case "list_id":
+			r.ListID = v
+		case "label":
+			var labels []string
+			for _, l := range strings.Split(v, ",") {
+				if l = strings.TrimSpace(l); l != "" {
+					labels = append(labels, l)
+				}
+			}
+			r.Label = labels
+			actions++
+			continue
+		case "move":
+			r.Move = v
+			actions++
+			continue
+		}
+		matches++
+	}
+
+	if matches == 0 || actions == 0 {
+		t.status = "A rule needs at least one match and one action."
+		return
+	}
+
+	if err := mail.AppendRawRule(localRulesFile, r); err != nil {
+		t.status = err.Error()
+		return
+	}
+
+	t.form = nil
+	if err := t.load(); err != nil {
+		t.status = err.Error()
+		return
+	}
+
+	t.status = "Added rule to " + localRulesFile
+}
+
+// listHeight returns the number of message rows shown for a screen of height h.
+func (t *tui) listHeight(h int) int {
+	lh := h / 2
+	if lh < 1 {
+		lh = 1
+	}
+	return lh
+}
+
+// tuiPrint writes the text at the given position, cut off at the given width.
+func tuiPrint(x, y, width int, fg, bg termbox.Attribute, text string) {
+	for _, c := range text {
+		cw := runewidth.RuneWidth(c)
+		if cw > width {
+			break
+		} else if cw == 0 {
+			continue
+		}
+		termbox.SetCell(x, y, c, fg, bg)
+		x += cw
+		width -= cw
+	}
+	for ; width > 0; width-- {
+		termbox.SetCell(x, y, ' ', fg, bg)
+		x++
+	}
+}
+
+// draw paints the screen.
+func (t *tui) draw() {
+	_ = termbox.Clear(termbox.ColorDefault, termbox.ColorDefault)
+	w, h := termbox.Size()
+
+	tuiPrint(0, 0, w, termbox.ColorBlack, termbox.ColorWhite,
+		fmt.Sprintf(" label-mail: %s (%d messages)", t.folder, len(t.rows)))
+
+	lh := t.listHeight(h)
+	if t.sel < t.top {
+		t.top = t.sel
+	}
+	if t.sel >= t.top+lh {
+		t.top = t.sel - lh + 1
+	}
+
+	for i := 0; i < lh && t.top+i < len(t.rows); i++ {
+		row := t.rows[t.top+i]
+		fg, bg := termbox.ColorDefault, termbox.ColorDefault
+		if t.top+i == t.sel {
+			fg, bg = termbox.ColorBlack, termbox.ColorCyan
+		}
+		tuiPrint(0, 1+i, w, fg, bg, fmt.Sprintf(" %-16s  %-30.30s  %s", row.date, row.from, row.subject))
+	}
+
+	y := lh + 1
+	tuiPrint(0, y, w, termbox.ColorBlack, termbox.ColorWhite, "")
+	y++
+
+	if t.form != nil {
+		t.drawForm(y, w)
+	} else if row := t.selected(); row != nil {
+		detail := func(name, value string) {
+			if y < h-1 {
+				tuiPrint(0, y, w, termbox.ColorDefault, termbox.ColorDefault, fmt.Sprintf(" %-9s %s", name+":", value))
+				y++
+			}
+		}
+
+		detail("From", row.from)
+		detail("Subject", row.subject)
+		detail("Date", row.date)
+		if row.listID != "" {
+			detail("List-Id", row.listID)
+		}
+
+		matched := t.matches(row)
+		if len(matched) == 0 {
+			detail("Rules", "none match")
+		}
+		for i, m := range matched {
+			name := ""
+			if i == 0 {
+				name = "Rules"
+			}
+			detail(name, m)
+		}
+	}
+
+	help := " ↑/↓ select  n new rule  r reload  q quit"
+	if t.form != nil {
+		help = " ↑/↓ field  type to edit  Tab toggle  Enter save  Esc cancel"
+	}
+	if t.status != "" {
+		help = " " + t.status
+	}
+	tuiPrint(0, h-1, w, termbox.ColorBlack, termbox.ColorWhite, help)
+
+	_ = termbox.Flush()
+}
+
+// drawForm paints the new rule form starting at line y.
+func (t *tui) drawForm(y, w int) {
+	tuiPrint(0, y, w, termbox.ColorDefault, termbox.ColorDefault, " New rule for "+localRulesFile)
+	y += 2
+
+	termbox.HideCursor()
+	for i, f := range t.form {
+		check := "[ ]"
+		if f.enabled {
+			check = "[x]"
+		}
+
+		fg := termbox.ColorDefault
+		if i == t.formSel {
+			fg = termbox.ColorCyan | termbox.AttrBold
+		}
+
+		line := fmt.Sprintf(" %s %-8s %s", check, f.name+":", f.value)
+		tuiPrint(0, y, w, fg, termbox.ColorDefault, line)
+		if i == t.formSel {
+			termbox.SetCursor(runewidth.StringWidth(line), y)
+		}
+		y++
+	}
+}
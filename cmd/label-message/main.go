@@ -8,6 +8,7 @@ import (
 
 	"github.com/spf13/cobra"
 
+	"github.com/zostay/dotfiles-go/internal/dotfiles"
 	"github.com/zostay/dotfiles-go/internal/mail"
 )
 
@@ -19,6 +20,7 @@ var (
 	verbose        int
 	dryRun         bool
 	allowSending   bool
+	environments   []string
 )
 
 func init() {
@@ -35,6 +37,7 @@ func init() {
 	cmd.PersistentFlags().BoolVarP(&dryRun, "dry-run", "d", false, "perform a dry run")
 	cmd.PersistentFlags().CountVarP(&verbose, "verbose", "v", "enable debugging verbose mode")
 	cmd.PersistentFlags().BoolVarP(&allowSending, "allow-forwarding", "e", false, "allow email forwarding rules to run")
+	cmd.PersistentFlags().StringSliceVar(&environments, "env", []string{}, "select the rule environments to use instead of the configured ones")
 }
 
 func RunLabelMessage(cmd *cobra.Command, args []string) {
@@ -42,12 +45,17 @@ func RunLabelMessage(cmd *cobra.Command, args []string) {
 		panic(errors.New("maildir did not work"))
 	}
 
+	if len(environments) > 0 {
+		dotfiles.OverrideEnvironments(environments...)
+	}
+
 	filter, err := mail.NewFilter(mailDir, rulesFile, localRulesFile)
 	if err != nil {
 		panic(err)
 	}
 
 	filter.SetDryRun(dryRun)
+	filter.SetAllowSendingEmail(allowSending)
 	filter.SetDebugLevel(verbose)
 
 	if verbose > 3 {
@@ -62,6 +70,11 @@ func RunLabelMessage(cmd *cobra.Command, args []string) {
 		fmt.Fprintln(os.Stderr, err)
 	}
 
+	err = filter.SaveRuleStats()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+	}
+
 	fmt.Print(actions)
 }
 
@@ -11,6 +11,7 @@ require (
 	github.com/emersion/go-smtp v0.14.0
 	github.com/fatih/color v1.9.0
 	github.com/kr/pretty v0.3.1
+	github.com/mattn/go-runewidth v0.0.10
 	github.com/mitchellh/go-wordwrap v1.0.1
 	github.com/nsf/termbox-go v1.1.1
 	github.com/spf13/cobra v1.7.0
@@ -36,7 +37,6 @@ require (
 	github.com/kr/text v0.2.0 // indirect
 	github.com/mattn/go-colorable v0.1.7 // indirect
 	github.com/mattn/go-isatty v0.0.12 // indirect
-	github.com/mattn/go-runewidth v0.0.10 // indirect
 	github.com/mitchellh/go-homedir v1.1.0 // indirect
 	github.com/mitchellh/mapstructure v1.5.0 // indirect
 	github.com/niemeyer/pretty v0.0.0-20200227124842-a10e7caefd8e // indirect
@@ -1,6 +1,8 @@
 package dotfiles
 
 import (
+	"bufio"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"os"
@@ -10,10 +12,21 @@ import (
 
 const (
 	EnvFile = ".dotfile-environment"
+
+	// EnvVar is the name of the environment variable that, when set, overrides
+	// the environments named in the EnvFile.
+	EnvVar = "DOTFILE_ENVIRONMENT"
 )
 
 var (
 	HomeDir string
+
+	// override holds the environments set by OverrideEnvironments.
+	override []string
+
+	// hostname returns the name of this host. It is a variable so tests may
+	// replace it.
+	hostname = os.Hostname
 )
 
 func init() {
@@ -41,11 +54,113 @@ func SetEnvironment(env string) error {
 	return nil
 }
 
+// OverrideEnvironments causes Environments to return the given environments
+// instead of consulting the EnvVar or the EnvFile. Passing no environments
+// removes the override.
+func OverrideEnvironments(envs ...string) {
+	override = envs
+}
+
+// Environment returns the first of the active environments.
 func Environment() (string, error) {
-	bs, err := ioutil.ReadFile(path.Join(HomeDir, EnvFile))
+	envs, err := Environments()
 	if err != nil {
 		return "", err
 	}
 
-	return strings.TrimSpace(string(bs)), err
+	if len(envs) == 0 {
+		return "", errors.New("no environment is set in " + path.Join(HomeDir, EnvFile))
+	}
+
+	return envs[0], nil
+}
+
+// Environments returns the names of the active environments. These come from
+// OverrideEnvironments, if set, or else from the EnvVar, if set, or else from
+// the EnvFile.
+//
+// The EnvVar and each line of the EnvFile list environment names separated by
+// spaces or commas. In the EnvFile, a line may begin with a hostname pattern
+// followed by a colon, e.g., "*.corp.example.com: work", in which case the
+// names on that line are only active when the hostname matches. Blank lines and
+// lines starting with "#" are ignored.
+func Environments() ([]string, error) {
+	if len(override) > 0 {
+		return override, nil
+	}
+
+	if v := os.Getenv(EnvVar); v != "" {
+		return splitEnvironments(v), nil
+	}
+
+	bs, err := ioutil.ReadFile(path.Join(HomeDir, EnvFile))
+	if err != nil {
+		return nil, err
+	}
+
+	return parseEnvironments(string(bs))
+}
+
+// parseEnvironments reads the active environments from the content of the
+// EnvFile.
+func parseEnvironments(content string) ([]string, error) {
+	var (
+		envs []string
+		host string
+	)
+
+	s := bufio.NewScanner(strings.NewReader(content))
+	for s.Scan() {
+		line := strings.TrimSpace(s.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		if pattern, names, ok := strings.Cut(line, ":"); ok {
+			if host == "" {
+				h, err := hostname()
+				if err != nil {
+					return nil, fmt.Errorf("unable to determine hostname: %w", err)
+				}
+				host = strings.ToLower(h)
+			}
+
+			matched, err := path.Match(strings.ToLower(strings.TrimSpace(pattern)), host)
+			if err != nil {
+				return nil, fmt.Errorf("bad hostname pattern %q: %w", pattern, err)
+			}
+
+			if !matched {
+				continue
+			}
+
+			line = names
+		}
+
+		for _, env := range splitEnvironments(line) {
+			if !contains(envs, env) {
+				envs = append(envs, env)
+			}
+		}
+	}
+
+	return envs, s.Err()
+}
+
+// splitEnvironments splits a list of environment names separated by spaces or
+// commas.
+func splitEnvironments(s string) []string {
+	return strings.FieldsFunc(s, func(c rune) bool {
+		return c == ',' || c == ' ' || c == '\t'
+	})
+}
+
+// contains returns true if the string is in the list.
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
 }
@@ -0,0 +1,41 @@
+package dotfiles
+
+import (
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseEnvironments(t *testing.T) {
+	hostname = func() (string, error) { return "Laptop.corp.example.com", nil }
+	defer func() { hostname = os.Hostname }()
+
+	envs, err := parseEnvironments(`
+# always
+home, laptop
+*.corp.example.com: work laptop
+build-*: ci
+`)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"home", "laptop", "work"}, envs)
+
+	_, err = parseEnvironments("[: work\n")
+	assert.Error(t, err)
+}
+
+func TestEnvironments_Override(t *testing.T) {
+	t.Setenv(EnvVar, "work,laptop")
+
+	envs, err := Environments()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"work", "laptop"}, envs)
+
+	OverrideEnvironments("home")
+	defer OverrideEnvironments()
+
+	env, err := Environment()
+	require.NoError(t, err)
+	assert.Equal(t, "home", env)
+}
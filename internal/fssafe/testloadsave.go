@@ -3,6 +3,7 @@ package fssafe
 import (
 	"bytes"
 	"io"
+	"os"
 )
 
 type TestingReader struct {
@@ -31,6 +32,10 @@ func NewTestingLoaderSaver() *TestingLoaderSaver {
 
 	var buf *bytes.Buffer
 	loader := func() (io.ReadCloser, error) {
+		if buf == nil {
+			return nil, os.ErrNotExist
+		}
+
 		r := &TestingReader{bytes.NewReader(buf.Bytes()), false}
 		rs = append(rs, r)
 		return r, nil
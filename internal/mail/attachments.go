@@ -0,0 +1,138 @@
+package mail
+
+import (
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/zostay/go-email/v2/message"
+	"github.com/zostay/go-email/v2/message/walk"
+)
+
+// Attachment describes a single attachment found in a message.
+type Attachment struct {
+	Filename  string // the file name of the attachment, if it has one
+	MediaType string // the media type of the attachment, in lowercase
+}
+
+// isAttachment returns the description of the given part and true if the part
+// is an attachment. A part is an attachment if it is marked as an attachment
+// by its Content-disposition or if it provides a file name.
+func isAttachment(part message.Part) (Attachment, bool) {
+	h := part.GetHeader()
+
+	var a Attachment
+	if ct, err := h.GetContentType(); err == nil {
+		a.MediaType = strings.ToLower(ct.MediaType())
+		a.Filename = ct.Parameter("name")
+	}
+
+	presentation := ""
+	if cd, err := h.GetContentDisposition(); err == nil {
+		presentation = strings.ToLower(cd.Presentation())
+		if fn := cd.Filename(); fn != "" {
+			a.Filename = fn
+		}
+	}
+
+	if presentation == "attachment" || a.Filename != "" || a.MediaType == "message/rfc822" {
+		return a, true
+	}
+
+	return a, false
+}
+
+// Attachments returns a description of every attachment found anywhere in the
+// message. The result is cached.
+func (m *Message) Attachments() ([]Attachment, error) {
+	if m.attachments != nil {
+		return m.attachments, nil
+	}
+
+	mm, err := m.MultipartEmailMessage()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read message to find attachments: %w", err)
+	}
+
+	as := make([]Attachment, 0)
+	err = walk.AndProcess(
+		func(part message.Part, parents []message.Part) error {
+			// the message itself is never an attachment
+			if len(parents) == 0 || part.IsMultipart() {
+				return nil
+			}
+
+			if a, ok := isAttachment(part); ok {
+				as = append(as, a)
+			}
+
+			return nil
+		}, mm,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to walk message parts to find attachments: %w", err)
+	}
+
+	m.attachments = as
+	return as, nil
+}
+
+// matchGlobFold returns true if the name matches the glob pattern (as
+// understood by path.Match) without regard to case.
+func matchGlobFold(pattern, name string) bool {
+	ok, err := path.Match(strings.ToLower(pattern), strings.ToLower(name))
+	return err == nil && ok
+}
+
+// ByteSize is a size in bytes. In configuration, it may be given as a plain
+// number of bytes or as a number with a unit suffix, such as "512K", "10MB",
+// or "1G". Units are powers of 1024.
+type ByteSize int64
+
+// byteUnits maps unit suffixes to their multipliers.
+var byteUnits = map[string]ByteSize{
+	"":   1,
+	"b":  1,
+	"k":  1 << 10,
+	"kb": 1 << 10,
+	"m":  1 << 20,
+	"mb": 1 << 20,
+	"g":  1 << 30,
+	"gb": 1 << 30,
+}
+
+// ParseByteSize parses a size in bytes with an optional unit suffix.
+func ParseByteSize(s string) (ByteSize, error) {
+	s = strings.TrimSpace(s)
+	i := strings.IndexFunc(s, func(c rune) bool { return !unicode.IsDigit(c) })
+	if i < 0 {
+		i = len(s)
+	}
+
+	n, err := strconv.ParseInt(s[:i], 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid byte size %q: %w", s, err)
+	}
+
+	unit := strings.ToLower(strings.TrimSpace(s[i:]))
+	mult, ok := byteUnits[unit]
+	if !ok {
+		return 0, fmt.Errorf("invalid byte size %q: unknown unit %q", s, unit)
+	}
+
+	return ByteSize(n) * mult, nil
+}
+
+// UnmarshalYAML parses the ByteSize from configuration.
+func (b *ByteSize) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var s string
+	err := unmarshal(&s)
+	if err != nil {
+		return err
+	}
+
+	*b, err = ParseByteSize(s)
+	return err
+}
@@ -0,0 +1,122 @@
+package mail
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v3"
+)
+
+const invoiceMessage = `From: billing@example.com
+Subject: Your invoice
+MIME-Version: 1.0
+Content-Type: multipart/mixed; boundary="XXX"
+
+--XXX
+Content-Type: text/plain; charset=utf-8
+
+Your invoice is attached.
+--XXX
+Content-Type: application/pdf; name="Invoice-42.pdf"
+Content-Disposition: attachment; filename="Invoice-42.pdf"
+Content-Transfer-Encoding: base64
+
+JVBERi0xLjQK
+--XXX--
+`
+
+func TestMessage_Attachments(t *testing.T) {
+	t.Parallel()
+
+	_, root := mkTempFilter(t)
+	writeTestMessage(t, root, "INBOX", "30:2,S", invoiceMessage)
+
+	msg, err := NewMailDirFolder(root, "INBOX").Message("30:2,S")
+	require.NoError(t, err)
+
+	as, err := msg.Attachments()
+	require.NoError(t, err)
+	assert.Equal(t, []Attachment{{"Invoice-42.pdf", "application/pdf"}}, as)
+
+	msg, err = NewMailDirFolder(root, "INBOX").Message("1:2,S")
+	require.NoError(t, err)
+
+	as, err = msg.Attachments()
+	require.NoError(t, err)
+	assert.Empty(t, as)
+}
+
+func TestFilter_ApplyRule_Attachments(t *testing.T) {
+	t.Parallel()
+
+	f, root := mkTempFilter(t)
+	f.SetDryRun(true)
+	writeTestMessage(t, root, "INBOX", "30:2,S", invoiceMessage)
+
+	invoice, err := f.Message("INBOX", "30:2,S")
+	require.NoError(t, err)
+
+	plain, err := f.Message("INBOX", "1:2,S")
+	require.NoError(t, err)
+
+	yes, no := true, false
+	tests := []struct {
+		name    string
+		match   Match
+		invoice bool
+		plain   bool
+	}{
+		{"has attachment", Match{HasAttachment: &yes}, true, false},
+		{"no attachment", Match{HasAttachment: &no}, false, true},
+		{"attachment name", Match{AttachmentName: "*.PDF"}, true, false},
+		{"attachment name mismatch", Match{AttachmentName: "*.doc"}, false, false},
+		{"attachment type", Match{AttachmentType: "application/pdf"}, true, false},
+		{"attachment type glob", Match{AttachmentType: "image/*"}, false, false},
+		{"size greater", Match{SizeGreater: 300}, true, false},
+		{"size less", Match{SizeLess: 300}, false, true},
+	}
+
+	for _, test := range tests {
+		cr := &CompiledRule{Match: test.match, Label: []string{"Test"}}
+
+		actions, err := f.ApplyRule(invoice, cr)
+		assert.NoError(t, err)
+		assert.Equal(t, test.invoice, len(actions) > 0, "invoice %s", test.name)
+
+		actions, err = f.ApplyRule(plain, cr)
+		assert.NoError(t, err)
+		assert.Equal(t, test.plain, len(actions) > 0, "plain %s", test.name)
+	}
+}
+
+func TestParseByteSize(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]ByteSize{
+		"42":     42,
+		"512K":   512 * 1024,
+		"10 MB":  10 * 1024 * 1024,
+		"1g":     1024 * 1024 * 1024,
+		"100b":   100,
+		" 7kb  ": 7 * 1024,
+	}
+
+	for in, expect := range tests {
+		got, err := ParseByteSize(in)
+		assert.NoError(t, err, in)
+		assert.Equal(t, expect, got, in)
+	}
+
+	_, err := ParseByteSize("10 parsecs")
+	assert.Error(t, err)
+
+	_, err = ParseByteSize("MB")
+	assert.Error(t, err)
+
+	var m Match
+	err = yaml.Unmarshal([]byte("size_greater: 10MB\nsize_less: 4096\n"), &m)
+	require.NoError(t, err)
+	assert.Equal(t, ByteSize(10*1024*1024), m.SizeGreater)
+	assert.Equal(t, ByteSize(4096), m.SizeLess)
+}
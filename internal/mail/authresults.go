@@ -0,0 +1,387 @@
+package mail
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+const (
+	// AuthenticationResultsHeader is the header added by a receiving mail
+	// server to report the results of message authentication (RFC 8601).
+	AuthenticationResultsHeader = "Authentication-Results"
+
+	// ARCAuthenticationResultsHeader is the copy of the Authentication-Results
+	// header sealed into the ARC chain by each intermediary (RFC 8617).
+	ARCAuthenticationResultsHeader = "ARC-Authentication-Results"
+)
+
+// AuthResult is the result of a single authentication method reported in an
+// Authentication-Results header, e.g.,
+//
+//	dkim=pass header.d=example.com header.s=sel
+type AuthResult struct {
+	// Method is the authentication method, such as "dkim", "spf", "dmarc",
+	// or "arc". It is always lowercase.
+	Method string
+
+	// Result is the result of the method, such as "pass", "fail", or
+	// "softfail". It is always lowercase.
+	Result string
+
+	// Reason is the reason given for the result, if any.
+	Reason string
+
+	// Properties holds the properties reported with the result, keyed by
+	// "ptype.property" in lowercase, e.g., "header.d" or "smtp.mailfrom".
+	Properties map[string]string
+}
+
+// Domain returns the domain the result applies to, which depends on the
+// method. For DKIM, it is the signing domain. For SPF, it is the domain of the
+// envelope sender (or the HELO name). For DMARC, it is the domain of the From
+// header. It returns an empty string if no domain is reported.
+func (r *AuthResult) Domain() string {
+	var keys []string
+	switch r.Method {
+	case "dkim":
+		keys = []string{"header.d", "header.i"}
+	case "spf":
+		keys = []string{"smtp.mailfrom", "smtp.helo"}
+	case "dmarc":
+		keys = []string{"header.from"}
+	default:
+		return ""
+	}
+
+	for _, k := range keys {
+		v := r.Properties[k]
+		if i := strings.LastIndexByte(v, '@'); i >= 0 {
+			v = v[i+1:]
+		}
+		if v != "" {
+			return strings.ToLower(v)
+		}
+	}
+
+	return ""
+}
+
+// AuthenticationResults is a parsed Authentication-Results or
+// ARC-Authentication-Results header.
+type AuthenticationResults struct {
+	// Instance is the ARC instance number. It is zero for an
+	// Authentication-Results header.
+	Instance int
+
+	// AuthServID identifies the server that performed the authentication.
+	AuthServID string
+
+	// Results lists the result of each method reported.
+	Results []AuthResult
+}
+
+// ParseAuthenticationResults parses the value of an Authentication-Results
+// header as described in RFC 8601.
+func ParseAuthenticationResults(v string) (*AuthenticationResults, error) {
+	return parseAuthenticationResults(v, false)
+}
+
+// ParseARCAuthenticationResults parses the value of an
+// ARC-Authentication-Results header, which is an Authentication-Results value
+// preceded by an ARC instance tag, as described in RFC 8617.
+func ParseARCAuthenticationResults(v string) (*AuthenticationResults, error) {
+	return parseAuthenticationResults(v, true)
+}
+
+func parseAuthenticationResults(v string, arc bool) (*AuthenticationResults, error) {
+	segs := splitAuthResults(stripAuthResultsComments(v), ';')
+
+	ar := &AuthenticationResults{}
+	if arc {
+		if len(segs) == 0 {
+			return nil, fmt.Errorf("missing ARC instance in %q", v)
+		}
+
+		tag := strings.TrimSpace(segs[0])
+		if !strings.HasPrefix(strings.ToLower(tag), "i=") {
+			return nil, fmt.Errorf("missing ARC instance in %q", v)
+		}
+
+		i, err := strconv.Atoi(strings.TrimSpace(tag[2:]))
+		if err != nil {
+			return nil, fmt.Errorf("bad ARC instance in %q: %w", v, err)
+		}
+
+		ar.Instance = i
+		segs = segs[1:]
+	}
+
+	if len(segs) == 0 {
+		return nil, fmt.Errorf("missing authserv-id in %q", v)
+	}
+
+	// the authserv-id may be followed by a version number
+	id := splitAuthResults(segs[0], ' ')
+	if len(id) == 0 {
+		return nil, fmt.Errorf("missing authserv-id in %q", v)
+	}
+	ar.AuthServID = unquoteAuthResults(id[0])
+
+	for _, seg := range segs[1:] {
+		toks := splitAuthResults(seg, ' ')
+		if len(toks) == 0 {
+			continue
+		}
+
+		if len(toks) == 1 && strings.EqualFold(toks[0], "none") {
+			continue
+		}
+
+		method, result, ok := strings.Cut(toks[0], "=")
+		if !ok {
+			return nil, fmt.Errorf("bad method result %q in %q", toks[0], v)
+		}
+
+		// drop the method version, if any
+		method, _, _ = strings.Cut(method, "/")
+
+		r := AuthResult{
+			Method:     strings.ToLower(strings.TrimSpace(method)),
+			Result:     strings.ToLower(unquoteAuthResults(result)),
+			Properties: make(map[string]string, len(toks)-1),
+		}
+
+		for _, tok := range toks[1:] {
+			k, pv, ok := strings.Cut(tok, "=")
+			if !ok {
+				continue
+			}
+
+			k = strings.ToLower(strings.TrimSpace(k))
+			pv = unquoteAuthResults(pv)
+			if k == "reason" {
+				r.Reason = pv
+			} else {
+				r.Properties[k] = pv
+			}
+		}
+
+		ar.Results = append(ar.Results, r)
+	}
+
+	return ar, nil
+}
+
+// stripAuthResultsComments removes the parenthesized comments (which may nest)
+// from the header value, leaving quoted strings alone.
+func stripAuthResultsComments(v string) string {
+	var (
+		b      strings.Builder
+		depth  int
+		quoted bool
+		escape bool
+	)
+
+	for _, c := range v {
+		switch {
+		case escape:
+			escape = false
+		case (quoted || depth > 0) && c == '\\':
+			escape = true
+		case depth > 0 && c == '(':
+			depth++
+		case depth > 0 && c == ')':
+			depth--
+			continue
+		case quoted && c == '"':
+			quoted = false
+		case !quoted && depth == 0 && c == '(':
+			depth++
+		case !quoted && depth == 0 && c == '"':
+			quoted = true
+		}
+
+		if depth == 0 {
+			b.WriteRune(c)
+		}
+	}
+
+	return b.String()
+}
+
+// splitAuthResults splits the value on the separator, outside of quoted
+// strings, dropping empty pieces. Splitting on a space splits on any
+// whitespace.
+func splitAuthResults(v string, sep rune) []string {
+	var (
+		parts  []string
+		b      strings.Builder
+		quoted bool
+	)
+
+	isSep := func(c rune) bool {
+		if sep == ' ' {
+			return c == ' ' || c == '\t' || c == '\r' || c == '\n'
+		}
+		return c == sep
+	}
+
+	flush := func() {
+		if s := strings.TrimSpace(b.String()); s != "" {
+			parts = append(parts, s)
+		}
+		b.Reset()
+	}
+
+	for _, c := range v {
+		switch {
+		case c == '"':
+			quoted = !quoted
+		case !quoted && isSep(c):
+			flush()
+			continue
+		}
+		b.WriteRune(c)
+	}
+	flush()
+
+	return parts
+}
+
+// unquoteAuthResults removes the quotes from a quoted string value.
+func unquoteAuthResults(v string) string {
+	v = strings.TrimSpace(v)
+	if len(v) >= 2 && v[0] == '"' && v[len(v)-1] == '"' {
+		v = strings.ReplaceAll(v[1:len(v)-1], `\"`, `"`)
+	}
+	return v
+}
+
+// AuthenticationResults returns the parsed Authentication-Results and
+// ARC-Authentication-Results headers of the message. Headers that cannot be
+// parsed are skipped.
+func (m *Message) AuthenticationResults() ([]*AuthenticationResults, error) {
+	mh, err := m.EmailHeader()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read email header for authentication results: %w", err)
+	}
+
+	var ars []*AuthenticationResults
+	for _, f := range mh.GetAllFieldsNamed(AuthenticationResultsHeader) {
+		if ar, err := ParseAuthenticationResults(f.Body()); err == nil {
+			ars = append(ars, ar)
+		}
+	}
+
+	for _, f := range mh.GetAllFieldsNamed(ARCAuthenticationResultsHeader) {
+		if ar, err := ParseARCAuthenticationResults(f.Body()); err == nil {
+			ars = append(ars, ar)
+		}
+	}
+
+	return ars, nil
+}
+
+// TrustedAuthenticationResults returns the authentication results of the
+// message that can be trusted. Anyone may add these headers before a message
+// reaches the receiving server, so only the topmost Authentication-Results
+// header, the one added last, is trusted. If servID is not empty, the topmost
+// header reported by that authserv-id is trusted instead.
+//
+// The ARC-Authentication-Results header of the highest ARC instance is
+// trusted as well, but only if the trusted header reports that the ARC chain
+// passed validation. If servID is not empty, it must also have been reported by
+// that authserv-id.
+func (m *Message) TrustedAuthenticationResults(servID string) ([]*AuthenticationResults, error) {
+	mh, err := m.EmailHeader()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read email header for authentication results: %w", err)
+	}
+
+	var top *AuthenticationResults
+	for _, f := range mh.GetAllFieldsNamed(AuthenticationResultsHeader) {
+		ar, err := ParseAuthenticationResults(f.Body())
+		if servID == "" {
+			// a topmost header we cannot parse leaves nothing to trust
+			if err == nil {
+				top = ar
+			}
+			break
+		}
+
+		if err == nil && strings.EqualFold(ar.AuthServID, servID) {
+			top = ar
+			break
+		}
+	}
+
+	if top == nil {
+		return nil, nil
+	}
+
+	ars := []*AuthenticationResults{top}
+
+	arcPassed := false
+	for _, r := range top.Results {
+		if r.Method == "arc" && r.Result == "pass" {
+			arcPassed = true
+		}
+	}
+
+	if !arcPassed {
+		return ars, nil
+	}
+
+	var latest *AuthenticationResults
+	for _, f := range mh.GetAllFieldsNamed(ARCAuthenticationResultsHeader) {
+		ar, err := ParseARCAuthenticationResults(f.Body())
+		if err != nil {
+			continue
+		}
+
+		if latest == nil || ar.Instance > latest.Instance {
+			latest = ar
+		}
+	}
+
+	if latest != nil && (servID == "" || strings.EqualFold(latest.AuthServID, servID)) {
+		ars = append(ars, latest)
+	}
+
+	return ars, nil
+}
+
+// HasAuthResult returns true if the message reports a result for the method
+// matching the expected result. If domain is not empty, the result must also
+// apply to that domain or one of its subdomains. Only the results returned by
+// TrustedAuthenticationResults for servID are considered.
+func (m *Message) HasAuthResult(method, result, domain, servID string) (bool, error) {
+	ars, err := m.TrustedAuthenticationResults(servID)
+	if err != nil {
+		return false, err
+	}
+
+	for _, ar := range ars {
+		for _, r := range ar.Results {
+			if r.Method != method || !strings.EqualFold(r.Result, result) {
+				continue
+			}
+
+			if domain != "" && !matchDomain(domain, r.Domain()) {
+				continue
+			}
+
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// matchDomain returns true if got is the expected domain or a subdomain of it.
+func matchDomain(expect, got string) bool {
+	expect = strings.ToLower(strings.TrimPrefix(expect, "."))
+	got = strings.ToLower(got)
+	return got == expect || strings.HasSuffix(got, "."+expect)
+}
@@ -0,0 +1,152 @@
+package mail
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const authResultsMessage = `Message-ID: <statement@bank.example.com>
+Authentication-Results: mx.google.com;
+       dkim=pass header.i=@bank.example.com header.s=sel1 header.b=abc123;
+       spf=pass (google.com: domain of bounce@mail.bank.example.com designates 192.0.2.1 as permitted sender) smtp.mailfrom=bounce@mail.bank.example.com;
+       dmarc=pass (p=REJECT sp=REJECT dis=NONE) header.from=bank.example.com;
+       arc=pass (i=1)
+ARC-Authentication-Results: i=1; lists.example.org; dkim=fail reason="signature \"bad\"" header.d=list.example.org; arc=none
+From: statements@bank.example.com
+To: me@example.com
+Subject: Your statement is ready
+
+Please log in.
+`
+
+func TestParseAuthenticationResults(t *testing.T) {
+	t.Parallel()
+
+	ar, err := ParseAuthenticationResults(`example.com 1; dkim/1=pass (good (nested) sig) header.d="example.com" header.s=x; spf=SoftFail smtp.mailfrom=foo@example.net`)
+	require.NoError(t, err)
+	assert.Equal(t, &AuthenticationResults{
+		AuthServID: "example.com",
+		Results: []AuthResult{
+			{
+				Method:     "dkim",
+				Result:     "pass",
+				Properties: map[string]string{"header.d": "example.com", "header.s": "x"},
+			},
+			{
+				Method:     "spf",
+				Result:     "softfail",
+				Properties: map[string]string{"smtp.mailfrom": "foo@example.net"},
+			},
+		},
+	}, ar)
+	assert.Equal(t, "example.com", ar.Results[0].Domain())
+	assert.Equal(t, "example.net", ar.Results[1].Domain())
+
+	ar, err = ParseAuthenticationResults("example.com; none")
+	require.NoError(t, err)
+	assert.Empty(t, ar.Results)
+
+	ar, err = ParseARCAuthenticationResults(`i=2; example.org; arc=pass reason="chain (ok)"`)
+	require.NoError(t, err)
+	assert.Equal(t, 2, ar.Instance)
+	assert.Equal(t, "example.org", ar.AuthServID)
+	require.Len(t, ar.Results, 1)
+	assert.Equal(t, "chain (ok)", ar.Results[0].Reason)
+
+	_, err = ParseARCAuthenticationResults("example.org; arc=pass")
+	assert.Error(t, err)
+
+	_, err = ParseAuthenticationResults("example.org; dkim")
+	assert.Error(t, err)
+}
+
+func TestFilter_ApplyRule_AuthResults(t *testing.T) {
+	t.Parallel()
+
+	f, root := mkTempFilter(t)
+	f.SetDryRun(true)
+	writeTestMessage(t, root, "INBOX", "40:2,S", authResultsMessage)
+
+	msg, err := f.Message("INBOX", "40:2,S")
+	require.NoError(t, err)
+
+	tests := []struct {
+		match  Match
+		passes bool
+	}{
+		{Match{DKIM: "pass"}, true},
+		{Match{DKIMDomain: "bank.example.com"}, true},
+		{Match{DKIMDomain: "example.com"}, true},
+		{Match{DKIMDomain: "evil.example"}, false},
+		{Match{DKIM: "fail", DKIMDomain: "list.example.org"}, true},
+		{Match{SPF: "pass", SPFDomain: "bank.example.com"}, true},
+		{Match{SPF: "fail"}, false},
+		{Match{DMARC: "pass", DMARCDomain: "bank.example.com"}, true},
+		{Match{ARC: "pass"}, true},
+		{Match{ARC: "none"}, true},
+		{Match{DKIM: "pass", AuthServID: "mx.google.com"}, true},
+		{Match{DKIM: "fail", AuthServID: "mx.google.com"}, false},
+	}
+
+	for _, test := range tests {
+		cr := &CompiledRule{Match: test.match, Label: []string{"Test"}}
+
+		actions, err := f.ApplyRule(msg, cr)
+		assert.NoError(t, err)
+		assert.Equal(t, test.passes, len(actions) > 0, "%+v", test.match)
+	}
+}
+
+const spoofedAuthResultsMessage = `Message-ID: <urgent@evil.example>
+Authentication-Results: mx.google.com;
+       dkim=none;
+       spf=fail smtp.mailfrom=evil.example;
+       dmarc=fail header.from=bank.example.com;
+       arc=none
+Authentication-Results: mx.google.com;
+       dkim=pass header.d=bank.example.com;
+       spf=pass smtp.mailfrom=bank.example.com;
+       dmarc=pass header.from=bank.example.com
+ARC-Authentication-Results: i=2; lists.example.org; dmarc=pass header.from=bank.example.com
+ARC-Authentication-Results: i=1; mx.google.com; dkim=pass header.d=bank.example.com
+From: statements@bank.example.com
+To: me@example.com
+Subject: Verify your account
+
+Please log in.
+`
+
+func TestFilter_ApplyRule_SpoofedAuthResults(t *testing.T) {
+	t.Parallel()
+
+	f, root := mkTempFilter(t)
+	f.SetDryRun(true)
+	writeTestMessage(t, root, "INBOX", "41:2,S", spoofedAuthResultsMessage)
+
+	msg, err := f.Message("INBOX", "41:2,S")
+	require.NoError(t, err)
+
+	tests := []struct {
+		match  Match
+		passes bool
+	}{
+		{Match{DKIM: "pass"}, false},
+		{Match{DKIMDomain: "bank.example.com"}, false},
+		{Match{SPF: "pass"}, false},
+		{Match{DMARC: "pass"}, false},
+		{Match{DMARC: "fail", DMARCDomain: "bank.example.com"}, true},
+		{Match{DKIM: "pass", AuthServID: "mx.google.com"}, false},
+		{Match{DMARC: "pass", AuthServID: "lists.example.org"}, false},
+		{Match{ARC: "none"}, true},
+	}
+
+	for _, test := range tests {
+		cr := &CompiledRule{Match: test.match, Label: []string{"Test"}}
+
+		actions, err := f.ApplyRule(msg, cr)
+		assert.NoError(t, err)
+		assert.Equal(t, test.passes, len(actions) > 0, "%+v", test.match)
+	}
+}
@@ -0,0 +1,161 @@
+package mail
+
+import (
+	"fmt"
+	"html"
+	"io"
+	"regexp"
+	"strings"
+
+	"github.com/zostay/go-email/v2/message"
+	"github.com/zostay/go-email/v2/message/header/encoding"
+	"github.com/zostay/go-email/v2/message/walk"
+)
+
+// bodyPart is a single text part of a message body, decoded into a string.
+type bodyPart struct {
+	mediaType string // the media type of the part, in lowercase
+	text      string // the decoded content of the part
+}
+
+// DecodedEmailMessage returns the message parsed into parts with the
+// Content-transfer-encoding of every part decoded. This is loaded from disk
+// each time and is not cached.
+func (m *Message) DecodedEmailMessage() (message.Generic, error) {
+	r, err := m.r.Reader()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the message for decoding: %w", err)
+	}
+
+	mm, err := message.Parse(r,
+		message.DecodeTransferEncoding(),
+		message.WithUnlimitedRecursion(),
+		message.WithMaxPartLength(message.DefaultMaxPartLength*1_000))
+	if err != nil {
+		return mm, fmt.Errorf("failed to parse the message for decoding: %w", err)
+	}
+
+	return mm, nil
+}
+
+// decodeCharset converts the bytes in the given charset to a string. If the
+// charset is unknown, the bytes are used as-is.
+func decodeCharset(charset string, bs []byte) string {
+	switch strings.ToLower(charset) {
+	case "", "utf-8", "utf8", "us-ascii", "ascii":
+		return string(bs)
+	}
+
+	s, err := encoding.CharsetDecoder(charset, bs)
+	if err != nil {
+		return string(bs)
+	}
+
+	return s
+}
+
+// bodyParts returns the decoded text parts of the message body. The result is
+// cached.
+func (m *Message) bodyParts() ([]bodyPart, error) {
+	if m.body != nil {
+		return m.body, nil
+	}
+
+	mm, err := m.DecodedEmailMessage()
+	if err != nil {
+		return nil, err
+	}
+
+	parts := make([]bodyPart, 0, 2)
+	err = walk.AndProcessOpaque(
+		func(part message.Part, parents []message.Part) error {
+			h := part.GetHeader()
+
+			mt := "text/plain"
+			charset := ""
+			if ct, err := h.GetContentType(); err == nil {
+				mt = strings.ToLower(ct.MediaType())
+				charset = ct.Charset()
+			}
+
+			if !strings.HasPrefix(mt, "text/") {
+				return nil
+			}
+
+			if cd, err := h.GetContentDisposition(); err == nil && strings.EqualFold(cd.Presentation(), "attachment") {
+				return nil
+			}
+
+			r := part.GetReader()
+			if r == nil {
+				return nil
+			}
+
+			bs, err := io.ReadAll(r)
+			if err != nil {
+				return err
+			}
+
+			parts = append(parts, bodyPart{mt, decodeCharset(charset, bs)})
+			return nil
+		}, mm,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode message body: %w", err)
+	}
+
+	m.body = parts
+	return parts, nil
+}
+
+var (
+	// htmlHidden matches HTML elements whose content is never displayed.
+	htmlHidden = regexp.MustCompile(`(?is)<(script|style|head)\b.*?</(script|style|head)\s*>`)
+
+	// htmlComment matches HTML comments.
+	htmlComment = regexp.MustCompile(`(?s)<!--.*?-->`)
+
+	// htmlBlockTag matches HTML tags that separate blocks of text.
+	htmlBlockTag = regexp.MustCompile(`(?is)</?(p|div|br|hr|li|ul|ol|tr|td|th|table|blockquote|h[1-6])\b[^>]*>`)
+
+	// htmlTag matches any HTML tag.
+	htmlTag = regexp.MustCompile(`(?s)<[^>]*>`)
+
+	// extraSpace matches runs of whitespace.
+	extraSpace = regexp.MustCompile(`\s+`)
+)
+
+// HTMLToText makes a rough conversion of HTML into plain text by dropping
+// markup, decoding entities, and collapsing whitespace.
+func HTMLToText(h string) string {
+	h = htmlHidden.ReplaceAllString(h, " ")
+	h = htmlComment.ReplaceAllString(h, " ")
+	h = htmlBlockTag.ReplaceAllString(h, " ")
+	h = htmlTag.ReplaceAllString(h, "")
+	h = html.UnescapeString(h)
+	h = extraSpace.ReplaceAllString(h, " ")
+	return strings.TrimSpace(h)
+}
+
+// BodyText returns the text of the message body with transfer encodings and
+// charsets decoded. Every text part of the message is included, separated by
+// newlines. Attachments and non-text parts are left out. When stripHTML is
+// true, HTML parts are converted to plain text first.
+func (m *Message) BodyText(stripHTML bool) (string, error) {
+	parts, err := m.bodyParts()
+	if err != nil {
+		return "", err
+	}
+
+	texts := make([]string, len(parts))
+	for i, p := range parts {
+		if stripHTML && p.mediaType == "text/html" {
+			texts[i] = HTMLToText(p.text)
+			continue
+		}
+
+		texts[i] = p.text
+	}
+
+	return strings.Join(texts, "\n"), nil
+}
@@ -0,0 +1,94 @@
+package mail
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const encodedMessage = `From: shop@example.com
+Subject: Shipped
+MIME-Version: 1.0
+Content-Type: multipart/alternative; boundary="XXX"
+
+--XXX
+Content-Type: text/plain; charset=iso-8859-1
+Content-Transfer-Encoding: base64
+
+Q2Fm6SBvbOksIHlvdXIgb3JkZXIgaGFzIHNoaXBwZWQuCg==
+--XXX
+Content-Type: text/html; charset=utf-8
+Content-Transfer-Encoding: quoted-printable
+
+<html><head><style>p { color: red; }</style></head><body><p class=3D"tracking">T=
+racking &amp; delivery</p></body></html>
+--XXX--
+`
+
+func TestMessage_BodyText(t *testing.T) {
+	t.Parallel()
+
+	_, root := mkTempFilter(t)
+	writeTestMessage(t, root, "INBOX", "40:2,S", encodedMessage)
+
+	msg, err := NewMailDirFolder(root, "INBOX").Message("40:2,S")
+	require.NoError(t, err)
+
+	body, err := msg.BodyText(false)
+	require.NoError(t, err)
+	assert.Contains(t, body, "Café olé, your order has shipped.")
+	assert.Contains(t, body, `<p class="tracking">Tracking &amp; delivery</p>`)
+
+	body, err = msg.BodyText(true)
+	require.NoError(t, err)
+	assert.Contains(t, body, "Café olé, your order has shipped.")
+	assert.Contains(t, body, "Tracking & delivery")
+	assert.NotContains(t, body, "tracking\"")
+	assert.NotContains(t, body, "color")
+}
+
+func TestFilter_ApplyRule_BodyContains(t *testing.T) {
+	t.Parallel()
+
+	f, root := mkTempFilter(t)
+	f.SetDryRun(true)
+	writeTestMessage(t, root, "INBOX", "40:2,S", encodedMessage)
+
+	msg, err := f.Message("INBOX", "40:2,S")
+	require.NoError(t, err)
+
+	tests := []struct {
+		match  Match
+		passes bool
+	}{
+		{Match{BodyContains: "Café olé"}, true},
+		{Match{BodyContains: "café olé"}, false},
+		{Match{BodyContainsFold: "CAFÉ OLÉ"}, true},
+		{Match{BodyContains: "Tracking & delivery"}, false},
+		{Match{BodyContains: "Tracking & delivery", BodyStripHTML: true}, true},
+		{Match{BodyContainsFold: "class=", BodyStripHTML: true}, false},
+		{Match{BodyContainsFold: "class="}, true},
+	}
+
+	for _, test := range tests {
+		cr := &CompiledRule{Match: test.match, Label: []string{"Test"}}
+
+		actions, err := f.ApplyRule(msg, cr)
+		assert.NoError(t, err)
+		assert.Equal(t, test.passes, len(actions) > 0, "%+v", test.match)
+	}
+
+	// the raw contains test cannot see through the encoding
+	cr := &CompiledRule{Match: Match{Contains: "Café"}, Label: []string{"Test"}}
+	actions, err := f.ApplyRule(msg, cr)
+	assert.NoError(t, err)
+	assert.Empty(t, actions)
+}
+
+func TestHTMLToText(t *testing.T) {
+	t.Parallel()
+
+	assert.Equal(t, "Hello, World & friends",
+		HTMLToText("<html><head><title>x</title></head><body><!-- hi --><b>Hello</b>,\n <i>World</i> &amp; friends<script>alert(1)</script></body></html>"))
+}
@@ -28,6 +28,7 @@ var (
 		"reading":    color.New(color.FgHiMagenta),
 		"labeling":   color.New(color.FgHiGreen),
 		"forwarding": color.New(color.FgHiYellow),
+		"replying":   color.New(color.FgHiYellow),
 		"moving":     color.New(color.FgHiCyan),
 		"clearing":   color.New(color.FgHiBlue),
 		"dropping":   color.New(color.FgHiYellow),
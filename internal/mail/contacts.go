@@ -0,0 +1,352 @@
+package mail
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/zostay/dotfiles-go/internal/dotfiles"
+)
+
+const (
+	// ContactsFile is the name of the default address book in the home
+	// directory.
+	ContactsFile = ".label-mail.contacts.yml"
+
+	// AnyContact may be given to from_contact to match a message from anyone
+	// in the address book.
+	AnyContact = "*"
+)
+
+// Contact is a single entry in the address book.
+type Contact struct {
+	// Name is the name used to refer to the contact in rules.
+	Name string `yaml:"name"`
+
+	// Emails lists the email addresses of the contact.
+	Emails []string `yaml:"emails"`
+
+	// Groups lists the names of the groups the contact belongs to.
+	Groups []string `yaml:"groups"`
+}
+
+// AddressBook is a collection of contacts loaded either from a YAML file or a
+// directory of vCard (.vcf) files. The contacts are reloaded whenever the files
+// change.
+type AddressBook struct {
+	path string
+
+	lock     sync.Mutex
+	stamp    string
+	contacts []Contact
+}
+
+// NewAddressBook returns an AddressBook for the given path. If the path names
+// a directory, every .vcf file in it is read. If the path names a .vcf file,
+// that file is read. Otherwise, the path names a YAML file holding a list of
+// contacts.
+func NewAddressBook(path string) *AddressBook {
+	return &AddressBook{path: path}
+}
+
+// DefaultContactsPath returns the default location of the address book.
+func DefaultContactsPath() string {
+	return path.Join(dotfiles.HomeDir, ContactsFile)
+}
+
+// Path returns the path the AddressBook is read from.
+func (ab *AddressBook) Path() string { return ab.path }
+
+// vCardFiles returns the vCard files to read, sorted by name. It returns nil
+// if the address book is a YAML file.
+func (ab *AddressBook) vCardFiles() ([]string, error) {
+	info, err := os.Stat(ab.path)
+	if err != nil {
+		return nil, err
+	}
+
+	if !info.IsDir() {
+		if strings.EqualFold(filepath.Ext(ab.path), ".vcf") {
+			return []string{ab.path}, nil
+		}
+		return nil, nil
+	}
+
+	des, err := os.ReadDir(ab.path)
+	if err != nil {
+		return nil, err
+	}
+
+	files := make([]string, 0, len(des))
+	for _, de := range des {
+		if de.IsDir() || !strings.EqualFold(filepath.Ext(de.Name()), ".vcf") {
+			continue
+		}
+		files = append(files, filepath.Join(ab.path, de.Name()))
+	}
+
+	sort.Strings(files)
+	return files, nil
+}
+
+// currentStamp describes the modification times and sizes of the files making
+// up the address book, so changes can be detected.
+func (ab *AddressBook) currentStamp() (string, error) {
+	files, err := ab.vCardFiles()
+	if err != nil {
+		return "", err
+	}
+
+	if files == nil {
+		files = []string{ab.path}
+	}
+
+	var stamp strings.Builder
+	for _, f := range files {
+		info, err := os.Stat(f)
+		if err != nil {
+			return "", err
+		}
+		fmt.Fprintf(&stamp, "%s %d %d\n", f, info.Size(), info.ModTime().UnixNano())
+	}
+
+	return stamp.String(), nil
+}
+
+// load reads the contacts if the files have changed since they were last read.
+func (ab *AddressBook) load() error {
+	stamp, err := ab.currentStamp()
+	if err != nil {
+		return fmt.Errorf("unable to read address book %s: %w", ab.path, err)
+	}
+
+	if stamp == ab.stamp {
+		return nil
+	}
+
+	files, err := ab.vCardFiles()
+	if err != nil {
+		return fmt.Errorf("unable to read address book %s: %w", ab.path, err)
+	}
+
+	var contacts []Contact
+	if files == nil {
+		bs, err := os.ReadFile(ab.path)
+		if err != nil {
+			return fmt.Errorf("unable to read address book %s: %w", ab.path, err)
+		}
+
+		err = yaml.Unmarshal(bs, &contacts)
+		if err != nil {
+			return fmt.Errorf("failed to parse YAML in address book %s: %w", ab.path, err)
+		}
+	} else {
+		for _, f := range files {
+			cs, err := readVCardFile(f)
+			if err != nil {
+				return err
+			}
+			contacts = append(contacts, cs...)
+		}
+	}
+
+	ab.contacts = contacts
+	ab.stamp = stamp
+
+	return nil
+}
+
+// Contacts returns all the contacts in the address book.
+func (ab *AddressBook) Contacts() ([]Contact, error) {
+	ab.lock.Lock()
+	defer ab.lock.Unlock()
+
+	if err := ab.load(); err != nil {
+		return nil, err
+	}
+
+	return ab.contacts, nil
+}
+
+// ContactEmails returns the email addresses of the named contact. The name is
+// matched without regard to case. If the name is AnyContact, the addresses of
+// every contact are returned.
+func (ab *AddressBook) ContactEmails(name string) ([]string, error) {
+	contacts, err := ab.Contacts()
+	if err != nil {
+		return nil, err
+	}
+
+	var emails []string
+	for _, c := range contacts {
+		if name == AnyContact || strings.EqualFold(c.Name, name) {
+			emails = append(emails, c.Emails...)
+		}
+	}
+
+	return emails, nil
+}
+
+// GroupEmails returns the email addresses of every contact in the named group.
+// The group name is matched without regard to case.
+func (ab *AddressBook) GroupEmails(group string) ([]string, error) {
+	contacts, err := ab.Contacts()
+	if err != nil {
+		return nil, err
+	}
+
+	var emails []string
+	for _, c := range contacts {
+		for _, g := range c.Groups {
+			if strings.EqualFold(g, group) {
+				emails = append(emails, c.Emails...)
+				break
+			}
+		}
+	}
+
+	return emails, nil
+}
+
+// readVCardFile reads the contacts from a vCard file.
+func readVCardFile(fn string) ([]Contact, error) {
+	f, err := os.Open(fn)
+	if err != nil {
+		return nil, fmt.Errorf("unable to open vCard file %s: %w", fn, err)
+	}
+
+	defer f.Close()
+
+	cs, err := ParseVCards(f)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse vCard file %s: %w", fn, err)
+	}
+
+	return cs, nil
+}
+
+// ParseVCards reads the contacts from a stream of vCards. Only the FN, N,
+// EMAIL, and CATEGORIES properties are used. The categories are treated as
+// group names.
+func ParseVCards(r io.Reader) ([]Contact, error) {
+	var lines []string
+	s := bufio.NewScanner(r)
+	for s.Scan() {
+		line := strings.TrimRight(s.Text(), "\r")
+		if len(lines) > 0 && (strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t")) {
+			lines[len(lines)-1] += line[1:]
+			continue
+		}
+		lines = append(lines, line)
+	}
+
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+
+	var (
+		contacts []Contact
+		c        *Contact
+	)
+	for n, line := range lines {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+
+		prop, value, ok := strings.Cut(line, ":")
+		if !ok {
+			return nil, fmt.Errorf("line %d: vCard property is missing a value", n+1)
+		}
+
+		// drop the parameters and any group prefix from the property name
+		name, _, _ := strings.Cut(prop, ";")
+		if i := strings.LastIndexByte(name, '.'); i >= 0 {
+			name = name[i+1:]
+		}
+		name = strings.ToUpper(name)
+
+		switch {
+		case name == "BEGIN" && strings.EqualFold(value, "VCARD"):
+			if c != nil {
+				return nil, fmt.Errorf("line %d: vCard begins before the last one ended", n+1)
+			}
+			c = &Contact{}
+		case name == "END" && strings.EqualFold(value, "VCARD"):
+			if c == nil {
+				return nil, fmt.Errorf("line %d: vCard ends before it begins", n+1)
+			}
+			contacts = append(contacts, *c)
+			c = nil
+		case c == nil:
+			return nil, fmt.Errorf("line %d: vCard property found outside of a vCard", n+1)
+		case name == "FN":
+			c.Name = unescapeVCard(value)
+		case name == "N" && c.Name == "":
+			parts := strings.Split(value, ";")
+			if len(parts) > 1 {
+				c.Name = strings.TrimSpace(unescapeVCard(parts[1]) + " " + unescapeVCard(parts[0]))
+			} else {
+				c.Name = unescapeVCard(parts[0])
+			}
+		case name == "EMAIL":
+			c.Emails = append(c.Emails, strings.TrimSpace(value))
+		case name == "CATEGORIES":
+			for _, g := range strings.Split(value, ",") {
+				if g = strings.TrimSpace(unescapeVCard(g)); g != "" {
+					c.Groups = append(c.Groups, g)
+				}
+			}
+		}
+	}
+
+	if c != nil {
+		return nil, errors.New("vCard is missing its END")
+	}
+
+	return contacts, nil
+}
+
+// unescapeVCard removes the backslash escapes from a vCard text value.
+func unescapeVCard(s string) string {
+	if !strings.Contains(s, "\\") {
+		return s
+	}
+
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+1 < len(s) {
+			i++
+			if s[i] == 'n' || s[i] == 'N' {
+				b.WriteByte('\n')
+				continue
+			}
+		}
+		b.WriteByte(s[i])
+	}
+
+	return b.String()
+}
+
+// SetAddressBook changes the address book used to match contacts and groups.
+// The default is the address book named in the rules file or at
+// DefaultContactsPath.
+func (fi *Filter) SetAddressBook(ab *AddressBook) {
+	fi.contacts = ab
+}
+
+// AddressBook returns the address book used to match contacts and groups.
+func (fi *Filter) AddressBook() *AddressBook {
+	if fi.contacts == nil {
+		fi.contacts = NewAddressBook(DefaultContactsPath())
+	}
+	return fi.contacts
+}
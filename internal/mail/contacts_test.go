@@ -0,0 +1,117 @@
+package mail
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testVCards = "BEGIN:VCARD\r\n" +
+	"VERSION:3.0\r\n" +
+	"FN:Sterling Hanenkamp\r\n" +
+	"EMAIL;TYPE=work:sterling@example.com\r\n" +
+	"item1.EMAIL:sterling@example.org\r\n" +
+	"CATEGORIES:Team,Family\r\n" +
+	"END:VCARD\r\n" +
+	"BEGIN:VCARD\r\n" +
+	"VERSION:3.0\r\n" +
+	"N:Smith;Alice;;;\r\n" +
+	"EMAIL:alice@\r\n" +
+	" example.com\r\n" +
+	"END:VCARD\r\n"
+
+func TestParseVCards(t *testing.T) {
+	t.Parallel()
+
+	cs, err := ParseVCards(strings.NewReader(testVCards))
+	require.NoError(t, err)
+	assert.Equal(t, []Contact{
+		{
+			Name:   "Sterling Hanenkamp",
+			Emails: []string{"sterling@example.com", "sterling@example.org"},
+			Groups: []string{"Team", "Family"},
+		},
+		{
+			Name:   "Alice Smith",
+			Emails: []string{"alice@example.com"},
+		},
+	}, cs)
+
+	_, err = ParseVCards(strings.NewReader("BEGIN:VCARD\r\nFN:Bob\r\n"))
+	assert.Error(t, err)
+}
+
+func TestAddressBook_Reload(t *testing.T) {
+	t.Parallel()
+
+	fn := filepath.Join(t.TempDir(), "contacts.yml")
+	require.NoError(t, os.WriteFile(fn, []byte(`
+- name: Alice
+  emails: [alice@example.com]
+  groups: [team]
+`), 0600))
+
+	ab := NewAddressBook(fn)
+	emails, err := ab.GroupEmails("Team")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"alice@example.com"}, emails)
+
+	require.NoError(t, os.WriteFile(fn, []byte(`
+- name: Alice
+  emails: [alice@example.com]
+  groups: [team]
+- name: Bob
+  emails: [bob@example.com, robert@example.com]
+  groups: [team]
+`), 0600))
+	later := time.Now().Add(time.Minute)
+	require.NoError(t, os.Chtimes(fn, later, later))
+
+	emails, err = ab.GroupEmails("team")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"alice@example.com", "bob@example.com", "robert@example.com"}, emails)
+
+	emails, err = ab.ContactEmails("bob")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"bob@example.com", "robert@example.com"}, emails)
+}
+
+func TestFilter_ApplyRule_Contacts(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "contacts.vcf"), []byte(testVCards), 0600))
+
+	f, _ := mkTempFilter(t)
+	f.SetDryRun(true)
+	f.SetAddressBook(NewAddressBook(dir))
+
+	msg, err := f.Message("INBOX", "1:2,S")
+	require.NoError(t, err)
+
+	tests := []struct {
+		match  Match
+		passes bool
+	}{
+		{Match{FromContact: "Sterling Hanenkamp"}, true},
+		{Match{FromContact: "sterling hanenkamp"}, true},
+		{Match{FromContact: "Alice Smith"}, false},
+		{Match{FromContact: AnyContact}, true},
+		{Match{FromContact: "Nobody"}, false},
+		{Match{FromGroup: "team"}, true},
+		{Match{FromGroup: "Coworkers"}, false},
+	}
+
+	for _, test := range tests {
+		cr := &CompiledRule{Match: test.match, Label: []string{"Test"}}
+
+		actions, err := f.ApplyRule(msg, cr)
+		assert.NoError(t, err)
+		assert.Equal(t, test.passes, len(actions) > 0, "%+v", test.match)
+	}
+}
@@ -0,0 +1,88 @@
+package mail
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/zostay/dotfiles-go/internal/keeper"
+)
+
+// Credentials holds the secrets required to send mail.
+type Credentials struct {
+	// Username is the username used to authenticate with the SMTP server.
+	Username string
+
+	// Password is the password used to authenticate with the SMTP server.
+	Password string
+
+	// FromEmail is the email address to send from when the SMTP account does
+	// not configure one.
+	FromEmail string
+}
+
+// CredentialsProvider resolves the Credentials used to send mail. A provider
+// is only asked for credentials when mail is actually being sent.
+type CredentialsProvider interface {
+	Credentials() (*Credentials, error)
+}
+
+// KeeperCredentials is a CredentialsProvider that looks up the credentials in
+// the secret keeper. The secrets are fetched on first use and cached after.
+type KeeperCredentials struct {
+	// UsernameSecret names the secret holding the SMTP username.
+	UsernameSecret string
+
+	// PasswordSecret names the secret holding the SMTP password.
+	PasswordSecret string
+
+	// FromEmailSecret names the secret holding the from email address.
+	FromEmailSecret string
+
+	once  sync.Once
+	creds *Credentials
+	err   error
+}
+
+var _ CredentialsProvider = &KeeperCredentials{}
+
+// DefaultCredentials is the CredentialsProvider used when no other is given.
+var DefaultCredentials CredentialsProvider = NewKeeperCredentials()
+
+// NewKeeperCredentials returns a KeeperCredentials using the usual secret
+// names.
+func NewKeeperCredentials() *KeeperCredentials {
+	return &KeeperCredentials{
+		UsernameSecret:  "LABEL_MAIL_USERNAME",
+		PasswordSecret:  "LABEL_MAIL_PASSWORD",
+		FromEmailSecret: "GIT_EMAIL_HOME",
+	}
+}
+
+// Credentials starts the secret keeper, if needed, and then fetches the
+// credentials from it.
+func (k *KeeperCredentials) Credentials() (*Credentials, error) {
+	k.once.Do(func() {
+		keeper.RequiresSecretKeeper()
+
+		var creds Credentials
+		for _, s := range []struct {
+			name string
+			dest *string
+		}{
+			{k.UsernameSecret, &creds.Username},
+			{k.PasswordSecret, &creds.Password},
+			{k.FromEmailSecret, &creds.FromEmail},
+		} {
+			sec, err := keeper.GetSecret(s.name)
+			if err != nil {
+				k.err = fmt.Errorf("unable to get secret %q: %w", s.name, err)
+				return
+			}
+			*s.dest = sec.Password()
+		}
+
+		k.creds = &creds
+	})
+
+	return k.creds, k.err
+}
@@ -0,0 +1,258 @@
+package mail
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// TimeWindow is a range of times of day. In configuration, it is given as two
+// 24-hour times separated by a hyphen, such as "09:00-17:00". When the end is
+// before the start, the window wraps around midnight, so "18:00-08:00" covers
+// the evening and early morning.
+type TimeWindow struct {
+	// Start is the time since midnight the window opens.
+	Start time.Duration
+
+	// End is the time since midnight the window closes.
+	End time.Duration
+}
+
+// parseTimeOfDay parses a 24-hour time such as "08:00" or "8:30" into the
+// time since midnight.
+func parseTimeOfDay(s string) (time.Duration, error) {
+	hs, ms, ok := strings.Cut(strings.TrimSpace(s), ":")
+	if !ok {
+		return 0, fmt.Errorf("invalid time of day %q: expected HH:MM", s)
+	}
+
+	h, err := strconv.Atoi(hs)
+	if err != nil || h < 0 || h > 24 {
+		return 0, fmt.Errorf("invalid time of day %q: bad hour", s)
+	}
+
+	m, err := strconv.Atoi(ms)
+	if err != nil || m < 0 || m > 59 || (h == 24 && m != 0) {
+		return 0, fmt.Errorf("invalid time of day %q: bad minute", s)
+	}
+
+	return time.Duration(h)*time.Hour + time.Duration(m)*time.Minute, nil
+}
+
+// ParseTimeWindow parses a TimeWindow such as "18:00-08:00".
+func ParseTimeWindow(s string) (TimeWindow, error) {
+	ss, es, ok := strings.Cut(s, "-")
+	if !ok {
+		return TimeWindow{}, fmt.Errorf("invalid time window %q: expected HH:MM-HH:MM", s)
+	}
+
+	start, err := parseTimeOfDay(ss)
+	if err != nil {
+		return TimeWindow{}, fmt.Errorf("invalid time window %q: %w", s, err)
+	}
+
+	end, err := parseTimeOfDay(es)
+	if err != nil {
+		return TimeWindow{}, fmt.Errorf("invalid time window %q: %w", s, err)
+	}
+
+	return TimeWindow{start, end}, nil
+}
+
+// UnmarshalYAML parses the TimeWindow from configuration.
+func (w *TimeWindow) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var s string
+	err := unmarshal(&s)
+	if err != nil {
+		return err
+	}
+
+	*w, err = ParseTimeWindow(s)
+	return err
+}
+
+// MarshalYAML writes the TimeWindow as it would be given in configuration.
+func (w TimeWindow) MarshalYAML() (interface{}, error) {
+	return w.String(), nil
+}
+
+// Contains returns true if the time of day of t falls within the window. The
+// start of the window is included and the end is not.
+func (w TimeWindow) Contains(t time.Time) bool {
+	h, m, s := t.Clock()
+	tod := time.Duration(h)*time.Hour + time.Duration(m)*time.Minute + time.Duration(s)*time.Second
+
+	if w.Start <= w.End {
+		return tod >= w.Start && tod < w.End
+	}
+
+	return tod >= w.Start || tod < w.End
+}
+
+// String returns the window as it would be given in configuration.
+func (w TimeWindow) String() string {
+	format := func(d time.Duration) string {
+		return fmt.Sprintf("%02d:%02d", int(d.Hours()), int(d.Minutes())%60)
+	}
+	return format(w.Start) + "-" + format(w.End)
+}
+
+// weekdayNames maps the names of the days of the week, in full and
+// abbreviated, to the time.Weekday.
+var weekdayNames = map[string]time.Weekday{
+	"sun": time.Sunday, "sunday": time.Sunday,
+	"mon": time.Monday, "monday": time.Monday,
+	"tue": time.Tuesday, "tuesday": time.Tuesday,
+	"wed": time.Wednesday, "wednesday": time.Wednesday,
+	"thu": time.Thursday, "thursday": time.Thursday,
+	"fri": time.Friday, "friday": time.Friday,
+	"sat": time.Saturday, "saturday": time.Saturday,
+}
+
+// Weekdays is a set of days of the week. In configuration, it may be given as a
+// single day or a list of days. Each day may be a name, such as "sat" or
+// "Saturday", or a range of days, such as "mon-fri".
+type Weekdays []time.Weekday
+
+// parseWeekdays parses a single day or range of days.
+func parseWeekdays(s string) (Weekdays, error) {
+	parse := func(name string) (time.Weekday, error) {
+		d, ok := weekdayNames[strings.ToLower(strings.TrimSpace(name))]
+		if !ok {
+			return 0, fmt.Errorf("unknown day of the week %q", name)
+		}
+		return d, nil
+	}
+
+	fs, ls, isRange := strings.Cut(s, "-")
+	first, err := parse(fs)
+	if err != nil {
+		return nil, err
+	}
+
+	if !isRange {
+		return Weekdays{first}, nil
+	}
+
+	last, err := parse(ls)
+	if err != nil {
+		return nil, err
+	}
+
+	var ds Weekdays
+	for d := first; ; d = (d + 1) % 7 {
+		ds = append(ds, d)
+		if d == last {
+			break
+		}
+	}
+
+	return ds, nil
+}
+
+// UnmarshalYAML parses the Weekdays from configuration.
+func (ws *Weekdays) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var names []string
+	if err := unmarshal(&names); err != nil {
+		var name string
+		if err := unmarshal(&name); err != nil {
+			return err
+		}
+		names = []string{name}
+	}
+
+	*ws = nil
+	for _, name := range names {
+		ds, err := parseWeekdays(name)
+		if err != nil {
+			return err
+		}
+		*ws = append(*ws, ds...)
+	}
+
+	return nil
+}
+
+// MarshalYAML writes the Weekdays as a list of abbreviated names.
+func (ws Weekdays) MarshalYAML() (interface{}, error) {
+	return strings.Split(ws.String(), ","), nil
+}
+
+// Contains returns true if the day of the week of t is in the set.
+func (ws Weekdays) Contains(t time.Time) bool {
+	for _, d := range ws {
+		if t.Weekday() == d {
+			return true
+		}
+	}
+	return false
+}
+
+// String returns the abbreviated names of the days in the set.
+func (ws Weekdays) String() string {
+	names := make([]string, len(ws))
+	for i, d := range ws {
+		names[i] = strings.ToLower(d.String()[:3])
+	}
+	return strings.Join(names, ",")
+}
+
+// Age is a length of time. In configuration, it may be given as a plain
+// number of days or as a number with a unit suffix: "m" for minutes, "h" for
+// hours, "d" for days, or "w" for weeks.
+type Age time.Duration
+
+// ageUnits maps unit suffixes to their lengths.
+var ageUnits = map[string]time.Duration{
+	"":  24 * time.Hour,
+	"m": time.Minute,
+	"h": time.Hour,
+	"d": 24 * time.Hour,
+	"w": 7 * 24 * time.Hour,
+}
+
+// ParseAge parses an Age such as "36h" or "2w".
+func ParseAge(s string) (Age, error) {
+	s = strings.ToLower(strings.TrimSpace(s))
+	i := strings.IndexFunc(s, func(c rune) bool { return c < '0' || c > '9' })
+	if i < 0 {
+		i = len(s)
+	}
+
+	n, err := strconv.ParseInt(s[:i], 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid age %q: %w", s, err)
+	}
+
+	unit, ok := ageUnits[s[i:]]
+	if !ok {
+		return 0, fmt.Errorf("invalid age %q: unknown unit %q", s, s[i:])
+	}
+
+	return Age(time.Duration(n) * unit), nil
+}
+
+// UnmarshalYAML parses the Age from configuration.
+func (a *Age) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var s string
+	err := unmarshal(&s)
+	if err != nil {
+		return err
+	}
+
+	*a, err = ParseAge(s)
+	return err
+}
+
+// MarshalYAML writes the Age as it would be given in configuration, using the
+// largest unit that represents it exactly.
+func (a Age) MarshalYAML() (interface{}, error) {
+	d := time.Duration(a)
+	for _, unit := range []string{"w", "d", "h", "m"} {
+		if d%ageUnits[unit] == 0 {
+			return fmt.Sprintf("%d%s", d/ageUnits[unit], unit), nil
+		}
+	}
+	return fmt.Sprintf("%dm", d/time.Minute), nil
+}
@@ -0,0 +1,125 @@
+package mail
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v3"
+)
+
+func TestTimeWindow(t *testing.T) {
+	t.Parallel()
+
+	at := func(h, m int) time.Time { return time.Date(2022, 11, 19, h, m, 0, 0, time.UTC) }
+
+	w, err := ParseTimeWindow("09:00-17:30")
+	require.NoError(t, err)
+	assert.Equal(t, "09:00-17:30", w.String())
+	assert.True(t, w.Contains(at(9, 0)))
+	assert.True(t, w.Contains(at(17, 29)))
+	assert.False(t, w.Contains(at(17, 30)))
+	assert.False(t, w.Contains(at(8, 59)))
+
+	w, err = ParseTimeWindow("18:00-8:00")
+	require.NoError(t, err)
+	assert.True(t, w.Contains(at(23, 0)))
+	assert.True(t, w.Contains(at(2, 0)))
+	assert.False(t, w.Contains(at(12, 0)))
+
+	for _, bad := range []string{"18:00", "25:00-08:00", "18:60-08:00", "six-eight"} {
+		_, err = ParseTimeWindow(bad)
+		assert.Error(t, err, bad)
+	}
+}
+
+func TestWeekdays_UnmarshalYAML(t *testing.T) {
+	t.Parallel()
+
+	var m Match
+	require.NoError(t, yaml.Unmarshal([]byte(`weekdays: [sat, Sunday]`), &m))
+	assert.Equal(t, Weekdays{time.Saturday, time.Sunday}, m.Weekdays)
+
+	require.NoError(t, yaml.Unmarshal([]byte(`weekdays: fri-mon`), &m))
+	assert.Equal(t, Weekdays{time.Friday, time.Saturday, time.Sunday, time.Monday}, m.Weekdays)
+	assert.Equal(t, "fri,sat,sun,mon", m.Weekdays.String())
+
+	assert.Error(t, yaml.Unmarshal([]byte(`weekdays: [someday]`), &m))
+}
+
+func TestParseAge(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]time.Duration{
+		"3":   72 * time.Hour,
+		"36h": 36 * time.Hour,
+		"2d":  48 * time.Hour,
+		"1w":  7 * 24 * time.Hour,
+		"90m": 90 * time.Minute,
+	}
+
+	for s, expect := range tests {
+		a, err := ParseAge(s)
+		assert.NoError(t, err, s)
+		assert.Equal(t, Age(expect), a, s)
+	}
+
+	_, err := ParseAge("3y")
+	assert.Error(t, err)
+}
+
+const offHoursMessage = "From: alerts@example.com\r\n" +
+	"To: me@example.com\r\n" +
+	"Subject: Disk full\r\n" +
+	"Date: Sat, 19 Nov 2022 23:30:00 +0000\r\n" +
+	"\r\n" +
+	"The disk is full.\r\n"
+
+func TestFilter_ApplyRule_Dates(t *testing.T) {
+	t.Parallel()
+
+	f, root := mkTempFilter(t)
+	f.SetDryRun(true)
+	writeTestMessage(t, root, "INBOX", "70:2,S", offHoursMessage)
+
+	msg, err := f.Message("INBOX", "70:2,S")
+	require.NoError(t, err)
+
+	chicago, err := time.LoadLocation("America/Chicago")
+	require.NoError(t, err)
+
+	msgDate := time.Date(2022, 11, 19, 23, 30, 0, 0, time.UTC)
+
+	tests := []struct {
+		rule   CompiledRule
+		passes bool
+	}{
+		{CompiledRule{Match: Match{ReceivedBetween: &TimeWindow{18 * time.Hour, 8 * time.Hour}}, Location: time.UTC}, true},
+		{CompiledRule{Match: Match{ReceivedBetween: &TimeWindow{8 * time.Hour, 18 * time.Hour}}, Location: time.UTC}, false},
+		{CompiledRule{Match: Match{ReceivedBetween: &TimeWindow{8 * time.Hour, 18 * time.Hour}}, Location: chicago}, true},
+		{CompiledRule{Match: Match{Weekdays: Weekdays{time.Saturday, time.Sunday}}, Location: time.UTC}, true},
+		{CompiledRule{Match: Match{Weekdays: Weekdays{time.Sunday}}, Location: time.UTC}, false},
+		{CompiledRule{Match: Match{Weekdays: Weekdays{time.Saturday}}, Location: chicago}, true},
+		{CompiledRule{NewerDate: msgDate.Add(-time.Hour)}, true},
+		{CompiledRule{NewerDate: msgDate.Add(time.Hour)}, false},
+	}
+
+	for _, test := range tests {
+		cr := test.rule
+		cr.Label = []string{"Test"}
+
+		actions, err := f.ApplyRule(msg, &cr)
+		assert.NoError(t, err)
+		assert.Equal(t, test.passes, len(actions) > 0, "%+v", test.rule)
+	}
+}
+
+func TestCompiledRules_FolderRules_NewerThan(t *testing.T) {
+	t.Parallel()
+
+	now := time.Date(2022, 11, 22, 23, 11, 59, 0, time.UTC)
+	cr := &CompiledRule{Match: Match{NewerThan: Age(36 * time.Hour)}, Label: []string{"Test"}}
+	CompiledRules{cr}.FolderRules(now)
+	assert.Equal(t, now.Add(-36*time.Hour), cr.NewerDate)
+}
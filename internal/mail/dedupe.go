@@ -0,0 +1,231 @@
+package mail
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/zostay/go-email/v2/message/header"
+)
+
+// PreferredDuplicateFolders lists the folders, in order of preference, that
+// hold the copy of a duplicated message that is kept when duplicates are
+// merged. If no copy is in one of these folders, the copy in the
+// alphabetically first folder is kept.
+var PreferredDuplicateFolders = []string{"gmail.All_Mail"}
+
+// DuplicateSet is a group of message files that are all copies of the same
+// message.
+type DuplicateSet struct {
+	// Key is the Message-ID shared by the copies or, if the copies have no
+	// Message-ID, the hash of the headers and body they share.
+	Key string
+
+	// Messages lists the copies. The first message is the one that is kept
+	// when the duplicates are merged.
+	Messages []*Message
+}
+
+// Keep returns the copy of the message to keep.
+func (ds *DuplicateSet) Keep() *Message { return ds.Messages[0] }
+
+// Extras returns the copies of the message to remove.
+func (ds *DuplicateSet) Extras() []*Message { return ds.Messages[1:] }
+
+// duplicateKeyHeaders are the headers hashed along with the body to identify
+// copies of a message without a Message-ID. Many different messages share the
+// same body (e.g., an empty body, "Thanks!", or a form notice), so the body
+// alone is not enough.
+var duplicateKeyHeaders = []string{header.Date, header.From, header.To, header.Subject}
+
+// DuplicateKey returns the key used to identify copies of the same message.
+// This is the Message-ID header, if present. Otherwise, it is a SHA-256 hash of
+// the Date, From, To, and Subject headers and the message body.
+func (m *Message) DuplicateKey() (string, error) {
+	id, err := m.MessageID()
+	if err != nil {
+		return "", err
+	}
+
+	if id != "" {
+		return id, nil
+	}
+
+	mh, err := m.EmailHeader()
+	if err != nil {
+		return "", fmt.Errorf("failed to read message header for hashing: %w", err)
+	}
+
+	h := sha256.New()
+	for _, name := range duplicateKeyHeaders {
+		for _, f := range mh.GetAllFieldsNamed(name) {
+			fmt.Fprintf(h, "%s: %s\n", name, strings.TrimSpace(f.Body()))
+		}
+	}
+	fmt.Fprintln(h)
+
+	mm, err := m.OpaqueEmailMessage()
+	if err != nil {
+		return "", fmt.Errorf("failed to read message body for hashing: %w", err)
+	}
+
+	if r := mm.GetReader(); r != nil {
+		_, err = io.Copy(h, r)
+		if err != nil {
+			return "", fmt.Errorf("failed to hash message body: %w", err)
+		}
+	}
+
+	return "sha256:" + hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// duplicatePreference ranks the folder for keeping a duplicate. Lower is
+// better.
+func duplicatePreference(folder string) int {
+	for i, pf := range PreferredDuplicateFolders {
+		if pf == folder {
+			return i
+		}
+	}
+	return len(PreferredDuplicateFolders)
+}
+
+// FindDuplicates searches the given folders (or all folders if none are given)
+// for messages that are copies of one another. It returns each group of copies
+// found, sorted by key.
+func (fi *Filter) FindDuplicates(onlyFolders []string) ([]*DuplicateSet, error) {
+	whichFolders := onlyFolders
+	if len(whichFolders) == 0 {
+		var err error
+		whichFolders, err = fi.AllFolders()
+		if err != nil {
+			return nil, fmt.Errorf("unable to get a list of folders for deduplication: %w", err)
+		}
+	}
+
+	copies := make(map[string][]*Message)
+	for _, folder := range whichFolders {
+		if _, skip := SkipFolder[folder]; skip {
+			continue
+		}
+
+		msgs, err := fi.folder(folder).Messages()
+		if err != nil {
+			return nil, fmt.Errorf("failed to get messages from folder %s: %w", folder, err)
+		}
+
+		var msg Message
+		for msgs.Next(&msg) {
+			key, err := msg.DuplicateKey()
+			if err != nil {
+				return nil, fmt.Errorf("failed to identify message %q: %w", msg.Filename(), err)
+			}
+
+			// a fresh message does not keep the parsed header in memory
+			copies[key] = append(copies[key], NewMessage(msg.r))
+		}
+
+		if err := msgs.Err(); err != nil {
+			return nil, fmt.Errorf("failed reading messages from folder %s: %w", folder, err)
+		}
+	}
+
+	sets := make([]*DuplicateSet, 0)
+	for key, msgs := range copies {
+		if len(msgs) < 2 {
+			continue
+		}
+
+		sort.Slice(msgs, func(i, j int) bool {
+			fi, fj := msgs[i].r.Folder(), msgs[j].r.Folder()
+			pi, pj := duplicatePreference(fi), duplicatePreference(fj)
+			if pi != pj {
+				return pi < pj
+			}
+			if fi != fj {
+				return fi < fj
+			}
+			return msgs[i].Filename() < msgs[j].Filename()
+		})
+
+		sets = append(sets, &DuplicateSet{key, msgs})
+	}
+
+	sort.Slice(sets, func(i, j int) bool {
+		return sets[i].Key < sets[j].Key
+	})
+
+	return sets, nil
+}
+
+// MergeDuplicates merges the keywords of every copy in the set into the copy
+// being kept and then removes the extra copies. Nothing is changed during a
+// dry run. It records the work done (or that would be done) in the given
+// ActionsSummary.
+func (fi *Filter) MergeDuplicates(actions ActionsSummary, ds *DuplicateSet) error {
+	keep := ds.Keep()
+
+	ks := make([]string, 0)
+	for _, extra := range ds.Extras() {
+		eks, err := extra.Keywords()
+		if err != nil {
+			return fmt.Errorf("failed to read keywords of duplicate %q: %w", extra.Filename(), err)
+		}
+
+		ks = append(ks, eks...)
+	}
+
+	missing, err := keep.MissingKeywords(ks...)
+	if err != nil {
+		return fmt.Errorf("failed to read keywords of %q: %w", keep.Filename(), err)
+	}
+
+	if len(missing) > 0 {
+		if fi.debug > 0 {
+			cp.Fcolor(os.Stderr,
+				"labeling", "LABELING",
+				"file", fmt.Sprintf(" %s ", keep.Filename()),
+				"action", ": ",
+				"value", fmt.Sprintf("%s\n", strings.Join(missing, ", ")),
+			)
+		}
+
+		if !fi.dryRun {
+			err := keep.AddKeyword(missing...)
+			if err != nil {
+				return fmt.Errorf("failed to merge keywords into %q: %w", keep.Filename(), err)
+			}
+
+			err = keep.Save()
+			if err != nil {
+				return fmt.Errorf("failed to save %q: %w", keep.Filename(), err)
+			}
+		}
+
+		actions["Merged keywords into kept copy"]++
+	}
+
+	for _, extra := range ds.Extras() {
+		if fi.debug > 0 {
+			cp.Fcolor(os.Stderr,
+				"dropping", "REMOVING",
+				"file", fmt.Sprintf(" %s\n", extra.Filename()),
+			)
+		}
+
+		if !fi.dryRun {
+			err := extra.Remove()
+			if err != nil {
+				return fmt.Errorf("failed to remove duplicate %q: %w", extra.Filename(), err)
+			}
+		}
+
+		actions["Removed duplicate"]++
+	}
+
+	return nil
+}
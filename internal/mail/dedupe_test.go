@@ -0,0 +1,104 @@
+package mail
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	dupeInboxMessage = `Message-ID: <dupe@example.com>
+Keywords: \Inbox
+Subject: Dupe
+
+Duplicated message.
+`
+
+	dupeAllMailMessage = `Message-ID: <dupe@example.com>
+Keywords: Family
+Subject: Dupe
+
+Duplicated message.
+`
+)
+
+func mkDedupeFilter(t *testing.T) (*Filter, string) {
+	t.Helper()
+
+	f, root := mkTempFilter(t)
+	writeTestMessage(t, root, "INBOX", "10:2,S", dupeInboxMessage)
+	writeTestMessage(t, root, "gmail.All_Mail", "11:2,S", dupeAllMailMessage)
+
+	// no Message-ID and the same Subject and body as Other/cur/3:2,S, but
+	// not the same message
+	writeTestMessage(t, root, "INBOX", "12:2,S", "From: someone@example.com\nSubject: Baz\n\nYet another test message.\n")
+
+	// no Message-ID, but the same message
+	const noID = "Date: Tue, 1 Nov 2022 11:23:13 -0500\nFrom: sterling@example.com\nTo: sterling@example.com\nSubject: Thanks\n\nThanks!\n"
+	writeTestMessage(t, root, "INBOX", "13:2,S", "Keywords: \\Inbox\n"+noID)
+	writeTestMessage(t, root, "gmail.All_Mail", "14:2,S", "Keywords: Family\n"+noID)
+	return f, root
+}
+
+func TestFilter_FindDuplicates(t *testing.T) {
+	t.Parallel()
+
+	f, root := mkDedupeFilter(t)
+
+	sets, err := f.FindDuplicates(nil)
+	require.NoError(t, err)
+	require.Len(t, sets, 2)
+
+	assert.Equal(t, "<dupe@example.com>", sets[0].Key)
+	assert.Equal(t, filepath.Join(root, "gmail.All_Mail", "cur", "11:2,S"), sets[0].Keep().Filename())
+	require.Len(t, sets[0].Extras(), 1)
+	assert.Equal(t, filepath.Join(root, "INBOX", "cur", "10:2,S"), sets[0].Extras()[0].Filename())
+
+	assert.Contains(t, sets[1].Key, "sha256:")
+	assert.Equal(t, filepath.Join(root, "gmail.All_Mail", "cur", "14:2,S"), sets[1].Keep().Filename())
+	require.Len(t, sets[1].Extras(), 1)
+	assert.Equal(t, filepath.Join(root, "INBOX", "cur", "13:2,S"), sets[1].Extras()[0].Filename())
+
+	// sharing a Subject and body is not enough to be a copy
+	for _, set := range sets {
+		for _, m := range set.Messages {
+			assert.NotEqual(t, filepath.Join(root, "INBOX", "cur", "12:2,S"), m.Filename())
+			assert.NotEqual(t, filepath.Join(root, "Other", "cur", "3:2,S"), m.Filename())
+		}
+	}
+}
+
+func TestFilter_MergeDuplicates(t *testing.T) {
+	t.Parallel()
+
+	f, root := mkDedupeFilter(t)
+
+	sets, err := f.FindDuplicates([]string{"INBOX", "gmail.All_Mail"})
+	require.NoError(t, err)
+	require.Len(t, sets, 2)
+	require.Equal(t, "<dupe@example.com>", sets[0].Key)
+
+	f.SetDryRun(true)
+	actions := make(ActionsSummary)
+	err = f.MergeDuplicates(actions, sets[0])
+	require.NoError(t, err)
+	assert.Equal(t, ActionsSummary{
+		"Merged keywords into kept copy": 1,
+		"Removed duplicate":              1,
+	}, actions)
+	assert.FileExists(t, filepath.Join(root, "INBOX", "cur", "10:2,S"))
+
+	f.SetDryRun(false)
+	err = f.MergeDuplicates(actions, sets[0])
+	require.NoError(t, err)
+	assert.NoFileExists(t, filepath.Join(root, "INBOX", "cur", "10:2,S"))
+
+	msg, err := f.Message("gmail.All_Mail", "11:2,S")
+	require.NoError(t, err)
+
+	ks, err := msg.Keywords()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"Family", `\Inbox`}, ks)
+}
@@ -8,6 +8,7 @@ import (
 	"time"
 
 	"github.com/zostay/dotfiles-go/internal/dotfiles"
+	"github.com/zostay/dotfiles-go/internal/fssafe"
 )
 
 type skip = struct{}
@@ -51,6 +52,7 @@ func init() {
 type Filter struct {
 	mailRoot string        // maildir to filter
 	rules    CompiledRules // the compiled filter rules
+	lists    AddressLists  // the named lists the rules may refer to
 
 	limitRecent time.Duration // if set, only message files newer than this will be filtered
 
@@ -59,7 +61,27 @@ type Filter struct {
 
 	now time.Time // the notion of "now" for the script is program start
 
-	allowSendingEmail bool // unless set, no email forwarding will be performed
+	allowSendingEmail bool                // unless set, no email forwarding will be performed
+	smtp              *SMTPConfig         // the outgoing mail configuration
+	creds             CredentialsProvider // provides the secrets needed to send mail
+	mailers           map[string]*Mailer  // mailers by SMTP account name, built on first use
+	replies           *ReplyLog           // records automatic replies, opened on first use
+	forwards          *ForwardLedger      // records forwarded messages, opened on first use
+	queue             *Queue              // holds outgoing mail to retry, opened on first use
+
+	threads  *ThreadIndex // the thread index, built on first use
+	contacts *AddressBook // the address book used to match contacts
+
+	ruleStats *RuleStats // counts rule matches, opened on first use
+
+	spam      *SpamModel         // the spam classifier, loaded on first use
+	spamStore fssafe.LoaderSaver // where the spam classifier is kept
+
+	index      *Index             // the full-text index, loaded on first use
+	indexErr   error              // the error from loading the full-text index
+	indexStore fssafe.LoaderSaver // where the full-text index is kept
+
+	fsckLost string // where Fsck moves orphaned files, set on first use
 }
 
 // NewFilter loads the rules and prepares the system for message filtering.
@@ -68,16 +90,25 @@ func NewFilter(
 	primaryRules,
 	localRules string,
 ) (*Filter, error) {
-	f, err := LoadRules(primaryRules, localRules)
+	c, err := LoadConfig(primaryRules, localRules)
 	if err != nil {
 		return nil, err
 	}
 
-	return &Filter{
+	fi := &Filter{
 		mailRoot: root,
-		rules:    f,
+		rules:    c.Rules,
+		smtp:     c.SMTP,
+		lists:    c.Lists,
+		creds:    DefaultCredentials,
 		now:      time.Now(),
-	}, nil
+	}
+
+	if c.Contacts != "" {
+		fi.contacts = NewAddressBook(c.Contacts)
+	}
+
+	return fi, nil
 }
 
 // AllRules is really only useful for printing and debugging.
@@ -97,6 +128,120 @@ func (fi *Filter) SetDryRun(dryRun bool) {
 	fi.dryRun = dryRun
 }
 
+// SetAllowSendingEmail permits forwarding rules to send email when a true
+// value is passed.
+func (fi *Filter) SetAllowSendingEmail(allow bool) {
+	fi.allowSendingEmail = allow
+}
+
+// SetCredentialsProvider changes the provider of the secrets used to send
+// mail. The default is DefaultCredentials.
+func (fi *Filter) SetCredentialsProvider(creds CredentialsProvider) {
+	fi.creds = creds
+	fi.mailers = nil
+}
+
+// SetReplyLog changes the ReplyLog used to rate limit automatic replies. The
+// default is kept in the file named by DefaultReplyLogPath.
+func (fi *Filter) SetReplyLog(l *ReplyLog) {
+	fi.replies = l
+}
+
+// replyLog returns the ReplyLog, opening the default one on first use.
+func (fi *Filter) replyLog() *ReplyLog {
+	if fi.replies == nil {
+		fi.replies = NewReplyLog(fssafe.NewFileSystemLoaderSaver(DefaultReplyLogPath()))
+	}
+	return fi.replies
+}
+
+// SetQueue changes the Queue used to hold outgoing mail that failed to send.
+// The default is kept in the folder named by DefaultQueuePath.
+func (fi *Filter) SetQueue(q *Queue) {
+	fi.queue = q
+	fi.mailers = nil
+}
+
+// Queue returns the Queue, opening the default one on first use.
+func (fi *Filter) Queue() *Queue {
+	if fi.queue == nil {
+		fi.queue = NewQueue(DefaultQueuePath())
+	}
+	return fi.queue
+}
+
+// FlushQueue retries sending the queued messages that are due, or all of them
+// if force is true. Forwards recorded in the ForwardLedger are updated to
+// match. It does nothing in dry run mode.
+func (fi *Filter) FlushQueue(force bool) (*QueueFlushReport, error) {
+	if fi.dryRun {
+		return &QueueFlushReport{}, nil
+	}
+
+	report, err := fi.Queue().Flush(time.Now(), force, func(account string) (*Mailer, error) {
+		return fi.smtp.Mailer(account, fi.creds)
+	})
+	if report == nil {
+		return nil, err
+	}
+
+	sent := make([]string, len(report.Sent))
+	for i, e := range report.Sent {
+		sent[i] = e.ID
+	}
+
+	gaveUp := make([]string, len(report.GaveUp))
+	for i, f := range report.GaveUp {
+		gaveUp[i] = f.Entry.ID
+	}
+
+	if lerr := fi.ForwardLedger().Delivered(sent...); lerr != nil && err == nil {
+		err = lerr
+	}
+
+	// a message delivered to some recipients stays queued for the rest
+	for _, fs := range [][]QueueFailure{report.Failed, report.GaveUp} {
+		for _, f := range fs {
+			if lerr := fi.ForwardLedger().Pending(f.Entry.ID, f.Entry.To); lerr != nil && err == nil {
+				err = lerr
+			}
+		}
+	}
+
+	if lerr := fi.ForwardLedger().Undelivered(gaveUp...); lerr != nil && err == nil {
+		err = lerr
+	}
+
+	return report, err
+}
+
+// mailer returns the Mailer for the named SMTP account, building it on first
+// use.
+func (fi *Filter) mailer(name string) (*Mailer, error) {
+	if m, ok := fi.mailers[name]; ok {
+		return m, nil
+	}
+
+	m, err := fi.smtp.Mailer(name, fi.creds)
+	if err != nil {
+		return nil, err
+	}
+
+	m.Transport = &QueueingTransport{
+		Queue:     fi.Queue(),
+		Account:   name,
+		Transport: m.Transport,
+		Now:       func() time.Time { return fi.now },
+	}
+
+	if fi.mailers == nil {
+		fi.mailers = make(map[string]*Mailer)
+	}
+	fi.mailers[name] = m
+
+	return m, nil
+}
+
 // UseNow changes the notion of "now" for the filter tooling. Helpful for
 // testing, at least.
 func (fi *Filter) UseNow(now time.Time) {
@@ -383,12 +528,13 @@ func (fi *Filter) ApplyRules(msg *Message, rules []*CompiledRule) ([]string, err
 func (fi *Filter) ApplyRule(m *Message, c *CompiledRule) ([]string, error) {
 	var (
 		fail    string
+		skipped bool
 		passes  = make([]string, 0)
 		actions []string
 	)
 
 	for _, skippable := range skipTests {
-		r, err := skippable(m, c)
+		r, err := skippable(fi, m, c)
 
 		if err != nil {
 			cp.Fcolor(os.Stderr,
@@ -403,6 +549,7 @@ func (fi *Filter) ApplyRule(m *Message, c *CompiledRule) ([]string, error) {
 			passes = append(passes, r.reason)
 		} else {
 			fail = r.reason
+			skipped = true
 			break
 		}
 	}
@@ -412,8 +559,9 @@ func (fi *Filter) ApplyRule(m *Message, c *CompiledRule) ([]string, error) {
 	// }
 
 	tests := 0
+	matched := true
 	for _, applies := range ruleTests {
-		r, err := applies(m, c, &tests)
+		r, err := applies(fi, m, c, &tests)
 
 		if err != nil {
 			cp.Fcolor(os.Stderr,
@@ -428,6 +576,14 @@ func (fi *Filter) ApplyRule(m *Message, c *CompiledRule) ([]string, error) {
 			passes = append(passes, r.reason)
 		} else {
 			fail = r.reason
+			matched = false
+		}
+	}
+
+	// a skipped rule still counts as matched, it just had nothing to do
+	if matched && tests > 0 && skipped {
+		if err := fi.recordRuleMatch(c, false); err != nil {
+			return actions, err
 		}
 	}
 
@@ -484,9 +640,34 @@ func (fi *Filter) ApplyRule(m *Message, c *CompiledRule) ([]string, error) {
 
 		debugLogOp("LABELING", m, c.Label)
 
+		if fi.threads != nil {
+			err := fi.threads.AddLabels(m, c.Label...)
+			if err != nil {
+				return actions, err
+			}
+		}
+
 		actions = append(actions, "Labeled "+strings.Join(c.Label, ", "))
 	}
 
+	if c.IsLabelingThread() {
+		if !fi.dryRun {
+			err := m.AddKeyword(c.LabelThread...)
+			if err != nil {
+				return actions, err
+			}
+
+			err = fi.labelThread(m, c.LabelThread)
+			if err != nil {
+				return actions, err
+			}
+		}
+
+		debugLogOp("LABELING", m, c.LabelThread)
+
+		actions = append(actions, "Labeled thread "+strings.Join(c.LabelThread, ", "))
+	}
+
 	if c.IsClearing() {
 		if !fi.dryRun {
 			err := m.RemoveKeyword(c.Clear...)
@@ -501,22 +682,49 @@ func (fi *Filter) ApplyRule(m *Message, c *CompiledRule) ([]string, error) {
 	}
 
 	if c.IsForwarding() {
-		if !fi.dryRun && fi.allowSendingEmail {
-			err := m.ForwardTo(c.Forward, fi.now)
+		if fi.allowSendingEmail {
+			res, err := fi.forward(m, c)
 			if err != nil {
 				return actions, err
 			}
-		}
 
-		debugLogOp("FORWARDING", m, AddressListStrings(c.Forward))
+			if len(res.sent) > 0 {
+				debugLogOp("FORWARDING", m, res.sent)
 
-		if fi.allowSendingEmail {
-			actions = append(actions, "Forwarded "+strings.Join(AddressListStrings(c.Forward), ", "))
+				if res.queued {
+					actions = append(actions, "Queued Forward to "+strings.Join(res.sent, ", "))
+				} else {
+					actions = append(actions, "Forwarded "+strings.Join(res.sent, ", "))
+				}
+			}
+
+			if len(res.already) > 0 {
+				actions = append(actions, "Already Forwarded "+strings.Join(res.already, ", "))
+			}
 		} else {
+			debugLogOp("FORWARDING", m, AddressListStrings(c.Forward))
+
 			actions = append(actions, "NOT Forwarded "+strings.Join(AddressListStrings(c.Forward), ", "))
 		}
 	}
 
+	// a skipped reply is reported, but is not an action taken on the message
+	var skips []string
+	if c.IsReplying() {
+		action, replied, err := fi.replyTo(m, c)
+		if err != nil {
+			return actions, err
+		}
+
+		debugLogOp("REPLYING", m, []string{action})
+
+		if replied {
+			actions = append(actions, action)
+		} else {
+			skips = append(skips, action)
+		}
+	}
+
 	if len(actions) > 0 && !fi.dryRun {
 		err := m.Save()
 		if err != nil {
@@ -526,10 +734,18 @@ func (fi *Filter) ApplyRule(m *Message, c *CompiledRule) ([]string, error) {
 
 	if c.IsMoving() {
 		if !fi.dryRun {
+			from := threadMember(m)
 			err := m.MoveTo(fi.mailRoot, c.Move)
 			if err != nil {
 				return actions, err
 			}
+
+			if fi.threads != nil {
+				err := fi.threads.Move(m, from)
+				if err != nil {
+					return actions, err
+				}
+			}
 		}
 
 		debugLogOp("MOVING", m, []string{c.Move})
@@ -537,5 +753,10 @@ func (fi *Filter) ApplyRule(m *Message, c *CompiledRule) ([]string, error) {
 		actions = append(actions, "Moved "+c.Move)
 	}
 
-	return actions, nil
+	err := fi.recordRuleMatch(c, len(actions) > 0)
+	if err != nil {
+		return actions, err
+	}
+
+	return append(actions, skips...), nil
 }
@@ -1,18 +1,24 @@
 package mail
 
 import (
+	"io/fs"
+	"os"
+	"path/filepath"
 	"sort"
 	"testing"
 	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+
+	"github.com/zostay/dotfiles-go/internal/fssafe"
 )
 
 func mkFilter(t *testing.T) *Filter {
 	f, err := NewFilter("test/maildir", "test/rules.yml", "test/local.yml")
 	f.UseNow(time.Date(2022, 11, 22, 23, 11, 59, 0, time.Local))
 	require.NoError(t, err)
+	f.SetRuleStats(NewRuleStats(fssafe.NewTestingLoaderSaver()))
 	return f
 }
 
@@ -22,6 +28,62 @@ func mkFilterDR(t *testing.T) *Filter {
 	return f
 }
 
+// mkTempMailDir copies the test maildir into a temporary directory so tests
+// that modify mail do not modify the fixtures.
+func mkTempMailDir(t *testing.T) string {
+	t.Helper()
+
+	root := t.TempDir()
+	err := filepath.WalkDir("test/maildir", func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel("test/maildir", p)
+		if err != nil {
+			return err
+		}
+
+		dest := filepath.Join(root, rel)
+		if d.IsDir() {
+			return os.MkdirAll(dest, 0700)
+		}
+
+		bs, err := os.ReadFile(p)
+		if err != nil {
+			return err
+		}
+
+		return os.WriteFile(dest, bs, 0600)
+	})
+	require.NoError(t, err)
+
+	return root
+}
+
+// mkTempFilter returns a filter working against a temporary copy of the test
+// maildir.
+func mkTempFilter(t *testing.T) (*Filter, string) {
+	t.Helper()
+
+	root := mkTempMailDir(t)
+	f, err := NewFilter(root, "test/rules.yml", "test/local.yml")
+	require.NoError(t, err)
+	f.UseNow(time.Date(2022, 11, 22, 23, 11, 59, 0, time.Local))
+	f.SetRuleStats(NewRuleStats(fssafe.NewTestingLoaderSaver()))
+	return f, root
+}
+
+// writeTestMessage writes a message into the cur directory of the named folder
+// under the given mail root, creating the folder as needed.
+func writeTestMessage(t *testing.T, root, folder, fn, msg string) {
+	t.Helper()
+
+	require.NoError(t, NewMailDirFolder(root, folder).EnsureExists())
+	err := os.WriteFile(filepath.Join(root, folder, "cur", fn), []byte(msg), 0600)
+	require.NoError(t, err)
+}
+
 func TestNewFilter(t *testing.T) {
 	t.Parallel()
 
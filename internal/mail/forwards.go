@@ -0,0 +1,345 @@
+package mail
+
+import (
+	"errors"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/zostay/go-addr/pkg/addr"
+
+	"github.com/zostay/dotfiles-go/internal/dotfiles"
+	"github.com/zostay/dotfiles-go/internal/fssafe"
+)
+
+// ForwardLedgerFile is the name of the file in the home directory that records
+// every message forwarded by the filter.
+const ForwardLedgerFile = ".label-mail.forwards.yml"
+
+// ForwardRecord records a single forward of a message to a single address.
+type ForwardRecord struct {
+	// To is the address the message was forwarded to.
+	To string `yaml:"to"`
+
+	// Mode is the forwarding mode used.
+	Mode string `yaml:"mode,omitempty"`
+
+	// Sent is the time the message was forwarded.
+	Sent time.Time `yaml:"sent"`
+
+	// Queued is the ID of the message in the queue while the forward is
+	// waiting to be delivered or after it has been given up on. It is empty
+	// once the forward is delivered.
+	Queued string `yaml:"queued,omitempty"`
+
+	// Failed is true if the queue gave up on delivering the forward. The
+	// message is not forwarded to the address again.
+	Failed bool `yaml:"failed,omitempty"`
+}
+
+// ForwardEntry lists all the forwards of a single message.
+type ForwardEntry struct {
+	// Subject is the subject of the message, recorded to make the ledger
+	// easier to read.
+	Subject string `yaml:"subject,omitempty"`
+
+	// Forwards lists each address the message was forwarded to.
+	Forwards []ForwardRecord `yaml:"forwards"`
+}
+
+// ForwardHistoryItem is a single forward of a message returned by
+// ForwardLedger.History.
+type ForwardHistoryItem struct {
+	ForwardRecord
+
+	// Key identifies the message, as returned by Message.DuplicateKey.
+	Key string
+
+	// Subject is the subject of the message.
+	Subject string
+}
+
+// ForwardLedger records which messages have been forwarded to which addresses
+// so that no message is forwarded to the same address twice. Messages are
+// keyed by Message-ID or, lacking that, by a hash of their headers and body.
+type ForwardLedger struct {
+	ls      fssafe.LoaderSaver
+	entries map[string]*ForwardEntry
+	loaded  bool
+}
+
+// DefaultForwardLedgerPath returns the default location of the forward ledger.
+func DefaultForwardLedgerPath() string {
+	return path.Join(dotfiles.HomeDir, ForwardLedgerFile)
+}
+
+// NewForwardLedger returns a ForwardLedger kept using the given loader/saver.
+func NewForwardLedger(ls fssafe.LoaderSaver) *ForwardLedger {
+	return &ForwardLedger{ls: ls}
+}
+
+// load reads the ledger the first time it is needed.
+func (l *ForwardLedger) load() error {
+	if l.loaded {
+		return nil
+	}
+
+	l.entries = make(map[string]*ForwardEntry)
+	err := loadState(l.ls, "forward", &l.entries)
+	if err != nil {
+		return err
+	}
+
+	l.loaded = true
+	return nil
+}
+
+// Forwarded returns true if the message with the given key has already been
+// forwarded to the given address. A forward waiting in the queue or given up
+// on counts, so that it is not queued again.
+func (l *ForwardLedger) Forwarded(key, to string) (bool, error) {
+	if err := l.load(); err != nil {
+		return false, err
+	}
+
+	e, ok := l.entries[key]
+	if !ok {
+		return false, nil
+	}
+
+	for _, r := range e.Forwards {
+		if strings.EqualFold(r.To, to) {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// Record adds forwards of the message with the given key and subject to the
+// ledger and saves it.
+func (l *ForwardLedger) Record(key, subject string, recs ...ForwardRecord) error {
+	if err := l.load(); err != nil {
+		return err
+	}
+
+	e, ok := l.entries[key]
+	if !ok {
+		e = &ForwardEntry{}
+		l.entries[key] = e
+	}
+
+	if subject != "" {
+		e.Subject = subject
+	}
+	e.Forwards = append(e.Forwards, recs...)
+
+	return saveState(l.ls, "forward", l.entries)
+}
+
+// Delivered records that the queued messages with the given IDs have been
+// delivered and saves the ledger.
+func (l *ForwardLedger) Delivered(ids ...string) error {
+	return l.dequeue(ids, true)
+}
+
+// Undelivered marks the forwards of the queued messages with the given IDs as
+// failed, since they were given up on without being delivered, and saves the
+// ledger.
+func (l *ForwardLedger) Undelivered(ids ...string) error {
+	return l.dequeue(ids, false)
+}
+
+// Pending records that the queued message with the given ID is only waiting
+// to be delivered to the given addresses, so its forwards to any other address
+// have been delivered, and saves the ledger.
+func (l *ForwardLedger) Pending(id string, to []string) error {
+	if err := l.load(); err != nil {
+		return err
+	}
+
+	pending := make(map[string]struct{}, len(to))
+	for _, a := range to {
+		pending[strings.ToLower(a)] = struct{}{}
+	}
+
+	changed := false
+	for _, e := range l.entries {
+		for i := range e.Forwards {
+			r := &e.Forwards[i]
+			if _, ok := pending[strings.ToLower(r.To)]; r.Queued != id || r.Failed || ok {
+				continue
+			}
+
+			r.Queued = ""
+			changed = true
+		}
+	}
+
+	if !changed {
+		return nil
+	}
+
+	return saveState(l.ls, "forward", l.entries)
+}
+
+// dequeue implements Delivered and Undelivered.
+func (l *ForwardLedger) dequeue(ids []string, delivered bool) error {
+	if len(ids) == 0 {
+		return nil
+	}
+
+	if err := l.load(); err != nil {
+		return err
+	}
+
+	idSet := make(map[string]struct{}, len(ids))
+	for _, id := range ids {
+		idSet[id] = struct{}{}
+	}
+
+	changed := false
+	for _, e := range l.entries {
+		for i := range e.Forwards {
+			r := &e.Forwards[i]
+			if _, ok := idSet[r.Queued]; r.Queued == "" || r.Failed || !ok {
+				continue
+			}
+
+			changed = true
+			if delivered {
+				r.Queued = ""
+			} else {
+				r.Failed = true
+			}
+		}
+	}
+
+	if !changed {
+		return nil
+	}
+
+	return saveState(l.ls, "forward", l.entries)
+}
+
+// History returns every forward in the ledger, oldest first.
+func (l *ForwardLedger) History() ([]ForwardHistoryItem, error) {
+	if err := l.load(); err != nil {
+		return nil, err
+	}
+
+	items := make([]ForwardHistoryItem, 0, len(l.entries))
+	for key, e := range l.entries {
+		for _, r := range e.Forwards {
+			items = append(items, ForwardHistoryItem{r, key, e.Subject})
+		}
+	}
+
+	sort.Slice(items, func(i, j int) bool {
+		if !items[i].Sent.Equal(items[j].Sent) {
+			return items[i].Sent.Before(items[j].Sent)
+		}
+		if items[i].Key != items[j].Key {
+			return items[i].Key < items[j].Key
+		}
+		return items[i].To < items[j].To
+	})
+
+	return items, nil
+}
+
+// SetForwardLedger changes the ForwardLedger used to prevent repeat forwards.
+// The default is kept in the file named by DefaultForwardLedgerPath.
+func (fi *Filter) SetForwardLedger(l *ForwardLedger) {
+	fi.forwards = l
+}
+
+// ForwardLedger returns the ForwardLedger, opening the default one on first
+// use.
+func (fi *Filter) ForwardLedger() *ForwardLedger {
+	if fi.forwards == nil {
+		fi.forwards = NewForwardLedger(fssafe.NewFileSystemLoaderSaver(DefaultForwardLedgerPath()))
+	}
+	return fi.forwards
+}
+
+// forwardResult describes what happened when forwarding a message.
+type forwardResult struct {
+	sent    []string // addresses the message was forwarded to
+	already []string // addresses skipped because they were forwarded to before
+	queued  bool     // true if the message was queued for later delivery
+}
+
+// forward sends the message to every address the rule forwards to that is not
+// already recorded in the ledger.
+func (fi *Filter) forward(m *Message, c *CompiledRule) (*forwardResult, error) {
+	key, err := m.DuplicateKey()
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		res forwardResult
+		tos addr.AddressList
+	)
+	for _, to := range c.Forward {
+		done, err := fi.ForwardLedger().Forwarded(key, to.Address())
+		if err != nil {
+			return nil, err
+		}
+
+		if done {
+			res.already = append(res.already, to.Address())
+		} else {
+			tos = append(tos, to)
+		}
+	}
+
+	if len(tos) == 0 {
+		return &res, nil
+	}
+
+	if fi.dryRun {
+		res.sent = AddressListStrings(tos)
+		return &res, nil
+	}
+
+	mailer, err := fi.mailer(c.SMTP)
+	if err != nil {
+		return nil, err
+	}
+
+	res.sent, err = m.ForwardTo(mailer, c.ForwardMode, tos, fi.now)
+	res.queued = errors.Is(err, ErrQueued)
+	if err != nil && !res.queued {
+		return nil, err
+	}
+
+	// a queued forward stays pending until the queue delivers it
+	queued := make(map[string]string)
+	var qerr *QueuedError
+	if errors.As(err, &qerr) {
+		for _, to := range qerr.To {
+			queued[strings.ToLower(to)] = qerr.ID
+		}
+	}
+
+	mode := c.ForwardMode
+	if mode == "" {
+		mode = ForwardInline
+	}
+
+	recs := make([]ForwardRecord, len(res.sent))
+	for i, to := range res.sent {
+		recs[i] = ForwardRecord{to, mode, fi.now, queued[strings.ToLower(to)], false}
+	}
+
+	subject, _ := m.Subject()
+	err = fi.ForwardLedger().Record(key, subject, recs...)
+	if err != nil {
+		return nil, err
+	}
+
+	return &res, nil
+}
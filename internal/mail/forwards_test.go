@@ -0,0 +1,132 @@
+package mail
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zostay/go-addr/pkg/addr"
+
+	"github.com/zostay/dotfiles-go/internal/fssafe"
+)
+
+func TestForwardLedger(t *testing.T) {
+	t.Parallel()
+
+	ls := fssafe.NewTestingLoaderSaver()
+	l := NewForwardLedger(ls)
+
+	done, err := l.Forwarded("<a@example.com>", "me@example.com")
+	require.NoError(t, err)
+	assert.False(t, done)
+
+	later := time.Date(2022, 11, 22, 23, 11, 59, 0, time.UTC)
+	earlier := later.Add(-time.Hour)
+	require.NoError(t, l.Record("<a@example.com>", "A", ForwardRecord{"me@example.com", ForwardInline, later, "", false}))
+	require.NoError(t, l.Record("<b@example.com>", "B", ForwardRecord{"you@example.com", ForwardAttach, earlier, "", false}))
+
+	// reload from the saved state
+	l = NewForwardLedger(ls)
+
+	done, err = l.Forwarded("<a@example.com>", "ME@example.com")
+	require.NoError(t, err)
+	assert.True(t, done)
+
+	done, err = l.Forwarded("<a@example.com>", "you@example.com")
+	require.NoError(t, err)
+	assert.False(t, done)
+
+	h, err := l.History()
+	require.NoError(t, err)
+	assert.Equal(t, []ForwardHistoryItem{
+		{ForwardRecord{"you@example.com", ForwardAttach, earlier, "", false}, "<b@example.com>", "B"},
+		{ForwardRecord{"me@example.com", ForwardInline, later, "", false}, "<a@example.com>", "A"},
+	}, h)
+
+	// queued forwards count until they are delivered or given up on
+	require.NoError(t, l.Record("<c@example.com>", "C",
+		ForwardRecord{"me@example.com", ForwardInline, later, "q1", false},
+		ForwardRecord{"you@example.com", ForwardInline, later, "q2", false}))
+
+	done, err = l.Forwarded("<c@example.com>", "you@example.com")
+	require.NoError(t, err)
+	assert.True(t, done)
+
+	require.NoError(t, l.Delivered("q1"))
+	require.NoError(t, l.Undelivered("q2"))
+
+	l = NewForwardLedger(ls)
+
+	done, err = l.Forwarded("<c@example.com>", "me@example.com")
+	require.NoError(t, err)
+	assert.True(t, done)
+
+	// a forward given up on stays in the ledger, so it is not sent again
+	done, err = l.Forwarded("<c@example.com>", "you@example.com")
+	require.NoError(t, err)
+	assert.True(t, done)
+
+	h, err = l.History()
+	require.NoError(t, err)
+	require.Len(t, h, 4)
+	assert.Equal(t, ForwardRecord{"me@example.com", ForwardInline, later, "", false}, h[2].ForwardRecord)
+	assert.Equal(t, ForwardRecord{"you@example.com", ForwardInline, later, "q2", true}, h[3].ForwardRecord)
+
+	// a message delivered to some addresses stays queued for the rest
+	require.NoError(t, l.Record("<d@example.com>", "D",
+		ForwardRecord{"me@example.com", ForwardInline, later, "q3", false},
+		ForwardRecord{"you@example.com", ForwardInline, later, "q3", false}))
+	require.NoError(t, l.Pending("q3", []string{"YOU@example.com"}))
+
+	l = NewForwardLedger(ls)
+
+	h, err = l.History()
+	require.NoError(t, err)
+	require.Len(t, h, 6)
+	assert.Equal(t, ForwardRecord{"me@example.com", ForwardInline, later, "", false}, h[4].ForwardRecord)
+	assert.Equal(t, ForwardRecord{"you@example.com", ForwardInline, later, "q3", false}, h[5].ForwardRecord)
+}
+
+func TestFilter_ForwardOnce(t *testing.T) {
+	t.Parallel()
+
+	f, root := mkTempFilter(t)
+	outbox := filepath.Join(t.TempDir(), "outbox")
+	f.smtp = &SMTPConfig{SMTPAccount: SMTPAccount{From: "me@example.com", Outbox: outbox}}
+	f.SetCredentialsProvider(NewTestingCredentials())
+	f.SetForwardLedger(NewForwardLedger(fssafe.NewTestingLoaderSaver()))
+	f.SetAllowSendingEmail(true)
+
+	to, err := addr.ParseEmailAddressList("you@example.com")
+	require.NoError(t, err)
+	rule := &CompiledRule{Match: Match{Folder: "INBOX", Subject: "Foo"}, Forward: to}
+
+	m, err := f.Message("INBOX", "1:2,S")
+	require.NoError(t, err)
+	actions, err := f.ApplyRule(m, rule)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"Forwarded you@example.com"}, actions)
+
+	bs, err := os.ReadFile(filepath.Join(root, "INBOX", "cur", "1:2,S"))
+	require.NoError(t, err)
+	assert.Contains(t, string(bs), "X-Zostay-Forwarded: you@example.com")
+
+	m, err = f.Message("INBOX", "1:2,S")
+	require.NoError(t, err)
+	actions, err = f.ApplyRule(m, rule)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"Already Forwarded you@example.com"}, actions)
+
+	des, err := os.ReadDir(filepath.Join(outbox, "new"))
+	require.NoError(t, err)
+	assert.Len(t, des, 1)
+
+	h, err := f.ForwardLedger().History()
+	require.NoError(t, err)
+	require.Len(t, h, 1)
+	assert.Equal(t, "Foo", h[0].Subject)
+	assert.Equal(t, "you@example.com", h[0].To)
+}
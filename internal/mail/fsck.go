@@ -0,0 +1,350 @@
+package mail
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/zostay/dotfiles-go/internal/dotfiles"
+)
+
+// FsckLostDir is the name of the maildir folder in the home directory that
+// orphaned files found in tmp are moved into during repair so they can be
+// looked over by hand. It is kept outside the mail root so that the filter and
+// mail sync tools leave it alone.
+const FsckLostDir = ".label-mail.lost+found"
+
+// DefaultFsckLostPath returns the default location of the folder holding the
+// orphaned files moved aside by Filter.Fsck.
+func DefaultFsckLostPath() string {
+	return path.Join(dotfiles.HomeDir, FsckLostDir)
+}
+
+// SetFsckLostPath changes the location of the maildir folder that orphaned
+// files are moved into by Filter.Fsck. The default is DefaultFsckLostPath.
+func (fi *Filter) SetFsckLostPath(dir string) {
+	fi.fsckLost = dir
+}
+
+// fsckLostFolder returns the maildir folder that orphaned files are moved into.
+func (fi *Filter) fsckLostFolder() *DirFolder {
+	if fi.fsckLost == "" {
+		fi.fsckLost = DefaultFsckLostPath()
+	}
+	return NewMailDirFolder(fi.fsckLost, "")
+}
+
+// The kinds of problem reported by Filter.Fsck.
+const (
+	FsckMissingDir     = "missing directory"
+	FsckInvalidName    = "invalid file name"
+	FsckEmptyMessage   = "empty message"
+	FsckDuplicateKey   = "duplicate key"
+	FsckAbandonedWrite = "abandoned rewrite in tmp"
+	FsckOrphanedTemp   = "orphaned file in tmp"
+)
+
+// validMessageName matches the name of a message file: a unique key, followed
+// by an optional info part holding flags.
+var validMessageName = regexp.MustCompile(`^[^:]+(:(1,.*|2,[A-Za-z]*))?$`)
+
+// FsckProblem records a single problem found by Filter.Fsck.
+type FsckProblem struct {
+	Path    string // the directory or file with the problem
+	Problem string // the kind of problem found
+	Fix     string // the repair to make, empty if it must be repaired by hand
+	Fixed   bool   // true if the repair was made
+	Err     error  // the error from the failed repair, if any
+}
+
+// String describes the problem and its repair.
+func (p FsckProblem) String() string {
+	var repair string
+	switch {
+	case p.Fix == "":
+		repair = "repair by hand"
+	case p.Err != nil:
+		repair = fmt.Sprintf("failed to %s: %v", p.Fix, p.Err)
+	case p.Fixed:
+		repair = "repaired: " + p.Fix
+	default:
+		repair = "--repair will " + p.Fix
+	}
+
+	return fmt.Sprintf("%s: %s (%s)", p.Problem, p.Path, repair)
+}
+
+// FsckReport is the structured summary of the problems found by Filter.Fsck.
+type FsckReport struct {
+	// Repair is true if repairs were made to the mail root.
+	Repair bool
+
+	// Problems lists the problems found.
+	Problems []FsckProblem
+}
+
+// Unrepaired returns the number of problems that remain.
+func (r *FsckReport) Unrepaired() int {
+	n := 0
+	for _, p := range r.Problems {
+		if !p.Fixed {
+			n++
+		}
+	}
+	return n
+}
+
+// String returns a summary of the report suitable for display on the console.
+func (r *FsckReport) String() string {
+	if len(r.Problems) == 0 {
+		return "No problems found.\n"
+	}
+
+	var out strings.Builder
+	for _, p := range r.Problems {
+		fmt.Fprintf(&out, " %s\n", p)
+	}
+
+	fmt.Fprintf(&out, "Found %d problems, %d repaired.\n",
+		len(r.Problems), len(r.Problems)-r.Unrepaired())
+
+	return out.String()
+}
+
+// fsckEntry is a single message file found by Filter.Fsck.
+type fsckEntry struct {
+	path string // the path to the file
+	rd   string // new or cur
+	name string // the file name
+	size int64  // the size of the file
+}
+
+// fsckCheck is used to make the repairs for a single problem.
+type fsckCheck struct {
+	report *FsckReport
+	repair bool
+}
+
+// problem records the problem and, when repairing, makes the repair using the
+// given function, which may be nil if the problem must be repaired by hand.
+func (c *fsckCheck) problem(p, problem, fix string, repair func() error) {
+	fp := FsckProblem{Path: p, Problem: problem, Fix: fix}
+	if c.repair && repair != nil {
+		fp.Err = repair()
+		fp.Fixed = fp.Err == nil
+	}
+
+	c.report.Problems = append(c.report.Problems, fp)
+}
+
+// Fsck checks the structure of the given folders, or every folder if none are
+// given. It finds folders missing their new, cur, or tmp directories, message
+// files with invalid names or empty contents, keys used by more than one
+// message file, and files left in tmp by writes that never finished. Files in
+// tmp are only considered once they are older than StaleTempAge.
+//
+// If repair is true and this is not a dry run, every problem that can be
+// repaired safely is repaired: missing directories are created, empty messages
+// are removed, identical copies of a message are removed, and files left in tmp
+// are removed when the message they were rewriting still exists or moved into
+// the folder named by SetFsckLostPath when it does not.
+func (fi *Filter) Fsck(onlyFolders []string, repair bool) (*FsckReport, error) {
+	folders := onlyFolders
+	if len(folders) == 0 {
+		var err error
+		folders, err = fi.AllFolders()
+		if err != nil {
+			return nil, fmt.Errorf("unable to get a list of folders to check: %w", err)
+		}
+	}
+
+	sort.Strings(folders)
+
+	report := &FsckReport{Repair: repair && !fi.dryRun}
+	check := &fsckCheck{report, report.Repair}
+	for _, folder := range folders {
+		if err := fi.fsckFolder(check, folder); err != nil {
+			return report, err
+		}
+	}
+
+	return report, nil
+}
+
+// fsckFolder implements Fsck for a single folder.
+func (fi *Filter) fsckFolder(check *fsckCheck, folder string) error {
+	f := fi.folder(folder)
+
+	var missing []string
+	for _, dir := range append(f.MessageDirPaths(), f.TempDirPath()) {
+		if _, err := os.Stat(dir); os.IsNotExist(err) {
+			missing = append(missing, dir)
+		} else if err != nil {
+			return fmt.Errorf("unable to check %q: %w", dir, err)
+		}
+	}
+
+	for _, dir := range missing {
+		check.problem(dir, FsckMissingDir, "create it", f.EnsureExists)
+	}
+
+	entries := make(map[string][]fsckEntry)
+	for _, dir := range f.MessageDirPaths() {
+		files, err := fsckFiles(dir)
+		if err != nil {
+			return err
+		}
+
+		for _, info := range files {
+			e := fsckEntry{path.Join(dir, info.Name()), path.Base(dir), info.Name(), info.Size()}
+
+			if !validMessageName.MatchString(e.name) {
+				check.problem(e.path, FsckInvalidName, "", nil)
+			}
+
+			if e.size == 0 {
+				check.problem(e.path, FsckEmptyMessage, "remove it", func() error {
+					return os.Remove(e.path)
+				})
+				continue
+			}
+
+			key := indexKey(e.name)
+			entries[key] = append(entries[key], e)
+		}
+	}
+
+	keys := make([]string, 0, len(entries))
+	for key, es := range entries {
+		if len(es) > 1 {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		if err := fsckDuplicates(check, entries[key]); err != nil {
+			return err
+		}
+	}
+
+	tmp, err := fsckFiles(f.TempDirPath())
+	if err != nil {
+		return err
+	}
+
+	for _, info := range tmp {
+		if fi.now.Sub(info.ModTime()) <= StaleTempAge {
+			continue
+		}
+
+		p := path.Join(f.TempDirPath(), info.Name())
+		remove := func() error { return os.Remove(p) }
+
+		lost := fi.fsckLostFolder()
+		target := path.Join(lost.Path(), "cur", info.Name())
+
+		switch _, rewriting := entries[indexKey(info.Name())]; {
+		case rewriting:
+			check.problem(p, FsckAbandonedWrite, "remove it", remove)
+		case info.Size() == 0:
+			check.problem(p, FsckOrphanedTemp, "remove it", remove)
+		default:
+			check.problem(p, FsckOrphanedTemp, "move it to "+target, func() error {
+				if err := lost.EnsureExists(); err != nil {
+					return err
+				}
+
+				if _, err := os.Stat(target); err == nil {
+					return fmt.Errorf("%q already exists", target)
+				}
+
+				return os.Rename(p, target)
+			})
+		}
+	}
+
+	return nil
+}
+
+// fsckFiles returns the file info of the regular files in the directory,
+// skipping hidden files. A missing directory has no files.
+func fsckFiles(dir string) ([]os.FileInfo, error) {
+	des, err := os.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("unable to read %q: %w", dir, err)
+	}
+
+	infos := make([]os.FileInfo, 0, len(des))
+	for _, de := range des {
+		if de.IsDir() || strings.HasPrefix(de.Name(), ".") {
+			continue
+		}
+
+		info, err := de.Info()
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return nil, fmt.Errorf("unable to stat %q: %w", path.Join(dir, de.Name()), err)
+		}
+
+		infos = append(infos, info)
+	}
+
+	return infos, nil
+}
+
+// fsckDuplicates reports message files sharing the same key. When every copy
+// is identical, the one in cur with the most flags is kept and the others are
+// removed. Otherwise, they must be sorted out by hand.
+func fsckDuplicates(check *fsckCheck, es []fsckEntry) error {
+	sort.Slice(es, func(i, j int) bool {
+		if es[i].rd != es[j].rd {
+			return es[i].rd == "cur"
+		}
+		if len(es[i].name) != len(es[j].name) {
+			return len(es[i].name) > len(es[j].name)
+		}
+		return es[i].name < es[j].name
+	})
+
+	keep, err := os.ReadFile(es[0].path)
+	if err != nil {
+		return fmt.Errorf("unable to read %q: %w", es[0].path, err)
+	}
+
+	identical := true
+	for _, e := range es[1:] {
+		bs, err := os.ReadFile(e.path)
+		if err != nil {
+			return fmt.Errorf("unable to read %q: %w", e.path, err)
+		}
+
+		if !bytes.Equal(keep, bs) {
+			identical = false
+			break
+		}
+	}
+
+	if !identical {
+		for _, e := range es {
+			check.problem(e.path, FsckDuplicateKey, "", nil)
+		}
+		return nil
+	}
+
+	for _, e := range es[1:] {
+		e := e
+		check.problem(e.path, FsckDuplicateKey, "remove this copy of "+es[0].path, func() error {
+			return os.Remove(e.path)
+		})
+	}
+
+	return nil
+}
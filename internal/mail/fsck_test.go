@@ -0,0 +1,139 @@
+package mail
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// mkFsckFilter returns a filter on a mail root with one of every problem Fsck
+// looks for.
+func mkFsckFilter(t *testing.T) (*Filter, string) {
+	t.Helper()
+
+	f, root := mkTempFilter(t)
+	f.SetFsckLostPath(filepath.Join(t.TempDir(), "lost+found"))
+
+	write := func(fn, content string) {
+		t.Helper()
+
+		p := filepath.Join(root, fn)
+		require.NoError(t, os.MkdirAll(filepath.Dir(p), 0700))
+		require.NoError(t, os.WriteFile(p, []byte(content), 0600))
+	}
+
+	stale := func(fn, content string) {
+		t.Helper()
+
+		write(fn, content)
+		old := time.Date(2022, 11, 20, 0, 0, 0, 0, time.Local)
+		require.NoError(t, os.Chtimes(filepath.Join(root, fn), old, old))
+	}
+
+	write("Broken/cur/40:2,S", "Subject: Broken\r\n\r\nBroken.\r\n")
+	write("INBOX/cur/50:2,S", "")
+	write("INBOX/cur/51:flags", "Subject: Bad name\r\n\r\nBad.\r\n")
+	write("INBOX/new/60", "Subject: Same\r\n\r\nSame.\r\n")
+	write("INBOX/cur/60:2,S", "Subject: Same\r\n\r\nSame.\r\n")
+	write("INBOX/new/61", "Subject: Different\r\n\r\nOne.\r\n")
+	write("INBOX/cur/61:2,S", "Subject: Different\r\n\r\nTwo.\r\n")
+	stale("INBOX/tmp/1:2,S", "Subject: Foo\r\n\r\nHalf")
+	stale("INBOX/tmp/70", "Subject: Lost\r\n\r\nLost.\r\n")
+	stale("INBOX/tmp/71", "")
+	write("INBOX/tmp/72", "Subject: Delivering\r\n\r\n")
+
+	return f, root
+}
+
+// fsckProblems lists the problems in the report with paths relative to root.
+func fsckProblems(root string, report *FsckReport) []string {
+	ps := make([]string, len(report.Problems))
+	for i, p := range report.Problems {
+		ps[i] = p.Problem + " " + strings.TrimPrefix(p.Path, root+"/")
+	}
+	return ps
+}
+
+func TestFilter_Fsck(t *testing.T) {
+	t.Parallel()
+
+	f, root := mkFsckFilter(t)
+
+	report, err := f.Fsck(nil, false)
+	require.NoError(t, err)
+
+	assert.False(t, report.Repair)
+	assert.Equal(t, []string{
+		FsckMissingDir + " Broken/new",
+		FsckMissingDir + " Broken/tmp",
+		FsckEmptyMessage + " INBOX/cur/50:2,S",
+		FsckInvalidName + " INBOX/cur/51:flags",
+		FsckDuplicateKey + " INBOX/new/60",
+		FsckDuplicateKey + " INBOX/cur/61:2,S",
+		FsckDuplicateKey + " INBOX/new/61",
+		FsckAbandonedWrite + " INBOX/tmp/1:2,S",
+		FsckOrphanedTemp + " INBOX/tmp/70",
+		FsckOrphanedTemp + " INBOX/tmp/71",
+	}, fsckProblems(root, report))
+	assert.Equal(t, 10, report.Unrepaired())
+	assert.Contains(t, report.String(), "--repair will remove it")
+	assert.Contains(t, report.String(), "Found 10 problems, 0 repaired.")
+
+	// nothing changed on disk
+	assert.FileExists(t, filepath.Join(root, "INBOX", "cur", "50:2,S"))
+	assert.FileExists(t, filepath.Join(root, "INBOX", "tmp", "70"))
+	assert.NoDirExists(t, filepath.Join(root, "Broken", "new"))
+
+	f.SetDryRun(true)
+	report, err = f.Fsck(nil, true)
+	require.NoError(t, err)
+	assert.False(t, report.Repair)
+	assert.Equal(t, 10, report.Unrepaired())
+}
+
+func TestFilter_Fsck_Repair(t *testing.T) {
+	t.Parallel()
+
+	f, root := mkFsckFilter(t)
+
+	report, err := f.Fsck(nil, true)
+	require.NoError(t, err)
+
+	assert.True(t, report.Repair)
+	assert.Len(t, report.Problems, 10)
+	assert.Equal(t, 3, report.Unrepaired())
+	assert.Contains(t, report.String(), "Found 10 problems, 7 repaired.")
+	for _, p := range report.Problems {
+		assert.NoError(t, p.Err, p.Path)
+	}
+
+	assert.DirExists(t, filepath.Join(root, "Broken", "new"))
+	assert.DirExists(t, filepath.Join(root, "Broken", "tmp"))
+	assert.NoFileExists(t, filepath.Join(root, "INBOX", "cur", "50:2,S"))
+	assert.NoFileExists(t, filepath.Join(root, "INBOX", "new", "60"))
+	assert.FileExists(t, filepath.Join(root, "INBOX", "cur", "60:2,S"))
+	assert.NoFileExists(t, filepath.Join(root, "INBOX", "tmp", "1:2,S"))
+	assert.FileExists(t, filepath.Join(root, "INBOX", "cur", "1:2,S"))
+	assert.NoFileExists(t, filepath.Join(root, "INBOX", "tmp", "71"))
+	assert.FileExists(t, filepath.Join(root, "INBOX", "tmp", "72"))
+	assert.FileExists(t, filepath.Join(f.fsckLost, "cur", "70"))
+	assert.NoDirExists(t, filepath.Join(root, "lost+found"))
+
+	// only the problems needing a human remain
+	report, err = f.Fsck(nil, true)
+	require.NoError(t, err)
+	assert.Equal(t, []string{
+		FsckInvalidName + " INBOX/cur/51:flags",
+		FsckDuplicateKey + " INBOX/cur/61:2,S",
+		FsckDuplicateKey + " INBOX/new/61",
+	}, fsckProblems(root, report))
+
+	report, err = f.Fsck([]string{"Other"}, false)
+	require.NoError(t, err)
+	assert.Equal(t, "No problems found.\n", report.String())
+}
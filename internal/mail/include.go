@@ -0,0 +1,179 @@
+package mail
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/zostay/dotfiles-go/internal/dotfiles"
+)
+
+// RulesDir returns the directory of rule fragments that goes with the given
+// primary rules file. This is the primary file name with the extension
+// replaced by ".d", so ~/.label-mail.yml goes with ~/.label-mail.d.
+func RulesDir(primary string) string {
+	return strings.TrimSuffix(primary, filepath.Ext(primary)) + ".d"
+}
+
+// resolveRulesPath returns the path relative to the directory of the rules
+// file that named it. A leading "~/" names the home directory.
+func resolveRulesPath(from, p string) string {
+	switch {
+	case filepath.IsAbs(p):
+		return p
+	case strings.HasPrefix(p, "~/"):
+		return filepath.Join(dotfiles.HomeDir, p[2:])
+	default:
+		return filepath.Join(filepath.Dir(from), p)
+	}
+}
+
+// ruleLoader gathers the rules and other configuration from a primary rules
+// file and all the files it includes.
+type ruleLoader struct {
+	envs []string // the environments whose sections are loaded
+
+	smtp         *SMTPConfig  // the SMTP configuration, if any file has it
+	smtpFrom     string       // the file the SMTP configuration came from
+	contacts     string       // the path to the address book
+	contactsFrom string       // the file the address book path came from
+	lists        AddressLists // the lists merged from every file
+	listsFrom    map[string]string
+
+	rules RawRules // the rules gathered, in order
+
+	loaded map[string]struct{} // the files already loaded
+	stack  []string            // the files being loaded, to detect cycles
+}
+
+// newRuleLoader returns a ruleLoader for the given environments.
+func newRuleLoader(envs []string) *ruleLoader {
+	return &ruleLoader{
+		envs:      envs,
+		listsFrom: make(map[string]string),
+		loaded:    make(map[string]struct{}),
+	}
+}
+
+// loadFile loads an environment sectioned rules file. The rules in the "*"
+// section and then in the section for each environment are added, followed by
+// the rules of each included file in the order they are listed. A file that
+// has already been loaded is not loaded again.
+func (l *ruleLoader) loadFile(fn string) error {
+	abs, err := filepath.Abs(fn)
+	if err != nil {
+		return fmt.Errorf("unable to resolve rules file %s: %w", fn, err)
+	}
+
+	for i, s := range l.stack {
+		if s == abs {
+			cycle := append(append([]string{}, l.stack[i:]...), abs)
+			return fmt.Errorf("rules file %s includes itself: %s", fn, strings.Join(cycle, " -> "))
+		}
+	}
+
+	if _, ok := l.loaded[abs]; ok {
+		return nil
+	}
+	l.loaded[abs] = struct{}{}
+
+	rf, err := LoadRulesFile(fn)
+	if err != nil {
+		return err
+	}
+
+	if rf.SMTP != nil {
+		if l.smtp != nil {
+			return fmt.Errorf("rules file %s configures smtp, which is already configured in %s", fn, l.smtpFrom)
+		}
+		l.smtp, l.smtpFrom = rf.SMTP, fn
+	}
+
+	if rf.Contacts != "" {
+		if l.contacts != "" {
+			return fmt.Errorf("rules file %s names contacts, which are already named in %s", fn, l.contactsFrom)
+		}
+		l.contacts, l.contactsFrom = resolveRulesPath(fn, rf.Contacts), fn
+	}
+
+	for name, items := range rf.Lists {
+		if prev, ok := l.listsFrom[name]; ok {
+			return fmt.Errorf("rules file %s defines list %s%s, which is already defined in %s", fn, ListPrefix, name, prev)
+		}
+		if l.lists == nil {
+			l.lists = make(AddressLists)
+		}
+		l.lists[name] = items
+		l.listsFrom[name] = fn
+	}
+
+	l.rules = append(l.rules, rf.Rules["*"]...)
+	for _, env := range l.envs {
+		if env != "*" {
+			l.rules = append(l.rules, rf.Rules[env]...)
+		}
+	}
+
+	l.stack = append(l.stack, abs)
+	defer func() { l.stack = l.stack[:len(l.stack)-1] }()
+
+	for _, inc := range rf.Include {
+		pattern := resolveRulesPath(fn, inc)
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			return fmt.Errorf("rules file %s has bad include %q: %w", fn, inc, err)
+		}
+
+		if len(matches) == 0 && !hasGlobMeta(inc) {
+			return fmt.Errorf("rules file %s includes missing file %s", fn, pattern)
+		}
+
+		sort.Strings(matches)
+		for _, m := range matches {
+			if err := l.loadFile(m); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// loadDir loads every .yml and .yaml file in the directory in order by name.
+// Nothing is loaded if the directory does not exist.
+func (l *ruleLoader) loadDir(dir string) error {
+	des, err := os.ReadDir(dir)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("unable to read rules directory %s: %w", dir, err)
+	}
+
+	var files []string
+	for _, de := range des {
+		ext := filepath.Ext(de.Name())
+		if de.IsDir() || (ext != ".yml" && ext != ".yaml") {
+			continue
+		}
+		files = append(files, filepath.Join(dir, de.Name()))
+	}
+
+	sort.Strings(files)
+	for _, f := range files {
+		if err := l.loadFile(f); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// hasGlobMeta returns true if the pattern contains any of the special
+// characters used by filepath.Match.
+func hasGlobMeta(pattern string) bool {
+	return strings.ContainsAny(pattern, `*?[\`)
+}
@@ -0,0 +1,135 @@
+package mail
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/zostay/dotfiles-go/internal/dotfiles"
+)
+
+// writeRulesFiles writes the named files under a new temporary directory and
+// returns the directory.
+func writeRulesFiles(t *testing.T, files map[string]string) string {
+	t.Helper()
+
+	dir := t.TempDir()
+	for fn, content := range files {
+		p := filepath.Join(dir, fn)
+		require.NoError(t, os.MkdirAll(filepath.Dir(p), 0700))
+		require.NoError(t, os.WriteFile(p, []byte(content), 0600))
+	}
+	return dir
+}
+
+func TestLoadConfigIncludes(t *testing.T) {
+	t.Parallel()
+
+	dir := writeRulesFiles(t, map[string]string{
+		"rules.yml": `---
+include: [frag/*.yml, shared.yml]
+lists:
+  team: [a@example.com]
+"*":
+  - label: Primary
+`,
+		"frag/b.yml": `---
+"*":
+  - label: FragB
+`,
+		"frag/a.yml": `---
+include: ../shared.yml
+"*":
+  - from: "@team"
+    label: FragA
+`,
+		"shared.yml": `---
+"*":
+  - label: Shared
+`,
+		"rules.d/20-two.yml": `---
+"*":
+  - label: DirTwo
+`,
+		"rules.d/10-one.yml": `---
+"*":
+  - label: DirOne
+nowhere:
+  - label: NotHere
+`,
+		"rules.d/notes.txt": "ignored",
+	})
+
+	c, err := LoadConfig(filepath.Join(dir, "rules.yml"), "test/local.yml")
+	require.NoError(t, err)
+
+	var labels []string
+	for _, r := range c.Rules {
+		labels = append(labels, r.Label...)
+	}
+
+	lr, err := LoadRawRules("test/local.yml")
+	require.NoError(t, err)
+
+	require.Len(t, labels, 6+len(lr))
+	assert.Equal(t, []string{"Primary", "FragA", "Shared", "FragB", "DirOne", "DirTwo"}, labels[:6])
+	assert.Equal(t, []string{"a@example.com"}, c.Rules[1].Expanded["from"])
+}
+
+func TestLoadConfigEnvironments(t *testing.T) {
+	dir := writeRulesFiles(t, map[string]string{
+		"rules.yml": `---
+"*":
+  - label: All
+home:
+  - label: Home
+work:
+  - label: Work
+laptop:
+  - label: Laptop
+`,
+	})
+
+	dotfiles.OverrideEnvironments("laptop", "work")
+	defer dotfiles.OverrideEnvironments("home")
+
+	c, err := LoadConfig(filepath.Join(dir, "rules.yml"), "test/local.yml")
+	require.NoError(t, err)
+
+	var labels []string
+	for _, r := range c.Rules[:3] {
+		labels = append(labels, r.Label...)
+	}
+	assert.Equal(t, []string{"All", "Laptop", "Work"}, labels)
+}
+
+func TestLoadConfigIncludeErrors(t *testing.T) {
+	t.Parallel()
+
+	tests := []map[string]string{
+		{
+			"rules.yml": "include: a.yml\n",
+			"a.yml":     "include: rules.yml\n",
+		},
+		{
+			"rules.yml": "include: missing.yml\n",
+		},
+		{
+			"rules.yml": "include: a.yml\nlists:\n  team: [a@example.com]\n",
+			"a.yml":     "lists:\n  team: [b@example.com]\n",
+		},
+		{
+			"rules.yml":     "smtp:\n  host: a.example.com\n",
+			"rules.d/a.yml": "smtp:\n  host: b.example.com\n",
+		},
+	}
+
+	for _, files := range tests {
+		dir := writeRulesFiles(t, files)
+		_, err := LoadConfig(filepath.Join(dir, "rules.yml"), "test/local.yml")
+		assert.Error(t, err, "%v", files)
+	}
+}
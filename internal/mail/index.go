@@ -0,0 +1,570 @@
+package mail
+
+import (
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/zostay/dotfiles-go/internal/fssafe"
+)
+
+const (
+	// IndexFile is the name of the file in the mail root that holds the
+	// full-text index of the messages.
+	IndexFile = ".label-mail.index.gob"
+
+	// indexMaxWordLength is the length of the longest word kept in the index.
+	indexMaxWordLength = 64
+
+	// indexMinEncodedLine is the length of the shortest line that is treated
+	// as base64 encoded data and left out of the index.
+	indexMinEncodedLine = 40
+)
+
+// ErrNoIndex is returned when the full-text index is needed, but has never
+// been built.
+var ErrNoIndex = errors.New("no full-text index has been built (run label-mail index)")
+
+// IndexDoc records the words found in a single message file.
+type IndexDoc struct {
+	// Folder is the folder the message was last seen in.
+	Folder string
+
+	// Name is the file name the message was last seen with.
+	Name string
+
+	// Size and ModTime identify the version of the file that was indexed.
+	Size    int64
+	ModTime time.Time
+
+	// Words lists the distinct lowercase words found in the message, sorted.
+	Words []string
+
+	// Complete is true if every word of the message is listed in Words. A
+	// message holding encoded data or overly long words is not complete, so
+	// the index cannot rule it out.
+	Complete bool
+}
+
+// fresh returns true if the file info describes the version of the file that
+// was indexed.
+func (d *IndexDoc) fresh(info os.FileInfo) bool {
+	return d.Size == info.Size() && d.ModTime.Equal(info.ModTime())
+}
+
+// Index is an inverted index of the words found in the headers and decoded
+// bodies of the messages in the mail root. It is used to rule out messages
+// that cannot contain a substring without reading them. Messages are keyed by
+// the unique part of the maildir file name, so a message keeps its entry when
+// it is moved to another folder or its flags change.
+//
+// Lines of base64 encoded data and overly long words are not indexed. The
+// index never rules out a message holding either, since a substring may be
+// found within them.
+type Index struct {
+	// Docs holds the indexed messages, keyed by maildir key.
+	Docs map[string]*IndexDoc
+
+	postings   map[string]map[string]struct{} // the keys of the messages holding each word
+	candidates map[string]map[string]struct{} // cached results of Candidates
+}
+
+// NewIndex returns an empty Index.
+func NewIndex() *Index {
+	return &Index{
+		Docs:       make(map[string]*IndexDoc),
+		postings:   make(map[string]map[string]struct{}),
+		candidates: make(map[string]map[string]struct{}),
+	}
+}
+
+// indexKey returns the key used to index the message file with the given name,
+// which is the file name with the maildir flags removed.
+func indexKey(filename string) string {
+	key := path.Base(filename)
+	if i := strings.IndexByte(key, ':'); i >= 0 {
+		key = key[:i]
+	}
+	return key
+}
+
+// isIndexWordRune returns true for the characters that make up indexed words.
+func isIndexWordRune(c rune) bool {
+	return unicode.IsLetter(c) || unicode.IsDigit(c)
+}
+
+// IndexWords splits text into the lowercase words kept in the index.
+func IndexWords(text string) []string {
+	words := strings.FieldsFunc(text, func(c rune) bool { return !isIndexWordRune(c) })
+	for i, w := range words {
+		words[i] = strings.ToLower(w)
+	}
+	return words
+}
+
+// isEncodedLine returns true if the line looks like base64 encoded data.
+func isEncodedLine(line string) bool {
+	line = strings.TrimSpace(line)
+	if len(line) < indexMinEncodedLine {
+		return false
+	}
+
+	for _, c := range line {
+		if !(c >= 'A' && c <= 'Z' || c >= 'a' && c <= 'z' || c >= '0' && c <= '9' || c == '+' || c == '/' || c == '=') {
+			return false
+		}
+	}
+
+	return true
+}
+
+// messageIndexWords returns the distinct words of the raw message, skipping
+// encoded data, and of the decoded body text, sorted. It also returns false if
+// any encoded data or overly long words were skipped.
+func messageIndexWords(m *Message) ([]string, bool, error) {
+	complete := true
+	words := make(map[string]struct{})
+	addWords := func(text string) {
+		for _, w := range IndexWords(text) {
+			if utf8.RuneCountInString(w) <= indexMaxWordLength {
+				words[w] = struct{}{}
+			} else {
+				complete = false
+			}
+		}
+	}
+
+	raw, err := m.Raw()
+	if err != nil {
+		return nil, false, err
+	}
+
+	for _, line := range strings.Split(string(raw), "\n") {
+		if isEncodedLine(line) {
+			complete = false
+		} else {
+			addWords(line)
+		}
+	}
+
+	for _, stripHTML := range []bool{false, true} {
+		body, err := m.BodyText(stripHTML)
+		if err != nil {
+			return nil, false, err
+		}
+
+		addWords(body)
+	}
+
+	sorted := make([]string, 0, len(words))
+	for w := range words {
+		sorted = append(sorted, w)
+	}
+	sort.Strings(sorted)
+
+	return sorted, complete, nil
+}
+
+// add places the document into the index under the given key, replacing any
+// document already there.
+func (ix *Index) add(key string, doc *IndexDoc) {
+	ix.remove(key)
+	ix.forgetCandidates()
+
+	ix.Docs[key] = doc
+	for _, w := range doc.Words {
+		if _, ok := ix.postings[w]; !ok {
+			ix.postings[w] = make(map[string]struct{})
+		}
+		ix.postings[w][key] = struct{}{}
+	}
+}
+
+// remove drops the document with the given key from the index.
+func (ix *Index) remove(key string) {
+	doc, ok := ix.Docs[key]
+	if !ok {
+		return
+	}
+
+	for _, w := range doc.Words {
+		delete(ix.postings[w], key)
+		if len(ix.postings[w]) == 0 {
+			delete(ix.postings, w)
+		}
+	}
+
+	delete(ix.Docs, key)
+	ix.forgetCandidates()
+}
+
+// forgetCandidates clears the cached results of Candidates after a change.
+func (ix *Index) forgetCandidates() {
+	if len(ix.candidates) > 0 {
+		ix.candidates = make(map[string]map[string]struct{})
+	}
+}
+
+// Words returns the number of distinct words in the index.
+func (ix *Index) Words() int {
+	return len(ix.postings)
+}
+
+// Candidates returns the keys of the messages that may contain the given
+// substring, ignoring case. It returns false if the index cannot narrow the
+// search for this substring, such as when it holds no words.
+func (ix *Index) Candidates(needle string) (map[string]struct{}, bool) {
+	if cs, ok := ix.candidates[needle]; ok {
+		return cs, cs != nil
+	}
+
+	cs := ix.findCandidates(needle)
+	ix.candidates[needle] = cs
+	return cs, cs != nil
+}
+
+// findCandidates implements Candidates. Words in the middle of the substring
+// must be found whole in a message. The first word may be the end of a longer
+// word and the last word may be the start of one, unless the substring begins
+// or ends with a character that is not part of a word.
+func (ix *Index) findCandidates(needle string) map[string]struct{} {
+	type span struct {
+		word           string // the lowercase word
+		atStart, atEnd bool   // whether the word begins or ends the substring
+	}
+
+	var spans []span
+	for i := 0; i < len(needle); {
+		c, n := utf8.DecodeRuneInString(needle[i:])
+		if !isIndexWordRune(c) {
+			i += n
+			continue
+		}
+
+		j := i
+		for j < len(needle) {
+			c, n := utf8.DecodeRuneInString(needle[j:])
+			if !isIndexWordRune(c) {
+				break
+			}
+			j += n
+		}
+
+		spans = append(spans, span{strings.ToLower(needle[i:j]), i == 0, j == len(needle)})
+		i = j
+	}
+
+	if len(spans) == 0 {
+		return nil
+	}
+
+	sets := make([]map[string]struct{}, 0, len(spans))
+	for _, s := range spans {
+		if utf8.RuneCountInString(s.word) > indexMaxWordLength {
+			return nil
+		}
+
+		var matches func(string) bool
+		switch {
+		case s.atStart && s.atEnd:
+			matches = func(w string) bool { return strings.Contains(w, s.word) }
+		case s.atStart:
+			matches = func(w string) bool { return strings.HasSuffix(w, s.word) }
+		case s.atEnd:
+			matches = func(w string) bool { return strings.HasPrefix(w, s.word) }
+		}
+
+		if matches == nil {
+			sets = append(sets, ix.postings[s.word])
+			continue
+		}
+
+		set := make(map[string]struct{})
+		for w, keys := range ix.postings {
+			if !matches(w) {
+				continue
+			}
+
+			for k := range keys {
+				set[k] = struct{}{}
+			}
+		}
+		sets = append(sets, set)
+	}
+
+	sort.Slice(sets, func(i, j int) bool { return len(sets[i]) < len(sets[j]) })
+
+	cs := make(map[string]struct{}, len(sets[0]))
+	for k := range sets[0] {
+		cs[k] = struct{}{}
+	}
+
+	for _, set := range sets[1:] {
+		for k := range cs {
+			if _, ok := set[k]; !ok {
+				delete(cs, k)
+			}
+		}
+	}
+
+	return cs
+}
+
+// MayContain returns false if the index shows that the message does not
+// contain the given substring. It returns true when the message might contain
+// it, including when the message has not been indexed, has changed since, or
+// holds data that is not indexed.
+func (ix *Index) MayContain(m *Message, needle string) bool {
+	key := indexKey(m.Filename())
+	doc, ok := ix.Docs[key]
+	if !ok || !doc.Complete {
+		return true
+	}
+
+	info, err := m.Stat()
+	if err != nil || !doc.fresh(info) {
+		return true
+	}
+
+	cs, ok := ix.Candidates(needle)
+	if !ok {
+		return true
+	}
+
+	_, found := cs[key]
+	return found
+}
+
+// LoadIndex reads an Index. It returns ErrNoIndex if the index has never been
+// saved.
+func LoadIndex(ls fssafe.LoaderSaver) (*Index, error) {
+	r, err := ls.Loader()
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, ErrNoIndex
+	} else if err != nil {
+		return nil, fmt.Errorf("unable to open full-text index: %w", err)
+	}
+
+	defer r.Close()
+
+	var docs map[string]*IndexDoc
+	err = gob.NewDecoder(r).Decode(&docs)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read full-text index: %w", err)
+	}
+
+	ix := NewIndex()
+	for key, doc := range docs {
+		ix.add(key, doc)
+	}
+
+	return ix, nil
+}
+
+// Save writes the Index.
+func (ix *Index) Save(ls fssafe.LoaderSaver) error {
+	w, err := ls.Saver()
+	if err != nil {
+		return fmt.Errorf("unable to save full-text index: %w", err)
+	}
+
+	err = gob.NewEncoder(w).Encode(ix.Docs)
+	if err != nil {
+		_ = w.Close()
+		return fmt.Errorf("unable to write full-text index: %w", err)
+	}
+
+	return w.Close()
+}
+
+// IndexReport describes the outcome of Filter.UpdateIndex.
+type IndexReport struct {
+	// Added is the number of messages indexed for the first time.
+	Added int
+
+	// Updated is the number of messages indexed again because they changed.
+	Updated int
+
+	// Removed is the number of messages dropped because they are gone.
+	Removed int
+
+	// Skipped is the number of messages that could not be read.
+	Skipped int
+
+	// Messages is the number of messages in the index.
+	Messages int
+
+	// Words is the number of distinct words in the index.
+	Words int
+}
+
+// String summarizes the report.
+func (r *IndexReport) String() string {
+	s := fmt.Sprintf("Indexed %d messages with %d distinct words (%d added, %d updated, %d removed).\n",
+		r.Messages, r.Words, r.Added, r.Updated, r.Removed)
+	if r.Skipped > 0 {
+		s += fmt.Sprintf("Skipped %d messages that could not be read.\n", r.Skipped)
+	}
+	return s
+}
+
+// SetIndexLoaderSaver changes where the full-text index is kept. The default
+// is IndexFile in the mail root.
+func (fi *Filter) SetIndexLoaderSaver(ls fssafe.LoaderSaver) {
+	fi.indexStore = ls
+	fi.index = nil
+	fi.indexErr = nil
+}
+
+// indexLoaderSaver returns the LoaderSaver for the full-text index.
+func (fi *Filter) indexLoaderSaver() fssafe.LoaderSaver {
+	if fi.indexStore == nil {
+		fi.indexStore = fssafe.NewFileSystemLoaderSaver(path.Join(fi.mailRoot, IndexFile))
+	}
+	return fi.indexStore
+}
+
+// Index returns the full-text index, loading it on first use. It returns
+// ErrNoIndex if the index has never been built.
+func (fi *Filter) Index() (*Index, error) {
+	if fi.index != nil || fi.indexErr != nil {
+		return fi.index, fi.indexErr
+	}
+
+	fi.index, fi.indexErr = LoadIndex(fi.indexLoaderSaver())
+	return fi.index, fi.indexErr
+}
+
+// UpdateIndex builds the full-text index or brings it up to date with the
+// messages in every folder that is not skipped. Only messages that are new or
+// have changed since they were last indexed are read. The index is saved unless
+// this is a dry run.
+func (fi *Filter) UpdateIndex() (*IndexReport, error) {
+	ix, err := fi.Index()
+	if errors.Is(err, ErrNoIndex) {
+		ix = NewIndex()
+	} else if err != nil {
+		return nil, err
+	}
+
+	folders, err := fi.AllFolders()
+	if err != nil {
+		return nil, fmt.Errorf("unable to get a list of folders to index: %w", err)
+	}
+
+	report := &IndexReport{}
+	seen := make(map[string]struct{}, len(ix.Docs))
+	for _, folder := range folders {
+		if _, skip := SkipFolder[folder]; skip {
+			continue
+		}
+
+		msgs, err := fi.folder(folder).Messages()
+		if err != nil {
+			return nil, fmt.Errorf("failed to get messages from folder %s: %w", folder, err)
+		}
+
+		var msg Message
+		for msgs.Next(&msg) {
+			m := NewMessage(msg.r)
+			key := indexKey(m.Filename())
+			if _, dup := seen[key]; dup {
+				continue
+			}
+
+			info, err := m.Stat()
+			if err != nil {
+				report.Skipped++
+				continue
+			}
+
+			seen[key] = struct{}{}
+
+			doc, indexed := ix.Docs[key]
+			if indexed && doc.fresh(info) {
+				doc.Folder, doc.Name = folder, path.Base(m.Filename())
+				continue
+			}
+
+			words, complete, err := messageIndexWords(m)
+			if err != nil {
+				ix.remove(key)
+				report.Skipped++
+				continue
+			}
+
+			ix.add(key, &IndexDoc{
+				Folder:   folder,
+				Name:     path.Base(m.Filename()),
+				Size:     info.Size(),
+				ModTime:  info.ModTime(),
+				Words:    words,
+				Complete: complete,
+			})
+
+			if indexed {
+				report.Updated++
+			} else {
+				report.Added++
+			}
+		}
+
+		if err := msgs.Err(); err != nil {
+			return nil, fmt.Errorf("failed reading messages from folder %s: %w", folder, err)
+		}
+	}
+
+	for key := range ix.Docs {
+		if _, ok := seen[key]; !ok {
+			ix.remove(key)
+			report.Removed++
+		}
+	}
+
+	report.Messages = len(ix.Docs)
+	report.Words = ix.Words()
+
+	fi.index, fi.indexErr = ix, nil
+
+	if !fi.dryRun {
+		err = ix.Save(fi.indexLoaderSaver())
+		if err != nil {
+			return report, err
+		}
+	}
+
+	return report, nil
+}
+
+// RefreshIndex brings the full-text index up to date if it has been built.
+// It does nothing and returns nil if there is no index.
+func (fi *Filter) RefreshIndex() (*IndexReport, error) {
+	_, err := fi.Index()
+	if errors.Is(err, ErrNoIndex) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	return fi.UpdateIndex()
+}
+
+// indexMayContain returns false if the full-text index shows that the message
+// does not contain the given substring. Without a usable index, it always
+// returns true.
+func (fi *Filter) indexMayContain(m *Message, needle string) bool {
+	ix, err := fi.Index()
+	if err != nil {
+		return true
+	}
+
+	return ix.MayContain(m, needle)
+}
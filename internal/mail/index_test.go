@@ -0,0 +1,186 @@
+package mail
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/zostay/dotfiles-go/internal/fssafe"
+)
+
+func TestIndexWords(t *testing.T) {
+	t.Parallel()
+
+	assert.Equal(t,
+		[]string{"re", "lunch", "at", "café", "nov", "22"},
+		IndexWords("Re: Lunch at Café (Nov 22)?"))
+
+	assert.True(t, isEncodedLine("VGhpcyBpcyBhIGxvbmcgbGluZSBvZiBiYXNlNjQgZW5jb2RlZCBkYXRh\r"))
+	assert.False(t, isEncodedLine("VGhpcyBpcyBzaG9ydA=="))
+	assert.False(t, isEncodedLine("This is a long line of perfectly ordinary text, not data."))
+}
+
+func TestIndex_Candidates(t *testing.T) {
+	t.Parallel()
+
+	ix := NewIndex()
+	ix.add("a", &IndexDoc{Words: []string{"lunch", "plans", "tomorrow"}})
+	ix.add("b", &IndexDoc{Words: []string{"brunch", "plans"}})
+	ix.add("c", &IndexDoc{Words: []string{"dinner", "planning"}})
+
+	candidates := func(needle string) []string {
+		t.Helper()
+
+		cs, ok := ix.Candidates(needle)
+		require.True(t, ok, needle)
+
+		var keys []string
+		for k := range cs {
+			keys = append(keys, k)
+		}
+		return keys
+	}
+
+	assert.ElementsMatch(t, []string{"a"}, candidates("Lunch Plans"))
+	assert.ElementsMatch(t, []string{"a", "b"}, candidates("unch plans"))
+	assert.ElementsMatch(t, []string{"a"}, candidates(" lunch plan"))
+	assert.ElementsMatch(t, []string{"a", "b", "c"}, candidates("plan"))
+	assert.ElementsMatch(t, []string{"a", "b"}, candidates("plans "))
+	assert.Empty(t, candidates("breakfast"))
+
+	_, ok := ix.Candidates("?!")
+	assert.False(t, ok)
+
+	ix.add("d", &IndexDoc{Words: []string{"breakfast"}})
+	assert.ElementsMatch(t, []string{"d"}, candidates("breakfast"))
+
+	ix.remove("a")
+	assert.Empty(t, candidates("Lunch Plans"))
+}
+
+func TestFilter_UpdateIndex(t *testing.T) {
+	t.Parallel()
+
+	f, root := mkTempFilter(t)
+	ls := fssafe.NewTestingLoaderSaver()
+	f.SetIndexLoaderSaver(ls)
+
+	_, err := f.Index()
+	assert.ErrorIs(t, err, ErrNoIndex)
+
+	report, err := f.RefreshIndex()
+	assert.NoError(t, err)
+	assert.Nil(t, report)
+
+	writeTestMessage(t, root, "INBOX", "80:2,S", listMessage)
+
+	report, err = f.UpdateIndex()
+	require.NoError(t, err)
+	assert.Equal(t, 4, report.Messages)
+	assert.Equal(t, 4, report.Added)
+	assert.Contains(t, f.index.Docs["80"].Words, "gophers")
+
+	// moving a message keeps its place in the index
+	require.NoError(t, NewMailDirFolder(root, "Archive").EnsureExists())
+	require.NoError(t, os.Rename(
+		filepath.Join(root, "INBOX", "cur", "80:2,S"),
+		filepath.Join(root, "Archive", "cur", "80:2,RS")))
+	require.NoError(t, os.Remove(filepath.Join(root, "Other", "cur", "3:2,S")))
+	writeTestMessage(t, root, "INBOX", "1:2,S", "Subject: Replaced\r\n\r\nNew text.\r\n")
+	later := time.Now().Add(time.Minute)
+	require.NoError(t, os.Chtimes(filepath.Join(root, "INBOX", "cur", "1:2,S"), later, later))
+
+	// reload the saved index to update it
+	f.SetIndexLoaderSaver(ls)
+	report, err = f.RefreshIndex()
+	require.NoError(t, err)
+	assert.Equal(t, &IndexReport{
+		Added:    0,
+		Updated:  1,
+		Removed:  1,
+		Messages: 3,
+		Words:    f.index.Words(),
+	}, report)
+	assert.Equal(t, "Archive", f.index.Docs["80"].Folder)
+	assert.Equal(t, "80:2,RS", f.index.Docs["80"].Name)
+	assert.Contains(t, f.index.Docs["1"].Words, "replaced")
+	assert.NotContains(t, f.index.Docs["1"].Words, "simple")
+}
+
+func TestFilter_ApplyRule_ContainsIndex(t *testing.T) {
+	t.Parallel()
+
+	f, root := mkTempFilter(t)
+	f.SetDryRun(true)
+	f.SetIndexLoaderSaver(fssafe.NewTestingLoaderSaver())
+
+	fn := filepath.Join(root, "INBOX", "cur", "80:2,S")
+	writeTestMessage(t, root, "INBOX", "80:2,S", listMessage)
+	_, err := f.UpdateIndex()
+	require.NoError(t, err)
+
+	applies := func(match Match) bool {
+		t.Helper()
+
+		msg, err := f.Message("INBOX", "80:2,S")
+		require.NoError(t, err)
+
+		actions, err := f.ApplyRule(msg, &CompiledRule{Match: match, Label: []string{"Test"}})
+		require.NoError(t, err)
+		return len(actions) > 0
+	}
+
+	assert.True(t, applies(Match{Contains: "Hello, gophers"}))
+	assert.True(t, applies(Match{ContainsFold: "GO NUTS"}))
+	assert.True(t, applies(Match{BodyContains: "gopher"}))
+	assert.False(t, applies(Match{BodyContainsFold: "rustaceans"}))
+
+	// change the message without changing its size or time, so the index
+	// rules it out without reading it
+	info, err := os.Stat(fn)
+	require.NoError(t, err)
+	changed := []byte(listMessage)
+	copy(changed[len(changed)-len("gophers.\r\n"):], "rusties.\r\n")
+	require.NoError(t, os.WriteFile(fn, changed, 0600))
+	require.NoError(t, os.Chtimes(fn, info.ModTime(), info.ModTime()))
+
+	assert.False(t, applies(Match{BodyContainsFold: "rusties"}))
+
+	// once the time changes, the message is read again
+	later := info.ModTime().Add(time.Minute)
+	require.NoError(t, os.Chtimes(fn, later, later))
+
+	assert.True(t, applies(Match{BodyContainsFold: "rusties"}))
+
+	// data left out of the index never rules a message out
+	writeTestMessage(t, root, "INBOX", "81:2,S", "Subject: Attached\r\n"+
+		"MIME-Version: 1.0\r\n"+
+		"Content-Type: multipart/mixed; boundary=\"b\"\r\n\r\n"+
+		"--b\r\nContent-Type: text/plain\r\n\r\n"+
+		"Supercalifragilisticexpialidocious"+strings.Repeat("ly", 20)+"\r\n"+
+		"--b\r\nContent-Type: application/octet-stream\r\nContent-Transfer-Encoding: base64\r\n\r\n"+
+		"VGhpcyBpcyBhIGxvbmcgbGluZSBvZiBiYXNlNjQgZW5jb2RlZCBkYXRh\r\n"+
+		"--b--\r\n")
+	_, err = f.UpdateIndex()
+	require.NoError(t, err)
+	assert.False(t, f.index.Docs["81"].Complete)
+	assert.True(t, f.index.Docs["80"].Complete)
+
+	msg, err := f.Message("INBOX", "81:2,S")
+	require.NoError(t, err)
+	for _, match := range []Match{
+		{Contains: "xvbmcgbGlu"},
+		{ContainsFold: "XVBMCGBGLU"},
+		{BodyContains: "fragilistic"},
+		{BodyContainsFold: "FRAGILISTIC"},
+	} {
+		actions, err := f.ApplyRule(msg, &CompiledRule{Match: match, Label: []string{"Test"}})
+		require.NoError(t, err)
+		assert.NotEmpty(t, actions, match)
+	}
+}
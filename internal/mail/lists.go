@@ -0,0 +1,97 @@
+package mail
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// ListPrefix marks a value in an address or domain field as a reference to a
+// named list rather than a literal value.
+const ListPrefix = "@"
+
+// AddressLists are the named lists of addresses and domains defined under the
+// lists key of the primary rules file. A list may include other lists by
+// reference (e.g., "@family").
+type AddressLists map[string][]string
+
+// IsListRef returns true if the value refers to a named list.
+func IsListRef(value string) bool {
+	return strings.HasPrefix(value, ListPrefix)
+}
+
+// Expand returns the values a field value stands for. If the value is a list
+// reference, the list and every list it includes are expanded. Otherwise, the
+// value is returned as is. Returns an error if a list is undefined or includes
+// itself.
+func (ls AddressLists) Expand(value string) ([]string, error) {
+	if !IsListRef(value) {
+		return []string{value}, nil
+	}
+
+	return ls.expand(strings.TrimPrefix(value, ListPrefix), nil)
+}
+
+// expand returns the values of the named list. The path lists the names of the
+// lists being expanded, which is used to detect cycles.
+func (ls AddressLists) expand(name string, path []string) ([]string, error) {
+	for i, p := range path {
+		if p == name {
+			cycle := append(append([]string{}, path[i:]...), name)
+			return nil, fmt.Errorf("list %s%s includes itself: %s%s", ListPrefix, name,
+				ListPrefix, strings.Join(cycle, " -> "+ListPrefix))
+		}
+	}
+
+	items, ok := ls[name]
+	if !ok {
+		return nil, fmt.Errorf("list %s%s is not defined", ListPrefix, name)
+	}
+
+	path = append(path, name)
+	values := make([]string, 0, len(items))
+	for _, item := range items {
+		if !IsListRef(item) {
+			values = append(values, item)
+			continue
+		}
+
+		vs, err := ls.expand(strings.TrimPrefix(item, ListPrefix), path)
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, vs...)
+	}
+
+	return values, nil
+}
+
+// Check expands every list to make sure each one refers only to defined lists
+// and none includes itself.
+func (ls AddressLists) Check() error {
+	names := make([]string, 0, len(ls))
+	for name := range ls {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		if _, err := ls.expand(name, nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ExpandAll expands each of the values and returns them all together.
+func (ls AddressLists) ExpandAll(values []string) ([]string, error) {
+	var all []string
+	for _, v := range values {
+		vs, err := ls.Expand(strings.TrimSpace(v))
+		if err != nil {
+			return nil, err
+		}
+		all = append(all, vs...)
+	}
+	return all, nil
+}
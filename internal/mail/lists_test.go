@@ -0,0 +1,121 @@
+package mail
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zostay/go-addr/pkg/addr"
+)
+
+func TestAddressLists_Expand(t *testing.T) {
+	t.Parallel()
+
+	ls := AddressLists{
+		"parents":  {"mom@example.com", "dad@example.com"},
+		"family":   {"@parents", "sis@example.com"},
+		"vendors":  {"example.net", "example.org"},
+		"missing":  {"@nope"},
+		"ouroboro": {"@snake"},
+		"snake":    {"@ouroboro"},
+	}
+
+	vs, err := ls.Expand("someone@example.com")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"someone@example.com"}, vs)
+
+	vs, err = ls.Expand("@family")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"mom@example.com", "dad@example.com", "sis@example.com"}, vs)
+
+	_, err = ls.Expand("@strangers")
+	assert.EqualError(t, err, "list @strangers is not defined")
+
+	_, err = ls.Expand("@missing")
+	assert.EqualError(t, err, "list @nope is not defined")
+
+	_, err = ls.Expand("@snake")
+	assert.EqualError(t, err, "list @snake includes itself: @snake -> @ouroboro -> @snake")
+
+	assert.Error(t, ls.Check())
+	assert.NoError(t, AddressLists{"vendors": ls["vendors"]}.Check())
+}
+
+const listsRulesFile = `---
+lists:
+  parents: [mom@example.com, dad@example.com]
+  family: ["@parents", sis@example.com]
+  vendors: [example.net, example.org]
+
+"*":
+  - from: "@family"
+    to_domain: "@vendors"
+    forward: ["@parents", me@example.com]
+`
+
+func TestLoadConfigLists(t *testing.T) {
+	t.Parallel()
+
+	rulesFile := filepath.Join(t.TempDir(), "rules.yml")
+	require.NoError(t, os.WriteFile(rulesFile, []byte(listsRulesFile), 0600))
+
+	c, err := LoadConfig(rulesFile, "test/local.yml")
+	require.NoError(t, err)
+
+	require.NotEmpty(t, c.Rules)
+	r := c.Rules[0]
+	assert.Equal(t, map[string][]string{
+		"from":      {"mom@example.com", "dad@example.com", "sis@example.com"},
+		"to_domain": {"example.net", "example.org"},
+	}, r.Expanded)
+
+	assert.Equal(t, []string{"example.net", "example.org"}, r.values("to_domain", r.ToDomain))
+	assert.Equal(t, []string{""}, r.values("cc", r.Cc))
+
+	forward := make([]string, len(r.Forward))
+	for i, a := range r.Forward {
+		forward[i] = a.(*addr.Mailbox).Address()
+	}
+	assert.Equal(t, []string{"mom@example.com", "dad@example.com", "me@example.com"}, forward)
+
+	bad := filepath.Join(t.TempDir(), "rules.yml")
+	require.NoError(t, os.WriteFile(bad, []byte(`---
+"*":
+  - from: "@family"
+    label: Family
+`), 0600))
+
+	_, err = LoadConfig(bad, "test/local.yml")
+	assert.Error(t, err)
+}
+
+func TestFilter_ApplyRule_Lists(t *testing.T) {
+	t.Parallel()
+
+	f, _ := mkTempFilter(t)
+	f.SetDryRun(true)
+
+	msg, err := f.Message("INBOX", "1:2,S")
+	require.NoError(t, err)
+
+	tests := []struct {
+		expanded map[string][]string
+		match    Match
+		passes   bool
+	}{
+		{map[string][]string{"from": {"a@example.com", "sterling@example.com"}}, Match{From: "@team"}, true},
+		{map[string][]string{"from": {"a@example.com"}}, Match{From: "@team"}, false},
+		{map[string][]string{"from_domain": {"example.net", "example.com"}}, Match{FromDomain: "@vendors"}, true},
+		{map[string][]string{"from_domain": {}}, Match{FromDomain: "@vendors"}, false},
+	}
+
+	for _, test := range tests {
+		cr := &CompiledRule{Match: test.match, Label: []string{"Test"}, Expanded: test.expanded}
+
+		actions, err := f.ApplyRule(msg, cr)
+		assert.NoError(t, err)
+		assert.Equal(t, test.passes, len(actions) > 0, "%+v", test.match)
+	}
+}
@@ -5,8 +5,28 @@ import (
 	"os"
 	"path"
 	"strings"
+	"sync/atomic"
+	"time"
 )
 
+// mailDirKeyCount is used to keep keys unique within a single process.
+var mailDirKeyCount uint64
+
+// NewMailDirKey generates a new unique key for naming a message file being
+// delivered into a maildir folder, following the usual maildir convention of
+// time, process, and host name.
+func NewMailDirKey() string {
+	host, err := os.Hostname()
+	if err != nil {
+		host = "localhost"
+	}
+	host = strings.NewReplacer("/", "\\057", ":", "\\072").Replace(host)
+
+	now := time.Now()
+	n := atomic.AddUint64(&mailDirKeyCount, 1)
+	return fmt.Sprintf("%d.M%dP%dQ%d.%s", now.Unix(), now.Nanosecond()/1000, os.Getpid(), n, host)
+}
+
 // DirFolder represents a single maildir folder in a mail root.
 type DirFolder struct {
 	root     string
@@ -1,6 +1,8 @@
 package mail
 
 import (
+	"os"
+	"path/filepath"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -54,3 +56,23 @@ func TestDirFolder_Message(t *testing.T) {
 	require.NoError(t, err)
 	assert.Equal(t, "Foo", subj)
 }
+
+func TestDirWriter_Abort(t *testing.T) {
+	t.Parallel()
+
+	mdf := NewMailDirFolder(t.TempDir(), "Outbox")
+	require.NoError(t, mdf.EnsureExists())
+
+	w, err := NewMailDirWriter(NewMailDirSlurper("42", "", "new", mdf))
+	require.NoError(t, err)
+
+	_, err = w.Write([]byte("Subject: Partial\r\n"))
+	require.NoError(t, err)
+	require.NoError(t, w.Abort())
+
+	assert.NoFileExists(t, filepath.Join(mdf.Path(), "new", "42"))
+
+	tmp, err := os.ReadDir(mdf.TempDirPath())
+	require.NoError(t, err)
+	assert.Empty(t, tmp)
+}
@@ -0,0 +1,15 @@
+package mail
+
+import (
+	"os"
+	"testing"
+
+	"github.com/zostay/dotfiles-go/internal/dotfiles"
+)
+
+// TestMain makes the test rules independent of the environment configured on
+// the host running the tests.
+func TestMain(m *testing.M) {
+	dotfiles.OverrideEnvironments("home")
+	os.Exit(m.Run())
+}
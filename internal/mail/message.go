@@ -16,18 +16,9 @@ import (
 	"github.com/zostay/go-email/v2/message"
 	"github.com/zostay/go-email/v2/message/header"
 
-	"github.com/zostay/dotfiles-go/internal/keeper"
 	"github.com/zostay/dotfiles-go/internal/xtrings"
 )
 
-var (
-	// SASLUser contains the username to use to login
-	SASLUser = keeper.MustGetSecret("LABEL_MAIL_USERNAME").Password()
-
-	// SASLPass contsint eh password to use to login
-	SASLPass = keeper.MustGetSecret("LABEL_MAIL_PASSWORD").Password()
-)
-
 // Message represents a MIME message which may be partially or fully read in.
 type Message struct {
 	// r is the mechanism used for reading in the message
@@ -35,6 +26,12 @@ type Message struct {
 
 	// m is the cached header of the message
 	h *header.Header
+
+	// attachments is the cached list of attachments in the message
+	attachments []Attachment
+
+	// body is the cached list of decoded text parts in the message
+	body []bodyPart
 }
 
 // NewMessage creates a *Message from a Slurper.
@@ -180,13 +177,14 @@ func (m *Message) Keywords() ([]string, error) {
 		return nil, fmt.Errorf("failed to get the keywords of the message: %w", err)
 	}
 
-	ks, err := mh.GetAll(header.Keywords)
-	if errors.Is(err, header.ErrNoSuchField) {
-		return []string{}, nil
-	}
+	// Read the fields directly rather than using GetAll() because the header
+	// caches the values read by GetAll(), which means they go stale as soon as
+	// updateKeywords() modifies the header.
+	fs := mh.GetAllFieldsNamed(header.Keywords)
 
-	allKs := make([]string, 0, len(ks))
-	for _, k := range ks {
+	allKs := make([]string, 0, len(fs))
+	for _, f := range fs {
+		k := strings.TrimSpace(f.Body())
 		if k == "" {
 			continue
 		}
@@ -195,7 +193,7 @@ func (m *Message) Keywords() ([]string, error) {
 		allKs = append(allKs, splits...)
 	}
 
-	return allKs, err
+	return allKs, nil
 }
 
 // KeywordsSet returns the contents of the Keywords header as a set or an error.
@@ -214,7 +212,31 @@ func (m *Message) KeywordsSet() (km map[string]struct{}, err error) {
 	return
 }
 
-// HasNonconformingKeywords returns true if the Keywords header is mailformed.
+// MissingKeywords returns the names from the given list that are not found in
+// the Keywords header. Each missing name is only returned once. It returns an
+// error if it has a problem reading or parsing the Keywords header.
+func (m *Message) MissingKeywords(names ...string) ([]string, error) {
+	km, err := m.KeywordsSet()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get keywords while checking for missing keywords: %w", err)
+	}
+
+	missing := make([]string, 0, len(names))
+	for _, n := range names {
+		if _, ok := km[n]; ok {
+			continue
+		}
+
+		km[n] = struct{}{}
+		missing = append(missing, n)
+	}
+
+	return missing, nil
+}
+
+// HasNonconformingKeywords returns true if the Keywords header is mailformed,
+// meaning some keyword holds a character other than a letter, number, '_',
+// '-', '.', or '/'. A leading backslash is permitted for system flags.
 func (m *Message) HasNonconformingKeywords() (bool, error) {
 	sk, err := m.Keywords()
 	if err != nil {
@@ -222,8 +244,9 @@ func (m *Message) HasNonconformingKeywords() (bool, error) {
 	}
 
 	for _, k := range sk {
-		where := strings.IndexFunc(k, func(c rune) bool {
-			return unicode.IsLetter(c) || unicode.IsNumber(c) || c == '_' || c == '-' || c == '.' || c == '/'
+		// system flags, like \Inbox, start with a backslash
+		where := strings.IndexFunc(strings.TrimPrefix(k, "\\"), func(c rune) bool {
+			return !unicode.IsLetter(c) && !unicode.IsNumber(c) && c != '_' && c != '-' && c != '.' && c != '/'
 		})
 
 		if where >= 0 {
@@ -403,17 +426,65 @@ func (m *Message) Subject() (string, error) {
 	return mh.GetSubject()
 }
 
+// MessageID returns the contents of the Message-ID header. It returns an empty
+// string if the message has no Message-ID.
+func (m *Message) MessageID() (string, error) {
+	mh, err := m.EmailHeader()
+	if err != nil {
+		return "", fmt.Errorf("failed to read email message while pulling Message-ID: %w", err)
+	}
+
+	id, err := mh.GetMessageID()
+	if errors.Is(err, header.ErrNoSuchField) {
+		return "", nil
+	} else if errors.Is(err, header.ErrManyFields) {
+		// cope with broken messages by using the first one
+		err = nil
+	}
+
+	return strings.TrimSpace(id), err
+}
+
+// ListID returns the identifier from the List-Id header, which is the part in
+// angle brackets, if present. It returns an empty string if the message has no
+// List-Id.
+func (m *Message) ListID() (string, error) {
+	mh, err := m.EmailHeader()
+	if err != nil {
+		return "", fmt.Errorf("failed to read email message while pulling List-Id: %w", err)
+	}
+
+	id, err := mh.Get("List-Id")
+	if errors.Is(err, header.ErrNoSuchField) {
+		return "", nil
+	} else if errors.Is(err, header.ErrManyFields) {
+		// cope with broken messages by using the first one
+		id, err = mh.GetFieldNamed("List-Id", 0).Body(), nil
+	}
+
+	if i := strings.LastIndexByte(id, '<'); i >= 0 {
+		if j := strings.IndexByte(id[i:], '>'); j >= 0 {
+			id = id[i+1 : i+j]
+		}
+	}
+
+	return strings.TrimSpace(id), err
+}
+
 // Folder returns the name of the folder that contains this email's file.
 func (m *Message) Folder() (string, error) {
 	return m.r.Folder(), nil
 }
 
 // skipTest represents a function used to skip an action when it won't apply.
-type skipTest func(*Message, *CompiledRule) (skipResult, error)
+// The filter is provided for skips that need information gathered from beyond
+// the message itself.
+type skipTest func(*Filter, *Message, *CompiledRule) (skipResult, error)
 
 // ruleTest represents a function used to determine whether a rule is
-// applicable (if it has not been skipped).
-type ruleTest func(*Message, *CompiledRule, *int) (testResult, error)
+// applicable (if it has not been skipped). The filter is provided for tests
+// that need information gathered from beyond the message itself.
+type ruleTest func(*Filter, *Message, *CompiledRule, *int) (testResult, error)
 
 // skipResult describes whether a skip should occur and why
 type skipResult struct {
@@ -431,7 +502,7 @@ var (
 	// skipTests defines all the ways in which a rule may be skipped
 	skipTests = []skipTest{
 		// skip because we're labelling and this rule has no label
-		func(m *Message, c *CompiledRule) (skipResult, error) {
+		func(fi *Filter, m *Message, c *CompiledRule) (skipResult, error) {
 			if !c.IsLabeling() {
 				return skipResult{false, cp.Scolor("base", "not labeling")}, nil
 			}
@@ -454,8 +525,37 @@ var (
 			}, err
 		},
 
+		// skip because we're labeling the thread and every message in the
+		// thread already has the thread labels
+		func(fi *Filter, m *Message, c *CompiledRule) (skipResult, error) {
+			if !c.IsLabelingThread() {
+				return skipResult{false, cp.Scolor("base", "not labeling thread")}, nil
+			}
+
+			ok, err := m.HasKeyword(c.LabelThread...)
+			if ok && err == nil {
+				ok, err = fi.threadLabeled(m, c.LabelThread)
+			}
+
+			if !ok {
+				return skipResult{false,
+					cp.Scolor(
+						"base", "needs thread labels ",
+						"label", fmt.Sprintf("%q", strings.Join(c.LabelThread, ", ")),
+					),
+				}, err
+			}
+
+			return skipResult{true,
+				cp.Scolor(
+					"base", "already labeled thread ",
+					"label", fmt.Sprintf("%q", cp.Join("base", c.LabelThread, ", ")),
+				),
+			}, err
+		},
+
 		// skip because we're clearing and this rule is not a clearing rule
-		func(m *Message, c *CompiledRule) (skipResult, error) {
+		func(fi *Filter, m *Message, c *CompiledRule) (skipResult, error) {
 			if !c.IsClearing() {
 				return skipResult{false, cp.Scolor("base", "not clearing")}, nil
 			}
@@ -479,7 +579,7 @@ var (
 		},
 
 		// skip because the message is already in the destination folder
-		func(m *Message, c *CompiledRule) (skipResult, error) {
+		func(fi *Filter, m *Message, c *CompiledRule) (skipResult, error) {
 			if !c.IsMoving() {
 				return skipResult{false, cp.Scolor("base", "not moving")}, nil
 			}
@@ -503,7 +603,7 @@ var (
 		},
 
 		// skip because we do not modify starred messages
-		func(m *Message, c *CompiledRule) (skipResult, error) {
+		func(fi *Filter, m *Message, c *CompiledRule) (skipResult, error) {
 			ok, err := m.HasKeyword("\\Starred")
 			if ok {
 				return skipResult{true,
@@ -526,7 +626,7 @@ var (
 	// ruleTests are the rules that identify which messages match a certain rule
 	ruleTests = []ruleTest{
 		// match if the message Date is more recent than the ok date
-		func(m *Message, c *CompiledRule, tests *int) (testResult, error) {
+		func(fi *Filter, m *Message, c *CompiledRule, tests *int) (testResult, error) {
 			if !c.HasOkayDate() {
 				return testResult{true, cp.Scolor("base", "no okay date")}, nil
 			}
@@ -551,8 +651,104 @@ var (
 			}, err
 		},
 
+		// match if the message Date is more recent than the newer date
+		func(fi *Filter, m *Message, c *CompiledRule, tests *int) (testResult, error) {
+			if !c.HasNewerDate() {
+				return testResult{true, cp.Scolor("base", "no newer date")}, nil
+			}
+
+			*tests++
+
+			date, err := m.Date()
+			if date.After(c.NewerDate) {
+				return testResult{true,
+					cp.Scolor(
+						"base", "message is newer than newer date ",
+						"value", fmt.Sprintf("%q", c.NewerDate.Format(time.RFC3339)),
+					),
+				}, err
+			}
+
+			return testResult{false,
+				cp.Scolor(
+					"base", "message is older than newer date ",
+					"value", fmt.Sprintf("%q", c.NewerDate.Format(time.RFC3339)),
+				),
+			}, err
+		},
+
+		// match if the message Date is within the time of day window
+		func(fi *Filter, m *Message, c *CompiledRule, tests *int) (testResult, error) {
+			if c.ReceivedBetween == nil {
+				return testResult{true, cp.Scolor("base", "no received between test")}, nil
+			}
+
+			*tests++
+
+			date, err := m.Date()
+			if err != nil {
+				return testResult{false, cp.Scolor("base", "message has no usable date")}, err
+			}
+
+			date = c.InLocation(date)
+			if c.ReceivedBetween.Contains(date) {
+				return testResult{true,
+					cp.Scolor(
+						"action", "message time ",
+						"value", fmt.Sprintf("%q", date.Format("15:04 MST")),
+						"action", " is between ",
+						"value", fmt.Sprintf("%q", c.ReceivedBetween),
+					),
+				}, nil
+			}
+
+			return testResult{false,
+				cp.Scolor(
+					"base", "message time ",
+					"value", fmt.Sprintf("%q", date.Format("15:04 MST")),
+					"base", " is not between ",
+					"value", fmt.Sprintf("%q", c.ReceivedBetween),
+				),
+			}, nil
+		},
+
+		// match if the message Date falls on one of the days of the week
+		func(fi *Filter, m *Message, c *CompiledRule, tests *int) (testResult, error) {
+			if len(c.Weekdays) == 0 {
+				return testResult{true, cp.Scolor("base", "no weekdays test")}, nil
+			}
+
+			*tests++
+
+			date, err := m.Date()
+			if err != nil {
+				return testResult{false, cp.Scolor("base", "message has no usable date")}, err
+			}
+
+			date = c.InLocation(date)
+			if c.Weekdays.Contains(date) {
+				return testResult{true,
+					cp.Scolor(
+						"action", "message day ",
+						"value", fmt.Sprintf("%q", date.Weekday()),
+						"action", " is one of ",
+						"value", fmt.Sprintf("%q", c.Weekdays),
+					),
+				}, nil
+			}
+
+			return testResult{false,
+				cp.Scolor(
+					"base", "message day ",
+					"value", fmt.Sprintf("%q", date.Weekday()),
+					"base", " is not one of ",
+					"value", fmt.Sprintf("%q", c.Weekdays),
+				),
+			}, nil
+		},
+
 		// match if the message has a matching From address
-		func(m *Message, c *CompiledRule, tests *int) (testResult, error) {
+		func(fi *Filter, m *Message, c *CompiledRule, tests *int) (testResult, error) {
 			if c.From == "" {
 				return testResult{true, cp.Scolor("base", "no from test")}, nil
 			}
@@ -560,11 +756,45 @@ var (
 			*tests++
 
 			from, err := m.AddressList("From")
-			return testAddress("From", "from", c.From, from, err)
+			return testEach(testAddress, "From", "from", c.From, c.values("from", c.From), from, err)
+		},
+
+		// match if the message is from a matching contact in the address book
+		func(fi *Filter, m *Message, c *CompiledRule, tests *int) (testResult, error) {
+			if c.FromContact == "" {
+				return testResult{true, cp.Scolor("base", "no from_contact test")}, nil
+			}
+
+			*tests++
+
+			emails, err := fi.AddressBook().ContactEmails(c.FromContact)
+			if err != nil {
+				return testResult{false, cp.Scolor("base", "unable to read address book")}, err
+			}
+
+			from, err := m.AddressList("From")
+			return testEach(testAddress, "From", "from_contact", c.FromContact, emails, from, err)
+		},
+
+		// match if the message is from a contact in a matching address book group
+		func(fi *Filter, m *Message, c *CompiledRule, tests *int) (testResult, error) {
+			if c.FromGroup == "" {
+				return testResult{true, cp.Scolor("base", "no from_group test")}, nil
+			}
+
+			*tests++
+
+			emails, err := fi.AddressBook().GroupEmails(c.FromGroup)
+			if err != nil {
+				return testResult{false, cp.Scolor("base", "unable to read address book")}, err
+			}
+
+			from, err := m.AddressList("From")
+			return testEach(testAddress, "From", "from_group", c.FromGroup, emails, from, err)
 		},
 
 		// match if the message has a matching domain in the From header
-		func(m *Message, c *CompiledRule, tests *int) (testResult, error) {
+		func(fi *Filter, m *Message, c *CompiledRule, tests *int) (testResult, error) {
 			if c.FromDomain == "" {
 				return testResult{true, cp.Scolor("base", "no from domain test")}, nil
 			}
@@ -572,11 +802,11 @@ var (
 			*tests++
 
 			from, err := m.AddressList("From")
-			return testDomain("From", "from", c.FromDomain, from, err)
+			return testEach(testDomain, "From", "from", c.FromDomain, c.values("from_domain", c.FromDomain), from, err)
 		},
 
 		// match if the message has a matching To address
-		func(m *Message, c *CompiledRule, tests *int) (testResult, error) {
+		func(fi *Filter, m *Message, c *CompiledRule, tests *int) (testResult, error) {
 			if c.To == "" {
 				return testResult{true, cp.Scolor("base", "no to test")}, nil
 			}
@@ -584,11 +814,11 @@ var (
 			*tests++
 
 			to, err := m.AddressList("To")
-			return testAddress("To", "to", c.To, to, err)
+			return testEach(testAddress, "To", "to", c.To, c.values("to", c.To), to, err)
 		},
 
 		// match if the message has a matching domain in the To header
-		func(m *Message, c *CompiledRule, tests *int) (testResult, error) {
+		func(fi *Filter, m *Message, c *CompiledRule, tests *int) (testResult, error) {
 			if c.ToDomain == "" {
 				return testResult{true, cp.Scolor("base", "no to domain test")}, nil
 			}
@@ -596,11 +826,11 @@ var (
 			*tests++
 
 			to, err := m.AddressList("To")
-			return testDomain("To", "to", c.ToDomain, to, err)
+			return testEach(testDomain, "To", "to", c.ToDomain, c.values("to_domain", c.ToDomain), to, err)
 		},
 
 		// match if the message has a matching Cc address
-		func(m *Message, c *CompiledRule, tests *int) (testResult, error) {
+		func(fi *Filter, m *Message, c *CompiledRule, tests *int) (testResult, error) {
 			if c.Cc == "" {
 				return testResult{true, cp.Scolor("base", "no cc test")}, nil
 			}
@@ -608,11 +838,11 @@ var (
 			*tests++
 
 			cc, err := m.AddressList("Cc")
-			return testAddress("Cc", "cc", c.Cc, cc, err)
+			return testEach(testAddress, "Cc", "cc", c.Cc, c.values("cc", c.Cc), cc, err)
 		},
 
 		// match if the message has a matching domain in the Cc header
-		func(m *Message, c *CompiledRule, tests *int) (testResult, error) {
+		func(fi *Filter, m *Message, c *CompiledRule, tests *int) (testResult, error) {
 			if c.CcDomain == "" {
 				return testResult{true, cp.Scolor("base", "no cc domain test")}, nil
 			}
@@ -620,11 +850,11 @@ var (
 			*tests++
 
 			cc, err := m.AddressList("Cc")
-			return testDomain("Cc", "cc", c.CcDomain, cc, err)
+			return testEach(testDomain, "Cc", "cc", c.CcDomain, c.values("cc_domain", c.CcDomain), cc, err)
 		},
 
 		// match if the message has a matching Sender address
-		func(m *Message, c *CompiledRule, tests *int) (testResult, error) {
+		func(fi *Filter, m *Message, c *CompiledRule, tests *int) (testResult, error) {
 			if c.Sender == "" {
 				return testResult{true, cp.Scolor("base", "no sender test")}, nil
 			}
@@ -632,11 +862,11 @@ var (
 			*tests++
 
 			sender, err := m.AddressList("Sender")
-			return testAddress("Sender", "sender", c.Sender, sender, err)
+			return testEach(testAddress, "Sender", "sender", c.Sender, c.values("sender", c.Sender), sender, err)
 		},
 
 		// match if the message has a matching Delivered-To address
-		func(m *Message, c *CompiledRule, tests *int) (testResult, error) {
+		func(fi *Filter, m *Message, c *CompiledRule, tests *int) (testResult, error) {
 			if c.DeliveredTo == "" {
 				return testResult{true, cp.Scolor("base", "no delivered_to test")}, nil
 			}
@@ -652,11 +882,41 @@ var (
 			for _, dt := range deliveredTo {
 				dts = append(dts, dt...)
 			}
-			return testAddress("Delivered-To", "delivered_to", c.DeliveredTo, dts, err)
+			return testEach(testAddress, "Delivered-To", "delivered_to", c.DeliveredTo, c.values("delivered_to", c.DeliveredTo), dts, err)
+		},
+
+		// match if the message has a matching List-Id
+		func(fi *Filter, m *Message, c *CompiledRule, tests *int) (testResult, error) {
+			if c.ListID == "" {
+				return testResult{true, cp.Scolor("base", "no list_id test")}, nil
+			}
+
+			*tests++
+
+			id, err := m.ListID()
+			if strings.EqualFold(id, c.ListID) {
+				return testResult{true,
+					cp.Scolor(
+						"action", "message header ",
+						"header", fmt.Sprintf("%q", "List-Id"),
+						"action", " matches ",
+						"value", fmt.Sprintf("%q", c.ListID),
+					),
+				}, err
+			}
+
+			return testResult{false,
+				cp.Scolor(
+					"base", "message header ",
+					"header", fmt.Sprintf("%q", "List-Id"),
+					"base", " does not match ",
+					"value", fmt.Sprintf("%q", c.ListID),
+				),
+			}, err
 		},
 
 		// match if the message has a matching exact Subject header match
-		func(m *Message, c *CompiledRule, tests *int) (testResult, error) {
+		func(fi *Filter, m *Message, c *CompiledRule, tests *int) (testResult, error) {
 			if c.Subject == "" {
 				return testResult{true, cp.Scolor("base", "no exact subject test")}, nil
 			}
@@ -687,7 +947,7 @@ var (
 
 		// match if the message has an exact header match, but without case
 		// sensitivity
-		func(m *Message, c *CompiledRule, tests *int) (testResult, error) {
+		func(fi *Filter, m *Message, c *CompiledRule, tests *int) (testResult, error) {
 			if c.SubjectFold == "" {
 				return testResult{true, cp.Scolor("base", "no folded case subject test")}, nil
 			}
@@ -717,7 +977,7 @@ var (
 		},
 
 		// match if the Subject header contains the given substring
-		func(m *Message, c *CompiledRule, tests *int) (testResult, error) {
+		func(fi *Filter, m *Message, c *CompiledRule, tests *int) (testResult, error) {
 			if c.SubjectContains == "" {
 				return testResult{true, cp.Scolor("base", "no subject contains test")}, nil
 			}
@@ -748,7 +1008,7 @@ var (
 
 		// match if the Subject header contains the given substring, but using a
 		// case-insensitive match
-		func(m *Message, c *CompiledRule, tests *int) (testResult, error) {
+		func(fi *Filter, m *Message, c *CompiledRule, tests *int) (testResult, error) {
 			if c.SubjectContainsFold == "" {
 				return testResult{true, cp.Scolor("base", "no subject contains subject folded case test")}, nil
 			}
@@ -778,14 +1038,21 @@ var (
 		},
 
 		// match if the message anywhere contains the given substring
-		func(m *Message, c *CompiledRule, tests *int) (testResult, error) {
+		func(fi *Filter, m *Message, c *CompiledRule, tests *int) (testResult, error) {
 			if c.Contains == "" {
 				return testResult{true, cp.Scolor("base", "no contains anywhere test")}, nil
 			}
 
 			*tests++
 
-			bs, err := m.Raw()
+			var (
+				bs  []byte
+				err error
+			)
+			if fi.indexMayContain(m, c.Contains) {
+				bs, err = m.Raw()
+			}
+
 			if !strings.Contains(string(bs), c.Contains) {
 				return testResult{false,
 					cp.Scolor(
@@ -805,14 +1072,21 @@ var (
 
 		// match if the message anywhere contains the given substring, with a
 		// case insensitive match
-		func(m *Message, c *CompiledRule, tests *int) (testResult, error) {
+		func(fi *Filter, m *Message, c *CompiledRule, tests *int) (testResult, error) {
 			if c.ContainsFold == "" {
 				return testResult{true, cp.Scolor("base", "no contains anywhere folded case test")}, nil
 			}
 
 			*tests++
 
-			bs, err := m.Raw()
+			var (
+				bs  []byte
+				err error
+			)
+			if fi.indexMayContain(m, c.ContainsFold) {
+				bs, err = m.Raw()
+			}
+
 			if !xtrings.ContainsFold(string(bs), c.ContainsFold) {
 				return testResult{false,
 					cp.Scolor(
@@ -829,9 +1103,345 @@ var (
 				),
 			}, err
 		},
+
+		// match if the decoded message body contains the given substring
+		func(fi *Filter, m *Message, c *CompiledRule, tests *int) (testResult, error) {
+			if c.BodyContains == "" {
+				return testResult{true, cp.Scolor("base", "no body contains test")}, nil
+			}
+
+			*tests++
+
+			var (
+				body string
+				err  error
+			)
+			if fi.indexMayContain(m, c.BodyContains) {
+				body, err = m.BodyText(c.BodyStripHTML)
+			}
+
+			if !strings.Contains(body, c.BodyContains) {
+				return testResult{false,
+					cp.Scolor(
+						"base", "message fails body contains test: ",
+						"value", fmt.Sprintf("%q", c.BodyContains),
+					),
+				}, err
+			}
+
+			return testResult{true,
+				cp.Scolor(
+					"action", "message passes body contains test: ",
+					"value", fmt.Sprintf("%q", c.BodyContains),
+				),
+			}, err
+		},
+
+		// match if the decoded message body contains the given substring, with
+		// a case insensitive match
+		func(fi *Filter, m *Message, c *CompiledRule, tests *int) (testResult, error) {
+			if c.BodyContainsFold == "" {
+				return testResult{true, cp.Scolor("base", "no body contains folded case test")}, nil
+			}
+
+			*tests++
+
+			var (
+				body string
+				err  error
+			)
+			if fi.indexMayContain(m, c.BodyContainsFold) {
+				body, err = m.BodyText(c.BodyStripHTML)
+			}
+
+			if !xtrings.ContainsFold(body, c.BodyContainsFold) {
+				return testResult{false,
+					cp.Scolor(
+						"base", "message fails body contains folded case test: ",
+						"value", fmt.Sprintf("%q", c.BodyContainsFold),
+					),
+				}, err
+			}
+
+			return testResult{true,
+				cp.Scolor(
+					"action", "message passes body contains folded case test: ",
+					"value", fmt.Sprintf("%q", c.BodyContainsFold),
+				),
+			}, err
+		},
+
+		// match if the message does or does not have attachments
+		func(fi *Filter, m *Message, c *CompiledRule, tests *int) (testResult, error) {
+			if c.HasAttachment == nil {
+				return testResult{true, cp.Scolor("base", "no has attachment test")}, nil
+			}
+
+			*tests++
+
+			as, err := m.Attachments()
+			if (len(as) > 0) != *c.HasAttachment {
+				return testResult{false,
+					cp.Scolor(
+						"base", "message fails has attachment test: ",
+						"value", fmt.Sprintf("%t", *c.HasAttachment),
+					),
+				}, err
+			}
+
+			return testResult{true,
+				cp.Scolor(
+					"action", "message passes has attachment test: ",
+					"value", fmt.Sprintf("%t", *c.HasAttachment),
+				),
+			}, err
+		},
+
+		// match if the message has an attachment with a matching file name
+		func(fi *Filter, m *Message, c *CompiledRule, tests *int) (testResult, error) {
+			if c.AttachmentName == "" {
+				return testResult{true, cp.Scolor("base", "no attachment name test")}, nil
+			}
+
+			*tests++
+
+			as, err := m.Attachments()
+			for _, a := range as {
+				if matchGlobFold(c.AttachmentName, a.Filename) {
+					return testResult{true,
+						cp.Scolor(
+							"action", "message attachment ",
+							"value", fmt.Sprintf("%q", a.Filename),
+							"action", " matches attachment name test: ",
+							"value", fmt.Sprintf("%q", c.AttachmentName),
+						),
+					}, err
+				}
+			}
+
+			return testResult{false,
+				cp.Scolor(
+					"base", "message has no attachment matching attachment name test: ",
+					"value", fmt.Sprintf("%q", c.AttachmentName),
+				),
+			}, err
+		},
+
+		// match if the message has an attachment with a matching media type
+		func(fi *Filter, m *Message, c *CompiledRule, tests *int) (testResult, error) {
+			if c.AttachmentType == "" {
+				return testResult{true, cp.Scolor("base", "no attachment type test")}, nil
+			}
+
+			*tests++
+
+			as, err := m.Attachments()
+			for _, a := range as {
+				if matchGlobFold(c.AttachmentType, a.MediaType) {
+					return testResult{true,
+						cp.Scolor(
+							"action", "message attachment type ",
+							"value", fmt.Sprintf("%q", a.MediaType),
+							"action", " matches attachment type test: ",
+							"value", fmt.Sprintf("%q", c.AttachmentType),
+						),
+					}, err
+				}
+			}
+
+			return testResult{false,
+				cp.Scolor(
+					"base", "message has no attachment matching attachment type test: ",
+					"value", fmt.Sprintf("%q", c.AttachmentType),
+				),
+			}, err
+		},
+
+		// match if the message file is larger than the given size
+		func(fi *Filter, m *Message, c *CompiledRule, tests *int) (testResult, error) {
+			if c.SizeGreater == 0 {
+				return testResult{true, cp.Scolor("base", "no size greater test")}, nil
+			}
+
+			*tests++
+
+			info, err := m.Stat()
+			if err != nil || info.Size() <= int64(c.SizeGreater) {
+				return testResult{false,
+					cp.Scolor(
+						"base", "message is not larger than ",
+						"value", fmt.Sprintf("%d bytes", c.SizeGreater),
+					),
+				}, err
+			}
+
+			return testResult{true,
+				cp.Scolor(
+					"action", "message is larger than ",
+					"value", fmt.Sprintf("%d bytes", c.SizeGreater),
+				),
+			}, nil
+		},
+
+		// match if the message file is smaller than the given size
+		func(fi *Filter, m *Message, c *CompiledRule, tests *int) (testResult, error) {
+			if c.SizeLess == 0 {
+				return testResult{true, cp.Scolor("base", "no size less test")}, nil
+			}
+
+			*tests++
+
+			info, err := m.Stat()
+			if err != nil || info.Size() >= int64(c.SizeLess) {
+				return testResult{false,
+					cp.Scolor(
+						"base", "message is not smaller than ",
+						"value", fmt.Sprintf("%d bytes", c.SizeLess),
+					),
+				}, err
+			}
+
+			return testResult{true,
+				cp.Scolor(
+					"action", "message is smaller than ",
+					"value", fmt.Sprintf("%d bytes", c.SizeLess),
+				),
+			}, nil
+		},
+
+		// match if some message in the same thread carries the given label
+		func(fi *Filter, m *Message, c *CompiledRule, tests *int) (testResult, error) {
+			if c.ThreadHasLabel == "" {
+				return testResult{true, cp.Scolor("base", "no thread label test")}, nil
+			}
+
+			*tests++
+
+			label := CanonicalLabel(c.ThreadHasLabel)
+
+			ti, err := fi.ThreadIndex()
+			if err != nil {
+				return testResult{false, cp.Scolor("base", "no thread index")}, err
+			}
+
+			has, err := ti.HasLabel(m, label)
+			if !has {
+				return testResult{false,
+					cp.Scolor(
+						"base", "message thread does not have label ",
+						"label", fmt.Sprintf("%q", label),
+					),
+				}, err
+			}
+
+			return testResult{true,
+				cp.Scolor(
+					"action", "message thread has label ",
+					"label", fmt.Sprintf("%q", label),
+				),
+			}, err
+		},
+
+		// match if the DKIM result matches
+		func(fi *Filter, m *Message, c *CompiledRule, tests *int) (testResult, error) {
+			return testAuthResult(m, "dkim", c.DKIM, c.DKIMDomain, c.AuthServID, tests)
+		},
+
+		// match if the SPF result matches
+		func(fi *Filter, m *Message, c *CompiledRule, tests *int) (testResult, error) {
+			return testAuthResult(m, "spf", c.SPF, c.SPFDomain, c.AuthServID, tests)
+		},
+
+		// match if the DMARC result matches
+		func(fi *Filter, m *Message, c *CompiledRule, tests *int) (testResult, error) {
+			return testAuthResult(m, "dmarc", c.DMARC, c.DMARCDomain, c.AuthServID, tests)
+		},
+
+		// match if the ARC result matches
+		func(fi *Filter, m *Message, c *CompiledRule, tests *int) (testResult, error) {
+			return testAuthResult(m, "arc", c.ARC, "", c.AuthServID, tests)
+		},
+
+		// match if the spam classifier scores the message high enough
+		func(fi *Filter, m *Message, c *CompiledRule, tests *int) (testResult, error) {
+			if c.SpamScoreAbove == 0 {
+				return testResult{true, cp.Scolor("base", "no spam score test")}, nil
+			}
+
+			*tests++
+
+			score, err := fi.SpamScore(m)
+			if err != nil {
+				return testResult{false, cp.Scolor("base", "unable to score message as spam")},
+					fmt.Errorf("error scoring message as spam: %w", err)
+			}
+
+			desc := fmt.Sprintf("%.3f", score)
+			limit := fmt.Sprintf("%.3f", c.SpamScoreAbove)
+			if score <= c.SpamScoreAbove {
+				return testResult{false,
+					cp.Scolor(
+						"base", "message spam score ",
+						"value", desc,
+						"base", " is not above ",
+						"value", limit,
+					),
+				}, nil
+			}
+
+			return testResult{true,
+				cp.Scolor(
+					"action", "message spam score ",
+					"value", desc,
+					"action", " is above ",
+					"value", limit,
+				),
+			}, nil
+		},
 	}
 )
 
+// testAuthResult is a function that tests to see if the message reports the
+// expected result for the given authentication method. When only a domain is
+// given, the expected result is "pass".
+func testAuthResult(m *Message, method, expect, domain, servID string, tests *int) (testResult, error) {
+	if expect == "" && domain == "" {
+		return testResult{true, cp.Scolor("base", fmt.Sprintf("no %s test", method))}, nil
+	}
+
+	*tests++
+
+	if expect == "" {
+		expect = "pass"
+	}
+
+	desc := method + "=" + expect
+	if domain != "" {
+		desc += " for " + domain
+	}
+
+	ok, err := m.HasAuthResult(method, expect, domain, servID)
+	if err != nil {
+		err = fmt.Errorf("error reading authentication results: %w", err)
+	}
+
+	if !ok {
+		return testResult{false,
+			cp.Scolor(
+				"base", "message authentication results do not report ",
+				"value", desc,
+			),
+		}, err
+	}
+
+	return testResult{true,
+		cp.Scolor(
+			"action", "message authentication results report ",
+			"value", desc,
+		),
+	}, err
+}
+
 // testAddress is a function that tests to see if the given addr.AddressList
 // contains the expected address. It sets up common diagnostic messages and
 // always returns the given err, but formatted with a better diagnostic message.
@@ -875,6 +1485,32 @@ func testAddress(dbgh, dbgt, expect string, got addr.AddressList, err error) (te
 	}, err
 }
 
+// testEach is a function that uses test to see if any of the expected
+// addresses or domains is found in the addr.AddressList. The name is what the
+// expected values were found for, such as a list or contact name, which is
+// used in the diagnostics when none matches.
+func testEach(
+	test func(dbgh, dbgt, expect string, got addr.AddressList, err error) (testResult, error),
+	dbgh, dbgt, name string,
+	expect []string,
+	got addr.AddressList,
+	err error,
+) (testResult, error) {
+	if len(expect) == 1 && expect[0] == name {
+		return test(dbgh, dbgt, name, got, err)
+	}
+
+	for _, e := range expect {
+		if res, err := test(dbgh, dbgt, e, got, err); res.pass {
+			return res, err
+		}
+	}
+
+	// the name is never an address or domain, so this will not match, but it
+	// reports the failure in terms of the name
+	return test(dbgh, dbgt, name, got, err)
+}
+
 // testDomain is a helper that tests to see if the given domain is found in the
 // addr.AddressList. It adds diagnostics around the process. The dbgh names the
 // header being tested. The dbgt is the test being performed. And the err is
@@ -936,6 +1572,11 @@ func (m *Message) MoveTo(root string, name string) error {
 	return nil
 }
 
+// Remove deletes the message file from its maildir folder.
+func (m *Message) Remove() error {
+	return m.r.(*DirSlurper).Remove()
+}
+
 // Save saves any modifications made to the message to disk.
 func (m *Message) Save() error {
 	// We've been modifying the cached header, so we need that
@@ -0,0 +1,39 @@
+package mail
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMessage_HasNonconformingKeywords(t *testing.T) {
+	t.Parallel()
+
+	_, root := mkTempFilter(t)
+
+	tests := []struct {
+		keywords string
+		bad      bool
+	}{
+		{"Friends Go", false},
+		{`\Inbox \Starred Work`, false},
+		{"Lists/go-nuts v1.2 snake_case", false},
+		{"Friends,Family", true},
+		{"Oops!", true},
+		{`Work\Later`, true},
+	}
+
+	for i, test := range tests {
+		fn := fmt.Sprintf("%d:2,S", 100+i)
+		writeTestMessage(t, root, "INBOX", fn, "Subject: Test\r\nKeywords: "+test.keywords+"\r\n\r\nTest.\r\n")
+
+		m, err := NewMailDirFolder(root, "INBOX").Message(fn)
+		require.NoError(t, err)
+
+		bad, err := m.HasNonconformingKeywords()
+		assert.NoError(t, err)
+		assert.Equal(t, test.bad, bad, test.keywords)
+	}
+}
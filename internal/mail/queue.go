@@ -0,0 +1,527 @@
+package mail
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/emersion/go-smtp"
+	"github.com/zostay/go-email/v2/message"
+	"github.com/zostay/go-email/v2/message/header"
+
+	"github.com/zostay/dotfiles-go/internal/dotfiles"
+)
+
+const (
+	// QueueDir is the name of the maildir folder in the home directory that
+	// holds outgoing mail waiting to be retried.
+	QueueDir = ".label-mail.queue"
+
+	// QueueBaseDelay is the time to wait before the first retry of a queued
+	// message. The delay doubles after each failed attempt.
+	QueueBaseDelay = time.Minute
+
+	// QueueMaxDelay is the longest time to wait between retries.
+	QueueMaxDelay = 6 * time.Hour
+
+	// QueueMaxAttempts is the number of failed attempts after which a queued
+	// message is given up on.
+	QueueMaxAttempts = 20
+
+	// QueueMaxAge is how long a message may wait in the queue before it is
+	// given up on.
+	QueueMaxAge = 5 * 24 * time.Hour
+)
+
+// Header fields used to store queue metadata at the top of each queued message.
+const (
+	queueAccountHeader     = "X-Queue-Account"
+	queueAttemptsHeader    = "X-Queue-Attempts"
+	queueCreatedHeader     = "X-Queue-Created"
+	queueNextAttemptHeader = "X-Queue-Next-Attempt"
+	queueLastErrorHeader   = "X-Queue-Last-Error"
+)
+
+// ErrQueued is returned, wrapped around the original error, when a message
+// could not be sent right away and was queued to be retried later.
+var ErrQueued = errors.New("message queued for later delivery")
+
+// QueuedError is the error returned by QueueingTransport when a message could
+// not be sent right away and was queued. It wraps ErrQueued.
+type QueuedError struct {
+	// ID identifies the queued message.
+	ID string
+
+	// To lists the recipients the message was queued for, which may be fewer
+	// than it was sent to.
+	To []string
+
+	// Err is the error that prevented the message from being sent.
+	Err error
+}
+
+// Error describes the failure to send and that the message was queued.
+func (e *QueuedError) Error() string {
+	return fmt.Sprintf("%v: %v", ErrQueued, e.Err)
+}
+
+// Unwrap returns ErrQueued.
+func (e *QueuedError) Unwrap() error {
+	return ErrQueued
+}
+
+// QueueEntry describes a message waiting in the queue.
+type QueueEntry struct {
+	// ID identifies the entry in the queue.
+	ID string
+
+	// Account is the name of the SMTP account to send the message as.
+	Account string
+
+	// From is the envelope sender.
+	From string
+
+	// To lists the envelope recipients.
+	To []string
+
+	// Attempts is the number of failed attempts to send the message.
+	Attempts int
+
+	// Created is the time the message was first queued.
+	Created time.Time
+
+	// NextAttempt is the earliest time the message will be retried.
+	NextAttempt time.Time
+
+	// LastError is the error from the last failed attempt.
+	LastError string
+}
+
+// Queue is a maildir folder of outgoing messages waiting to be retried. Each
+// message is stored with the envelope and queue metadata in header fields at
+// the top of the message, which are removed again before it is sent. Messages
+// waiting to be retried are kept in new and messages that have been given up on
+// are moved to cur.
+type Queue struct {
+	folder *DirFolder
+}
+
+// DefaultQueuePath returns the default location of the queue.
+func DefaultQueuePath() string {
+	return path.Join(dotfiles.HomeDir, QueueDir)
+}
+
+// NewQueue returns the Queue kept in the maildir folder at the given path.
+func NewQueue(dir string) *Queue {
+	return &Queue{NewMailDirFolder(dir, "")}
+}
+
+// QueueDelay returns the time to wait before retrying after the given number
+// of failed attempts.
+func QueueDelay(attempts int) time.Duration {
+	d := QueueBaseDelay
+	for i := 1; i < attempts && d < QueueMaxDelay; i++ {
+		d *= 2
+	}
+
+	if d > QueueMaxDelay {
+		d = QueueMaxDelay
+	}
+
+	return d
+}
+
+// Enqueue adds a message that failed to send with the given error to the
+// queue. It returns the new entry.
+func (q *Queue) Enqueue(
+	account, from string,
+	to []string,
+	r io.Reader,
+	sendErr error,
+	now time.Time,
+) (*QueueEntry, error) {
+	mm, err := message.Parse(r, message.WithoutMultipart())
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse message to queue: %w", err)
+	}
+
+	e := &QueueEntry{
+		ID:          NewMailDirKey(),
+		Account:     account,
+		From:        from,
+		To:          to,
+		Attempts:    1,
+		Created:     now,
+		NextAttempt: now.Add(QueueDelay(1)),
+		LastError:   sendErr.Error(),
+	}
+
+	h := mm.GetHeader()
+	h.InsertBeforeField(0, EnvelopeToHeader, strings.Join(to, ", "))
+	h.InsertBeforeField(0, EnvelopeFromHeader, from)
+	h.InsertBeforeField(0, queueCreatedHeader, now.Format(time.RFC3339))
+	h.InsertBeforeField(0, queueAccountHeader, account)
+	setQueueState(h, e)
+
+	err = q.write(e.ID, mm)
+	if err != nil {
+		return nil, err
+	}
+
+	return e, nil
+}
+
+// setQueueState writes the state of the retries and the recipients still to be
+// sent to into the header.
+func setQueueState(h *header.Header, e *QueueEntry) {
+	h.Set(EnvelopeToHeader, strings.Join(e.To, ", "))
+	h.Set(queueAttemptsHeader, strconv.Itoa(e.Attempts))
+	h.Set(queueNextAttemptHeader, e.NextAttempt.Format(time.RFC3339))
+	h.Set(queueLastErrorHeader, strings.Join(strings.Fields(e.LastError), " "))
+}
+
+// write saves the queued message with the given ID.
+func (q *Queue) write(id string, mm message.Generic) error {
+	err := q.folder.EnsureExists()
+	if err != nil {
+		return err
+	}
+
+	w, err := NewMailDirWriter(NewMailDirSlurper(id, "", "new", q.folder))
+	if err != nil {
+		return fmt.Errorf("unable to write queued message: %w", err)
+	}
+
+	_, err = mm.WriteTo(w)
+	if err != nil {
+		_ = w.Abort()
+		return fmt.Errorf("unable to write queued message: %w", err)
+	}
+
+	return w.Close()
+}
+
+// filename returns the path to the queued message with the given ID in the
+// given directory, new or cur.
+func (q *Queue) filename(rd, id string) string {
+	return path.Join(q.folder.Path(), rd, id)
+}
+
+// read loads the queued message with the given ID from the given directory.
+func (q *Queue) read(rd, id string) (*QueueEntry, message.Generic, error) {
+	bs, err := os.ReadFile(q.filename(rd, id))
+	if err != nil {
+		return nil, nil, fmt.Errorf("unable to read queued message %q: %w", id, err)
+	}
+
+	mm, err := message.Parse(bytes.NewReader(bs), message.WithoutMultipart())
+	if err != nil {
+		return nil, nil, fmt.Errorf("unable to parse queued message %q: %w", id, err)
+	}
+
+	h := mm.GetHeader()
+	get := func(name string) string {
+		v, _ := h.Get(name)
+		return strings.TrimSpace(v)
+	}
+
+	e := &QueueEntry{
+		ID:        id,
+		Account:   get(queueAccountHeader),
+		From:      get(EnvelopeFromHeader),
+		LastError: get(queueLastErrorHeader),
+	}
+
+	for _, to := range strings.Split(get(EnvelopeToHeader), ",") {
+		if to = strings.TrimSpace(to); to != "" {
+			e.To = append(e.To, to)
+		}
+	}
+
+	e.Attempts, _ = strconv.Atoi(get(queueAttemptsHeader))
+	e.Created, _ = time.Parse(time.RFC3339, get(queueCreatedHeader))
+	e.NextAttempt, _ = time.Parse(time.RFC3339, get(queueNextAttemptHeader))
+
+	return e, mm, nil
+}
+
+// Entries lists the messages in the queue, ordered by when they were queued.
+func (q *Queue) Entries() ([]*QueueEntry, error) {
+	return q.entries("new")
+}
+
+// Failed lists the messages that have been given up on, ordered by when they
+// were queued.
+func (q *Queue) Failed() ([]*QueueEntry, error) {
+	return q.entries("cur")
+}
+
+// entries lists the messages in the given directory, new or cur.
+func (q *Queue) entries(rd string) ([]*QueueEntry, error) {
+	des, err := os.ReadDir(path.Join(q.folder.Path(), rd))
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("unable to read queue: %w", err)
+	}
+
+	es := make([]*QueueEntry, 0, len(des))
+	for _, de := range des {
+		if de.IsDir() {
+			continue
+		}
+
+		e, _, err := q.read(rd, de.Name())
+		if err != nil {
+			return nil, err
+		}
+
+		es = append(es, e)
+	}
+
+	sort.Slice(es, func(i, j int) bool {
+		if !es[i].Created.Equal(es[j].Created) {
+			return es[i].Created.Before(es[j].Created)
+		}
+		return es[i].ID < es[j].ID
+	})
+
+	return es, nil
+}
+
+// Drop removes the message with the given ID from the queue or from the
+// messages given up on.
+func (q *Queue) Drop(id string) error {
+	if strings.ContainsRune(id, '/') {
+		return fmt.Errorf("invalid queue ID %q", id)
+	}
+
+	err := os.Remove(q.filename("new", id))
+	if os.IsNotExist(err) {
+		err = os.Remove(q.filename("cur", id))
+	}
+
+	if err != nil {
+		return fmt.Errorf("unable to drop queued message %q: %w", id, err)
+	}
+
+	return nil
+}
+
+// QueueFailure reports a queued message that failed to send again.
+type QueueFailure struct {
+	Entry *QueueEntry
+	Err   error
+}
+
+// QueueFlushReport describes the outcome of Queue.Flush.
+type QueueFlushReport struct {
+	// Sent lists the messages delivered and removed from the queue.
+	Sent []*QueueEntry
+
+	// Failed lists the messages that failed again and remain queued.
+	Failed []QueueFailure
+
+	// GaveUp lists the messages that failed permanently or for too long and
+	// were moved out of the queue.
+	GaveUp []QueueFailure
+
+	// Waiting is the number of messages not yet due to be retried.
+	Waiting int
+}
+
+// String summarizes the report.
+func (r *QueueFlushReport) String() string {
+	return fmt.Sprintf("Sent %d queued messages, %d failed, %d given up, %d waiting.",
+		len(r.Sent), len(r.Failed), len(r.GaveUp), r.Waiting)
+}
+
+// Flush attempts to send every queued message that is due for a retry, or
+// every queued message if force is true. The mailer function returns the
+// Mailer to send with for the entry's account. Messages that are sent are
+// removed from the queue. Messages that fail again stay in the queue and are
+// retried after a longer delay, unless the failure is permanent, the message
+// has failed QueueMaxAttempts times, or it was queued more than QueueMaxAge
+// ago. Those are given up on and moved out of the queue. A message delivered
+// to some of its recipients stays in the queue for the rest.
+func (q *Queue) Flush(
+	now time.Time,
+	force bool,
+	mailer func(account string) (*Mailer, error),
+) (*QueueFlushReport, error) {
+	es, err := q.Entries()
+	if err != nil {
+		return nil, err
+	}
+
+	report := &QueueFlushReport{}
+	for _, e := range es {
+		if !force && now.Before(e.NextAttempt) {
+			report.Waiting++
+			continue
+		}
+
+		sendErr := q.send(e, mailer)
+		if sendErr == nil {
+			err = q.Drop(e.ID)
+			if err != nil {
+				return report, err
+			}
+
+			report.Sent = append(report.Sent, e)
+			continue
+		}
+
+		// only retry the recipients the message was not delivered to
+		e.To = failedRecipients(e.To, sendErr)
+
+		if IsPermanentSendError(sendErr) ||
+			e.Attempts+1 >= QueueMaxAttempts ||
+			now.Sub(e.Created) >= QueueMaxAge {
+			report.GaveUp = append(report.GaveUp, QueueFailure{e, sendErr})
+			err = q.giveUp(e, sendErr)
+		} else {
+			report.Failed = append(report.Failed, QueueFailure{e, sendErr})
+			err = q.retryLater(e, sendErr, now)
+		}
+
+		if err != nil {
+			return report, err
+		}
+	}
+
+	return report, nil
+}
+
+// send delivers a single queued message with the queue metadata removed.
+func (q *Queue) send(e *QueueEntry, mailer func(account string) (*Mailer, error)) error {
+	m, err := mailer(e.Account)
+	if err != nil {
+		return err
+	}
+
+	_, mm, err := q.read("new", e.ID)
+	if err != nil {
+		return err
+	}
+
+	h := mm.GetHeader()
+	for i := len(h.ListFields()) - 1; i >= 0; i-- {
+		switch name := h.GetField(i).Name(); {
+		case strings.HasPrefix(strings.ToLower(name), "x-queue-"),
+			strings.EqualFold(name, EnvelopeFromHeader),
+			strings.EqualFold(name, EnvelopeToHeader):
+			_ = h.DeleteField(i)
+		}
+	}
+
+	buf := &bytes.Buffer{}
+	_, err = mm.WriteTo(buf)
+	if err != nil {
+		return err
+	}
+
+	return m.Transport.Send(e.From, e.To, buf)
+}
+
+// retryLater records a failed attempt and schedules the next one.
+func (q *Queue) retryLater(e *QueueEntry, sendErr error, now time.Time) error {
+	_, mm, err := q.read("new", e.ID)
+	if err != nil {
+		return err
+	}
+
+	e.Attempts++
+	e.NextAttempt = now.Add(QueueDelay(e.Attempts))
+	e.LastError = sendErr.Error()
+	setQueueState(mm.GetHeader(), e)
+
+	return q.write(e.ID, mm)
+}
+
+// giveUp records the final failed attempt and moves the message out of the
+// queue into cur, where it is kept until dropped.
+func (q *Queue) giveUp(e *QueueEntry, sendErr error) error {
+	_, mm, err := q.read("new", e.ID)
+	if err != nil {
+		return err
+	}
+
+	e.Attempts++
+	e.LastError = sendErr.Error()
+	setQueueState(mm.GetHeader(), e)
+
+	err = q.write(e.ID, mm)
+	if err != nil {
+		return err
+	}
+
+	err = os.Rename(q.filename("new", e.ID), q.filename("cur", e.ID))
+	if err != nil {
+		return fmt.Errorf("unable to give up on queued message %q: %w", e.ID, err)
+	}
+
+	return nil
+}
+
+// IsPermanentSendError returns true if the error is an SMTP 5xx reply, which
+// means retrying the message will not help.
+func IsPermanentSendError(err error) bool {
+	var smtpErr *smtp.SMTPError
+	return errors.As(err, &smtpErr) && smtpErr.Code/100 == 5
+}
+
+// isTemporarySendError returns true if the error is an SMTP 4xx reply, which
+// means the message may be sent later.
+func isTemporarySendError(err error) bool {
+	var smtpErr *smtp.SMTPError
+	return errors.As(err, &smtpErr) && smtpErr.Code/100 == 4
+}
+
+// QueueingTransport is a Transport that queues messages it fails to send.
+type QueueingTransport struct {
+	// Queue is where failed messages are kept.
+	Queue *Queue
+
+	// Account names the SMTP account used to send queued messages later.
+	Account string
+
+	// Transport is the Transport that actually sends the mail.
+	Transport Transport
+
+	// Now returns the current time.
+	Now func() time.Time
+}
+
+var _ Transport = &QueueingTransport{}
+
+// Send tries to send the message and queues it if that fails. When the
+// message is queued, the error returned is a *QueuedError. If the message was
+// delivered to some recipients, it is only queued for the rest. A permanent
+// failure is returned as is, without queueing the message.
+func (t *QueueingTransport) Send(from string, to []string, r io.Reader) error {
+	bs, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+
+	sendErr := t.Transport.Send(from, to, bytes.NewReader(bs))
+	if sendErr == nil || IsPermanentSendError(sendErr) {
+		return sendErr
+	}
+
+	queueTo := failedRecipients(to, sendErr)
+	e, err := t.Queue.Enqueue(t.Account, from, queueTo, bytes.NewReader(bs), sendErr, t.Now())
+	if err != nil {
+		return fmt.Errorf("failed to queue message after send failed (%v): %w", sendErr, err)
+	}
+
+	return &QueuedError{e.ID, e.To, sendErr}
+}
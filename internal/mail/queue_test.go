@@ -0,0 +1,280 @@
+package mail
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/emersion/go-smtp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zostay/go-addr/pkg/addr"
+
+	"github.com/zostay/dotfiles-go/internal/fssafe"
+)
+
+const queueTestMessage = `From: me@example.com
+To: you@example.com
+Subject: Queued
+
+Try, try again.
+`
+
+// failingTransport is a Transport that always fails.
+type failingTransport struct{}
+
+func (failingTransport) Send(string, []string, io.Reader) error {
+	return errors.New("connection refused")
+}
+
+// rejectingTransport is a Transport that always fails permanently.
+type rejectingTransport struct{}
+
+func (rejectingTransport) Send(string, []string, io.Reader) error {
+	return fmt.Errorf("SMTP server rejected recipient: %w",
+		&smtp.SMTPError{Code: 550, Message: "no such user"})
+}
+
+func TestQueueDelay(t *testing.T) {
+	t.Parallel()
+
+	assert.Equal(t, time.Minute, QueueDelay(1))
+	assert.Equal(t, 2*time.Minute, QueueDelay(2))
+	assert.Equal(t, 4*time.Minute, QueueDelay(3))
+	assert.Equal(t, QueueMaxDelay, QueueDelay(100))
+}
+
+func TestQueue(t *testing.T) {
+	t.Parallel()
+
+	now := time.Date(2022, 11, 22, 23, 11, 59, 0, time.UTC)
+	q := NewQueue(filepath.Join(t.TempDir(), "queue"))
+	qt := &QueueingTransport{q, "work", failingTransport{}, func() time.Time { return now }}
+
+	err := qt.Send("me@example.com", []string{"you@example.com"}, strings.NewReader(queueTestMessage))
+	assert.ErrorIs(t, err, ErrQueued)
+
+	es, err := q.Entries()
+	require.NoError(t, err)
+	require.Len(t, es, 1)
+	assert.Equal(t, "work", es[0].Account)
+	assert.Equal(t, "me@example.com", es[0].From)
+	assert.Equal(t, []string{"you@example.com"}, es[0].To)
+	assert.Equal(t, 1, es[0].Attempts)
+	assert.Equal(t, now.Add(time.Minute), es[0].NextAttempt)
+	assert.Equal(t, "connection refused", es[0].LastError)
+
+	failing := func(string) (*Mailer, error) {
+		return &Mailer{Transport: failingTransport{}}, nil
+	}
+
+	report, err := q.Flush(now, false, failing)
+	require.NoError(t, err)
+	assert.Equal(t, 1, report.Waiting)
+
+	report, err = q.Flush(now.Add(time.Minute), false, failing)
+	require.NoError(t, err)
+	assert.Len(t, report.Failed, 1)
+
+	es, err = q.Entries()
+	require.NoError(t, err)
+	require.Len(t, es, 1)
+	assert.Equal(t, 2, es[0].Attempts)
+	assert.Equal(t, now.Add(3*time.Minute), es[0].NextAttempt)
+
+	outbox := filepath.Join(t.TempDir(), "outbox")
+	var account string
+	working := func(a string) (*Mailer, error) {
+		account = a
+		return &Mailer{Transport: NewOutboxTransport(outbox)}, nil
+	}
+
+	report, err = q.Flush(now, true, working)
+	require.NoError(t, err)
+	assert.Len(t, report.Sent, 1)
+	assert.Equal(t, "work", account)
+
+	es, err = q.Entries()
+	require.NoError(t, err)
+	assert.Empty(t, es)
+
+	des, err := os.ReadDir(filepath.Join(outbox, "new"))
+	require.NoError(t, err)
+	require.Len(t, des, 1)
+
+	bs, err := os.ReadFile(filepath.Join(outbox, "new", des[0].Name()))
+	require.NoError(t, err)
+	assert.NotContains(t, string(bs), "X-Queue-")
+	assert.Equal(t, 1, strings.Count(string(bs), "X-Envelope-From"))
+	assert.Contains(t, string(bs), "Try, try again.")
+}
+
+func TestQueue_GiveUp(t *testing.T) {
+	t.Parallel()
+
+	now := time.Date(2022, 11, 22, 23, 11, 59, 0, time.UTC)
+	q := NewQueue(filepath.Join(t.TempDir(), "queue"))
+
+	// a permanent failure is not queued
+	qt := &QueueingTransport{q, "work", rejectingTransport{}, func() time.Time { return now }}
+	err := qt.Send("me@example.com", []string{"nobody@example.com"}, strings.NewReader(queueTestMessage))
+	assert.True(t, IsPermanentSendError(err))
+	assert.NotErrorIs(t, err, ErrQueued)
+
+	es, err := q.Entries()
+	require.NoError(t, err)
+	assert.Empty(t, es)
+
+	failing := func(string) (*Mailer, error) {
+		return &Mailer{Transport: failingTransport{}}, nil
+	}
+
+	tooOld, err := q.Enqueue("work", "me@example.com", []string{"you@example.com"},
+		strings.NewReader(queueTestMessage), errors.New("connection refused"), now)
+	require.NoError(t, err)
+
+	report, err := q.Flush(now.Add(QueueMaxAge), true, failing)
+	require.NoError(t, err)
+	assert.Empty(t, report.Failed)
+	require.Len(t, report.GaveUp, 1)
+	assert.Equal(t, tooOld.ID, report.GaveUp[0].Entry.ID)
+
+	_, err = q.Enqueue("work", "me@example.com", []string{"you@example.com"},
+		strings.NewReader(queueTestMessage), errors.New("connection refused"), now)
+	require.NoError(t, err)
+
+	for i := 2; i < QueueMaxAttempts; i++ {
+		report, err = q.Flush(now, true, failing)
+		require.NoError(t, err)
+		assert.Len(t, report.Failed, 1)
+	}
+
+	report, err = q.Flush(now, true, failing)
+	require.NoError(t, err)
+	assert.Empty(t, report.Failed)
+	assert.Len(t, report.GaveUp, 1)
+
+	es, err = q.Entries()
+	require.NoError(t, err)
+	assert.Empty(t, es)
+
+	failed, err := q.Failed()
+	require.NoError(t, err)
+	require.Len(t, failed, 2)
+	assert.Equal(t, QueueMaxAttempts, failed[1].Attempts)
+	assert.Equal(t, "connection refused", failed[1].LastError)
+
+	require.NoError(t, q.Drop(tooOld.ID))
+
+	failed, err = q.Failed()
+	require.NoError(t, err)
+	assert.Len(t, failed, 1)
+}
+
+func TestQueue_Drop(t *testing.T) {
+	t.Parallel()
+
+	q := NewQueue(filepath.Join(t.TempDir(), "queue"))
+	e, err := q.Enqueue("", "me@example.com", []string{"you@example.com"},
+		strings.NewReader(queueTestMessage), errors.New("nope"), time.Now())
+	require.NoError(t, err)
+
+	assert.Error(t, q.Drop("../"+e.ID))
+	require.NoError(t, q.Drop(e.ID))
+	assert.Error(t, q.Drop(e.ID))
+
+	es, err := q.Entries()
+	require.NoError(t, err)
+	assert.Empty(t, es)
+}
+
+func TestFilter_ForwardQueued(t *testing.T) {
+	t.Parallel()
+
+	f, _ := mkTempFilter(t)
+	f.smtp = &SMTPConfig{SMTPAccount: SMTPAccount{
+		Host: "127.0.0.1",
+		Port: 1,
+		TLS:  TLSNone,
+		Auth: AuthNone,
+		From: "me@example.com",
+	}}
+	f.SetCredentialsProvider(NewTestingCredentials())
+	f.SetForwardLedger(NewForwardLedger(fssafe.NewTestingLoaderSaver()))
+	f.SetQueue(NewQueue(filepath.Join(t.TempDir(), "queue")))
+	f.SetAllowSendingEmail(true)
+
+	to, err := addr.ParseEmailAddressList("you@example.com")
+	require.NoError(t, err)
+	rule := &CompiledRule{Match: Match{Folder: "INBOX", Subject: "Foo"}, Forward: to}
+
+	m, err := f.Message("INBOX", "1:2,S")
+	require.NoError(t, err)
+	actions, err := f.ApplyRule(m, rule)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"Queued Forward to you@example.com"}, actions)
+
+	es, err := f.Queue().Entries()
+	require.NoError(t, err)
+	require.Len(t, es, 1)
+	assert.Equal(t, []string{"you@example.com"}, es[0].To)
+
+	// the forward is pending until the queue delivers it
+	h, err := f.ForwardLedger().History()
+	require.NoError(t, err)
+	require.Len(t, h, 1)
+	assert.Equal(t, es[0].ID, h[0].Queued)
+
+	f.smtp = &SMTPConfig{SMTPAccount: SMTPAccount{
+		From:   "me@example.com",
+		Outbox: filepath.Join(t.TempDir(), "outbox"),
+	}}
+
+	report, err := f.FlushQueue(true)
+	require.NoError(t, err)
+	assert.Len(t, report.Sent, 1)
+
+	h, err = f.ForwardLedger().History()
+	require.NoError(t, err)
+	require.Len(t, h, 1)
+	assert.Empty(t, h[0].Queued)
+}
+
+// partialTransport is a Transport that delivers to every recipient but one.
+type partialTransport struct{ rejected string }
+
+func (t partialTransport) Send(string, []string, io.Reader) error {
+	return &RecipientsError{[]string{t.rejected},
+		&smtp.SMTPError{Code: 452, Message: "mailbox full"}}
+}
+
+func TestQueue_FlushPartial(t *testing.T) {
+	t.Parallel()
+
+	now := time.Date(2022, 11, 22, 23, 11, 59, 0, time.UTC)
+	q := NewQueue(filepath.Join(t.TempDir(), "queue"))
+
+	e, err := q.Enqueue("work", "me@example.com", []string{"you@example.com", "them@example.com"},
+		strings.NewReader(queueTestMessage), errors.New("connection refused"), now)
+	require.NoError(t, err)
+
+	report, err := q.Flush(now, true, func(string) (*Mailer, error) {
+		return &Mailer{Transport: partialTransport{"them@example.com"}}, nil
+	})
+	require.NoError(t, err)
+	require.Len(t, report.Failed, 1)
+	assert.Equal(t, []string{"them@example.com"}, report.Failed[0].Entry.To)
+
+	// only the recipient that was rejected is retried
+	es, err := q.Entries()
+	require.NoError(t, err)
+	require.Len(t, es, 1)
+	assert.Equal(t, e.ID, es[0].ID)
+	assert.Equal(t, []string{"them@example.com"}, es[0].To)
+	assert.Equal(t, 2, es[0].Attempts)
+}
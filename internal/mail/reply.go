@@ -0,0 +1,385 @@
+package mail
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/zostay/go-addr/pkg/addr"
+	"github.com/zostay/go-email/v2/message"
+	"github.com/zostay/go-email/v2/message/header"
+
+	"github.com/zostay/dotfiles-go/internal/dotfiles"
+	"github.com/zostay/dotfiles-go/internal/fssafe"
+)
+
+const (
+	// ReplyStateFile is the name of the file in the home directory that
+	// records when each sender was last sent an automatic reply.
+	ReplyStateFile = ".label-mail.replies.yml"
+
+	// DefaultReplyDays is the number of days to wait before sending another
+	// automatic reply to the same sender when the rule does not say.
+	DefaultReplyDays = 1
+)
+
+// RawReply is the reply action as given in the configuration file.
+//
+//	reply:
+//	  subject: "Re: {{.Subject}}"
+//	  days: 7
+//	  body: |
+//	    I am away until Monday and will read your message about
+//	    "{{.Subject}}" sent {{.Date.Format "Jan 2"}} when I return.
+type RawReply struct {
+	// Subject is the template for the reply subject. If not given, the
+	// original subject is used with a "Re: " prefix.
+	Subject string `yaml:"subject"`
+
+	// Body is the template for the body of the reply.
+	Body string `yaml:"body"`
+
+	// Days is the number of days to wait before replying to the same sender
+	// again. It defaults to DefaultReplyDays.
+	Days int `yaml:"days"`
+}
+
+// CompiledReply is the reply action after the templates have been parsed.
+type CompiledReply struct {
+	// Subject is the subject template or nil to use the default subject.
+	Subject *template.Template
+
+	// Body is the body template.
+	Body *template.Template
+
+	// Every is the minimum time between replies to the same sender.
+	Every time.Duration
+}
+
+// ReplyData is the data made available to reply templates.
+type ReplyData struct {
+	// Subject is the subject of the original message.
+	Subject string
+
+	// From is the list of authors of the original message.
+	From addr.AddressList
+
+	// Date is the date of the original message.
+	Date time.Time
+}
+
+// CompileReply parses the templates of a reply action. It returns nil if no
+// reply is given.
+func CompileReply(r *RawReply) (*CompiledReply, error) {
+	if r == nil {
+		return nil, nil
+	}
+
+	if strings.TrimSpace(r.Body) == "" {
+		return nil, errors.New("reply is missing a body")
+	}
+
+	var (
+		cr  CompiledReply
+		err error
+	)
+	if r.Subject != "" {
+		cr.Subject, err = template.New("subject").Parse(r.Subject)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse reply subject template: %w", err)
+		}
+	}
+
+	cr.Body, err = template.New("body").Parse(r.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse reply body template: %w", err)
+	}
+
+	days := r.Days
+	if days <= 0 {
+		days = DefaultReplyDays
+	}
+	cr.Every = time.Duration(days) * 24 * time.Hour
+
+	return &cr, nil
+}
+
+// ReplyAddress returns the address replies to the message should go to, which
+// is the Reply-To address, if given, or the From address.
+func (m *Message) ReplyAddress() (addr.AddressList, error) {
+	rt, err := m.AddressList("Reply-To")
+	if err == nil && len(rt) > 0 {
+		return rt, nil
+	}
+
+	return m.AddressList("From")
+}
+
+// AutomatedReason returns a non-empty reason when the message declares itself
+// to be automated or bulk mail, which should never receive an automatic reply.
+// See RFC 3834.
+func (m *Message) AutomatedReason() (string, error) {
+	h, err := m.EmailHeader()
+	if err != nil {
+		return "", err
+	}
+
+	as, _ := h.Get("Auto-Submitted")
+	as = strings.ToLower(strings.TrimSpace(as))
+	if as != "" && as != "no" {
+		return "Auto-Submitted: " + as, nil
+	}
+
+	prec, _ := h.Get("Precedence")
+	prec = strings.ToLower(strings.TrimSpace(prec))
+	switch prec {
+	case "bulk", "junk", "list":
+		return "Precedence: " + prec, nil
+	}
+
+	return "", nil
+}
+
+// ReplyMessage builds a reply to the current message from the given address
+// to the given address using the templates of the reply action.
+func (m *Message) ReplyMessage(
+	from, to addr.AddressList,
+	r *CompiledReply,
+	now time.Time,
+) (io.WriterTo, error) {
+	h, err := m.EmailHeader()
+	if err != nil {
+		return nil, err
+	}
+
+	data := ReplyData{}
+	data.Subject, _ = h.GetSubject()
+	data.From, _ = h.GetFrom()
+	data.Date, _ = h.GetDate()
+
+	subject := data.Subject
+	if r.Subject != nil {
+		buf := &strings.Builder{}
+		err = r.Subject.Execute(buf, data)
+		if err != nil {
+			return nil, fmt.Errorf("failed to render reply subject: %w", err)
+		}
+		subject = buf.String()
+	} else if !strings.HasPrefix(strings.ToLower(subject), "re:") {
+		subject = "Re: " + subject
+	}
+
+	rm := &message.Buffer{}
+	rm.SetDate(now)
+	rm.SetAddressList(header.To, to...)
+	rm.SetAddressList(header.From, from...)
+	rm.SetSubject(subject)
+	rm.SetMessageID(NewMessageID(from, now))
+	rm.Set("Auto-Submitted", "auto-replied")
+	rm.SetMediaType("text/plain")
+	_ = rm.SetCharset("utf-8")
+
+	// as in RFC 5322 section 3.6.4, the parent's References, or its
+	// In-Reply-To if it has no References, followed by the parent's Message-ID
+	if id, err := m.MessageID(); err == nil && id != "" {
+		var refs []string
+		for _, f := range h.GetAllFieldsNamed("References") {
+			refs = append(refs, msgIDs.FindAllString(f.Body(), -1)...)
+		}
+
+		if len(refs) == 0 {
+			for _, f := range h.GetAllFieldsNamed("In-Reply-To") {
+				if ids := msgIDs.FindAllString(f.Body(), -1); len(ids) == 1 {
+					refs = ids
+				}
+			}
+		}
+
+		rm.Set("In-Reply-To", id)
+		rm.Set("References", strings.Join(append(refs, id), " "))
+	}
+
+	err = r.Body.Execute(rm, data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to render reply body: %w", err)
+	}
+
+	return rm, nil
+}
+
+// ReplyLog records when automatic replies were last sent to each sender so
+// that replies can be rate limited.
+type ReplyLog struct {
+	ls     fssafe.LoaderSaver
+	sent   map[string]time.Time
+	loaded bool
+}
+
+// DefaultReplyLogPath returns the default location of the reply state file.
+func DefaultReplyLogPath() string {
+	return path.Join(dotfiles.HomeDir, ReplyStateFile)
+}
+
+// NewReplyLog returns a ReplyLog kept using the given loader/saver.
+func NewReplyLog(ls fssafe.LoaderSaver) *ReplyLog {
+	return &ReplyLog{ls: ls}
+}
+
+// load reads the state file the first time it is needed.
+func (l *ReplyLog) load() error {
+	if l.loaded {
+		return nil
+	}
+
+	l.sent = make(map[string]time.Time)
+	err := loadState(l.ls, "reply", &l.sent)
+	if err != nil {
+		return err
+	}
+
+	l.loaded = true
+	return nil
+}
+
+// LastSent returns the time a reply was last sent to the given address. It
+// returns the zero time if no reply has been recorded.
+func (l *ReplyLog) LastSent(email string) (time.Time, error) {
+	if err := l.load(); err != nil {
+		return time.Time{}, err
+	}
+
+	return l.sent[strings.ToLower(email)], nil
+}
+
+// Record notes that a reply was sent to the given address at the given time
+// and saves the state file.
+func (l *ReplyLog) Record(email string, when time.Time) error {
+	if err := l.load(); err != nil {
+		return err
+	}
+
+	l.sent[strings.ToLower(email)] = when
+
+	return saveState(l.ls, "reply", l.sent)
+}
+
+// sentBy returns true if the sender is one of the given from addresses.
+func sentBy(from addr.AddressList, sender string) bool {
+	for _, a := range from {
+		if strings.EqualFold(a.Address(), sender) {
+			return true
+		}
+	}
+	return false
+}
+
+// replyTo sends the reply for the rule to the sender of the message, unless
+// the message has already been replied to, the message is automated, the
+// sender is us, or the sender has already been replied to recently. It returns
+// a description of what happened and whether a reply was made. A reply made
+// is recorded in the X-Zostay-Replied header of the message, which is only
+// modified in memory, so the message must be saved to keep it.
+func (fi *Filter) replyTo(m *Message, c *CompiledRule) (string, bool, error) {
+	to, err := m.ReplyAddress()
+	if err != nil || len(to) == 0 {
+		return "Skipped reply (no sender)", false, nil
+	}
+
+	sender := to[0].Address()
+
+	mh, err := m.EmailHeader()
+	if err != nil {
+		return "", false, err
+	}
+
+	if zrs := mh.GetAllFieldsNamed("X-Zostay-Replied"); len(zrs) > 0 {
+		return fmt.Sprintf("Skipped reply to %s (already replied)", sender), false, nil
+	}
+
+	reason, err := m.AutomatedReason()
+	if err != nil {
+		return "", false, err
+	}
+
+	if reason != "" {
+		return fmt.Sprintf("Skipped reply to %s (%s)", sender, reason), false, nil
+	}
+
+	// check the configured from address here so that dry runs skip the same
+	// replies, without looking up credentials
+	acct, err := fi.smtp.Account(c.SMTP)
+	if err != nil {
+		return "", false, err
+	}
+
+	if acct.From != "" {
+		from, err := addr.ParseEmailAddressList(acct.From)
+		if err != nil {
+			return "", false, fmt.Errorf("unable to parse SMTP from address %q: %w", acct.From, err)
+		}
+
+		if sentBy(from, sender) {
+			return fmt.Sprintf("Skipped reply to %s (sent by me)", sender), false, nil
+		}
+	}
+
+	last, err := fi.replyLog().LastSent(sender)
+	if err != nil {
+		return "", false, err
+	}
+
+	if !last.IsZero() && fi.now.Sub(last) < c.Reply.Every {
+		return fmt.Sprintf("Skipped reply to %s (replied %s)", sender, last.Format(time.RFC1123)), false, nil
+	}
+
+	if !fi.allowSendingEmail {
+		return "NOT Replied to " + sender, true, nil
+	}
+
+	if fi.dryRun {
+		return "Replied to " + sender, true, nil
+	}
+
+	mailer, err := fi.mailer(c.SMTP)
+	if err != nil {
+		return "", false, err
+	}
+
+	if sentBy(mailer.From, sender) {
+		return fmt.Sprintf("Skipped reply to %s (sent by me)", sender), false, nil
+	}
+
+	rm, err := m.ReplyMessage(mailer.From, to[:1], c.Reply, fi.now)
+	if err != nil {
+		return "", false, err
+	}
+
+	buf := &bytes.Buffer{}
+	_, err = rm.WriteTo(buf)
+	if err != nil {
+		return "", false, err
+	}
+
+	sendErr := mailer.Transport.Send(mailer.EnvelopeFrom(), []string{sender}, buf)
+	if sendErr != nil && !errors.Is(sendErr, ErrQueued) {
+		return "", false, sendErr
+	}
+
+	mh.Set("X-Zostay-Replied", sender)
+
+	err = fi.replyLog().Record(sender, fi.now)
+	if err != nil {
+		return "", false, err
+	}
+
+	if sendErr != nil {
+		return "Queued Reply to " + sender, true, nil
+	}
+
+	return "Replied to " + sender, true, nil
+}
@@ -0,0 +1,250 @@
+package mail
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zostay/go-addr/pkg/addr"
+
+	"github.com/zostay/dotfiles-go/internal/fssafe"
+)
+
+const (
+	replyRequestMessage = `Message-ID: <request@example.com>
+Date: Tue, 22 Nov 2022 10:00:00 -0600
+From: Customer <customer@example.com>
+To: help@example.com
+Subject: Broken widget
+
+My widget is broken.
+`
+
+	replyBulkMessage = `Message-ID: <news@example.com>
+Date: Tue, 22 Nov 2022 10:00:00 -0600
+From: news@example.com
+To: help@example.com
+Precedence: bulk
+Subject: Newsletter
+
+Read all about it.
+`
+)
+
+func TestCompileReply(t *testing.T) {
+	t.Parallel()
+
+	cr, err := CompileReply(nil)
+	assert.NoError(t, err)
+	assert.Nil(t, cr)
+
+	_, err = CompileReply(&RawReply{Subject: "Hi"})
+	assert.Error(t, err, "body is required")
+
+	_, err = CompileReply(&RawReply{Body: "{{.Subject"})
+	assert.Error(t, err, "body must parse")
+
+	cr, err = CompileReply(&RawReply{Body: "Thanks"})
+	require.NoError(t, err)
+	assert.Nil(t, cr.Subject)
+	assert.Equal(t, 24*time.Hour, cr.Every)
+}
+
+func TestReplyMessage(t *testing.T) {
+	t.Parallel()
+
+	_, root := mkTempFilter(t)
+	writeTestMessage(t, root, "INBOX", "30:2,S", replyRequestMessage)
+	m, err := NewMailDirFolder(root, "INBOX").Message("30:2,S")
+	require.NoError(t, err)
+
+	cr, err := CompileReply(&RawReply{
+		Body: `We received "{{.Subject}}" from {{(index .From 0).DisplayName}} on {{.Date.Format "Jan 2"}}.`,
+	})
+	require.NoError(t, err)
+
+	from, err := addr.ParseEmailAddressList("help@example.com")
+	require.NoError(t, err)
+	to, err := m.ReplyAddress()
+	require.NoError(t, err)
+
+	rm, err := m.ReplyMessage(from, to, cr, time.Date(2022, 11, 22, 23, 11, 59, 0, time.UTC))
+	require.NoError(t, err)
+
+	buf := &bytes.Buffer{}
+	_, err = rm.WriteTo(buf)
+	require.NoError(t, err)
+
+	out := buf.String()
+	assert.Contains(t, out, "Subject: Re: Broken widget")
+	assert.Contains(t, out, "In-Reply-To: <request@example.com>")
+	assert.Contains(t, out, "References: <request@example.com>")
+	assert.Contains(t, out, "Auto-Submitted: auto-replied")
+	assert.Contains(t, out, `We received "Broken widget" from Customer on Nov 22.`)
+}
+
+func TestReplyMessage_References(t *testing.T) {
+	t.Parallel()
+
+	_, root := mkTempFilter(t)
+	cr, err := CompileReply(&RawReply{Body: "Thanks."})
+	require.NoError(t, err)
+
+	from, err := addr.ParseEmailAddressList("help@example.com")
+	require.NoError(t, err)
+
+	references := func(fn, extra string) string {
+		t.Helper()
+
+		writeTestMessage(t, root, "INBOX", fn, extra+replyRequestMessage)
+		m, err := NewMailDirFolder(root, "INBOX").Message(fn)
+		require.NoError(t, err)
+
+		rm, err := m.ReplyMessage(from, from, cr, time.Date(2022, 11, 22, 23, 11, 59, 0, time.UTC))
+		require.NoError(t, err)
+
+		_, mm := forwardTestMessage(t, rm)
+		refs, err := mm.GetHeader().Get("References")
+		require.NoError(t, err)
+		return refs
+	}
+
+	assert.Equal(t,
+		"<a@example.com> <b@example.com> <request@example.com>",
+		references("32:2,S", "In-Reply-To: <b@example.com>\nReferences: <a@example.com> <b@example.com>\n"))
+	assert.Equal(t,
+		"<b@example.com> <request@example.com>",
+		references("33:2,S", "In-Reply-To: <b@example.com>\n"))
+	assert.Equal(t,
+		"<request@example.com>",
+		references("34:2,S", "In-Reply-To: <b@example.com> <c@example.com>\n"))
+}
+
+func TestFilter_Reply(t *testing.T) {
+	t.Parallel()
+
+	f, root := mkTempFilter(t)
+	outbox := filepath.Join(t.TempDir(), "outbox")
+	f.smtp = &SMTPConfig{SMTPAccount: SMTPAccount{From: "help@example.com", Outbox: outbox}}
+	f.SetCredentialsProvider(NewTestingCredentials())
+	f.SetReplyLog(NewReplyLog(fssafe.NewTestingLoaderSaver()))
+	f.SetAllowSendingEmail(true)
+
+	writeTestMessage(t, root, "INBOX", "30:2,S", replyRequestMessage)
+	writeTestMessage(t, root, "INBOX", "31:2,S", replyBulkMessage)
+
+	cr, err := CompileReply(&RawReply{Body: "Thanks, we got it."})
+	require.NoError(t, err)
+	rule := &CompiledRule{Match: Match{Folder: "INBOX", Subject: "Broken widget"}, Reply: cr}
+
+	m, err := f.Message("INBOX", "30:2,S")
+	require.NoError(t, err)
+	actions, err := f.ApplyRule(m, rule)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"Replied to customer@example.com"}, actions)
+
+	bs, err := os.ReadFile(m.Filename())
+	require.NoError(t, err)
+	assert.Contains(t, string(bs), "X-Zostay-Replied: customer@example.com")
+
+	m, err = f.Message("INBOX", "30:2,S")
+	require.NoError(t, err)
+	fi, err := os.Stat(m.Filename())
+	require.NoError(t, err)
+	actions, err = f.ApplyRule(m, rule)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"Skipped reply to customer@example.com (already replied)"}, actions)
+
+	after, err := os.Stat(m.Filename())
+	require.NoError(t, err)
+	assert.Equal(t, fi.ModTime(), after.ModTime(), "a skipped reply does not save the message")
+
+	writeTestMessage(t, root, "INBOX", "32:2,S",
+		strings.Replace(replyRequestMessage, "<request@example.com>", "<again@example.com>", 1))
+	m, err = f.Message("INBOX", "32:2,S")
+	require.NoError(t, err)
+	actions, err = f.ApplyRule(m, rule)
+	require.NoError(t, err)
+	require.Len(t, actions, 1)
+	assert.True(t, strings.HasPrefix(actions[0], "Skipped reply to customer@example.com (replied "), actions[0])
+
+	items, err := f.RuleStats().Report(CompiledRules{rule})
+	require.NoError(t, err)
+	require.Len(t, items, 1)
+	assert.Equal(t, 3, items[0].Matched)
+	assert.Equal(t, 1, items[0].Acted)
+
+	des, err := os.ReadDir(filepath.Join(outbox, "new"))
+	require.NoError(t, err)
+	assert.Len(t, des, 1)
+
+	rule = &CompiledRule{Match: Match{Folder: "INBOX", Subject: "Newsletter"}, Reply: cr}
+	m, err = f.Message("INBOX", "31:2,S")
+	require.NoError(t, err)
+	actions, err = f.ApplyRule(m, rule)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"Skipped reply to news@example.com (Precedence: bulk)"}, actions)
+}
+
+func TestFilter_Reply_SentByMe(t *testing.T) {
+	t.Parallel()
+
+	f, root := mkTempFilter(t)
+	f.smtp = &SMTPConfig{SMTPAccount: SMTPAccount{From: "customer@example.com", Outbox: filepath.Join(t.TempDir(), "outbox")}}
+	f.SetCredentialsProvider(NewTestingCredentials())
+	f.SetReplyLog(NewReplyLog(fssafe.NewTestingLoaderSaver()))
+	f.SetDryRun(true)
+
+	writeTestMessage(t, root, "INBOX", "30:2,S", replyRequestMessage)
+
+	cr, err := CompileReply(&RawReply{Body: "Thanks, we got it."})
+	require.NoError(t, err)
+	rule := &CompiledRule{Match: Match{Folder: "INBOX", Subject: "Broken widget"}, Reply: cr}
+
+	m, err := f.Message("INBOX", "30:2,S")
+	require.NoError(t, err)
+	actions, err := f.ApplyRule(m, rule)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"Skipped reply to customer@example.com (sent by me)"}, actions)
+}
+
+func TestFilter_Reply_NoCredentials(t *testing.T) {
+	t.Parallel()
+
+	cr, err := CompileReply(&RawReply{Body: "Thanks, we got it."})
+	require.NoError(t, err)
+	rule := &CompiledRule{Match: Match{Folder: "INBOX", Subject: "Broken widget"}, Reply: cr}
+
+	reply := func(dryRun bool) ([]string, *TestingCredentials) {
+		t.Helper()
+
+		f, root := mkTempFilter(t)
+		creds := NewTestingCredentials()
+		f.smtp = &SMTPConfig{SMTPAccount: SMTPAccount{Outbox: filepath.Join(t.TempDir(), "outbox")}}
+		f.SetCredentialsProvider(creds)
+		f.SetReplyLog(NewReplyLog(fssafe.NewTestingLoaderSaver()))
+		f.SetAllowSendingEmail(dryRun)
+		f.SetDryRun(dryRun)
+
+		writeTestMessage(t, root, "INBOX", "30:2,S", replyRequestMessage)
+		m, err := f.Message("INBOX", "30:2,S")
+		require.NoError(t, err)
+		actions, err := f.ApplyRule(m, rule)
+		require.NoError(t, err)
+
+		return actions, creds
+	}
+
+	actions, creds := reply(true)
+	assert.Equal(t, []string{"Replied to customer@example.com"}, actions)
+	assert.Equal(t, 0, creds.Calls, "dry run does not look up credentials")
+
+	actions, creds = reply(false)
+	assert.Equal(t, []string{"NOT Replied to customer@example.com"}, actions)
+	assert.Equal(t, 0, creds.Calls, "not sending does not look up credentials")
+}
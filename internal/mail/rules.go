@@ -36,6 +36,14 @@ type Match struct {
 	// FromDomain is used to match email address domains in the From header.
 	FromDomain string `yaml:"from_domain"`
 
+	// FromContact is used to match any email address of the named contact in
+	// the From header. Use "*" to match anyone in the address book.
+	FromContact string `yaml:"from_contact"`
+
+	// FromGroup is used to match the email address of any contact in the named
+	// address book group in the From header.
+	FromGroup string `yaml:"from_group"`
+
 	// To is used to match email addresses in the To header.
 	To string `yaml:"to"`
 
@@ -54,6 +62,10 @@ type Match struct {
 	// DeliveredTo is used to match email addresses in the Delivered-To header.
 	DeliveredTo string `yaml:"delivered_to"`
 
+	// ListID is used to match the identifier of the mailing list in the
+	// List-Id header (e.g., "golang-nuts.googlegroups.com").
+	ListID string `yaml:"list_id"`
+
 	// Subject is used to match entire Subject header exactly.
 	Subject string `yaml:"subject"`
 
@@ -75,9 +87,103 @@ type Match struct {
 	// but with case-insensitivity.
 	ContainsFold string `yaml:"icontains"`
 
+	// BodyContains is used to match a substring of the message body after
+	// transfer encodings and charsets have been decoded. Only the text parts of
+	// the body are searched.
+	BodyContains string `yaml:"body_contains"`
+
+	// BodyContainsFold is used to match a substring of the decoded message
+	// body, but with case-insensitivity.
+	BodyContainsFold string `yaml:"body_icontains"`
+
+	// BodyStripHTML causes HTML parts to be converted to plain text before
+	// BodyContains and BodyContainsFold are matched, so that markup is not
+	// matched.
+	BodyStripHTML bool `yaml:"body_strip_html"`
+
 	// Days limits matches to email messages older than the given number
 	// of days.
 	Days int `yaml:"days"`
+
+	// NewerThan limits matches to email messages with a Date newer than the
+	// given age, such as "36h" or "7d".
+	NewerThan Age `yaml:"newer_than"`
+
+	// ReceivedBetween limits matches to email messages with a Date whose time
+	// of day falls within the window, such as "18:00-08:00".
+	ReceivedBetween *TimeWindow `yaml:"received_between"`
+
+	// Weekdays limits matches to email messages with a Date on one of the
+	// given days of the week, such as [sat, sun] or "mon-fri".
+	Weekdays Weekdays `yaml:"weekdays"`
+
+	// Timezone names the time zone (e.g., "America/Chicago") used to determine
+	// the time of day and day of the week of the message Date for
+	// ReceivedBetween and Weekdays. The local time zone is used by default.
+	Timezone string `yaml:"timezone"`
+
+	// HasAttachment is used to match messages that have (when true) or do not
+	// have (when false) attachments.
+	HasAttachment *bool `yaml:"has_attachment"`
+
+	// AttachmentName is used to match messages with an attachment whose file
+	// name matches the given glob pattern, without regard to case.
+	AttachmentName string `yaml:"attachment_name"`
+
+	// AttachmentType is used to match messages with an attachment whose media
+	// type matches the given glob pattern (e.g., "application/pdf" or
+	// "image/*").
+	AttachmentType string `yaml:"attachment_type"`
+
+	// SizeGreater is used to match messages larger than the given size.
+	SizeGreater ByteSize `yaml:"size_greater"`
+
+	// SizeLess is used to match messages smaller than the given size.
+	SizeLess ByteSize `yaml:"size_less"`
+
+	// ThreadHasLabel is used to match messages belonging to a conversation in
+	// which some message already carries the given label.
+	ThreadHasLabel string `yaml:"thread_has_label"`
+
+	// DKIM is used to match the result of DKIM verification reported in the
+	// Authentication-Results or ARC-Authentication-Results headers (e.g.,
+	// "pass" or "fail").
+	DKIM string `yaml:"dkim"`
+
+	// DKIMDomain limits the DKIM test to signatures by the given domain or
+	// its subdomains. If given without DKIM, the DKIM result must be "pass".
+	DKIMDomain string `yaml:"dkim_domain"`
+
+	// SPF is used to match the result of the SPF check reported in the
+	// authentication results headers (e.g., "pass", "fail", or "softfail").
+	SPF string `yaml:"spf"`
+
+	// SPFDomain limits the SPF test to the given envelope sender domain or its
+	// subdomains. If given without SPF, the SPF result must be "pass".
+	SPFDomain string `yaml:"spf_domain"`
+
+	// DMARC is used to match the result of the DMARC check reported in the
+	// authentication results headers.
+	DMARC string `yaml:"dmarc"`
+
+	// DMARCDomain limits the DMARC test to the given From domain or its
+	// subdomains. If given without DMARC, the DMARC result must be "pass".
+	DMARCDomain string `yaml:"dmarc_domain"`
+
+	// ARC is used to match the result of the ARC chain validation reported
+	// in the authentication results headers.
+	ARC string `yaml:"arc"`
+
+	// AuthServID limits the authentication tests to results reported by the
+	// server with the given authserv-id (e.g., "mx.google.com"). Without it,
+	// only the topmost Authentication-Results header is trusted, which is only
+	// safe if the last server to handle the message added one.
+	AuthServID string `yaml:"auth_serv_id"`
+
+	// SpamScoreAbove matches messages that the trained spam classifier scores
+	// above this value, which must be between 0 and 1. A zero value disables
+	// the test.
+	SpamScoreAbove float64 `yaml:"spam_score_above"`
 }
 
 // CompiledRule is the match after it has been processed by the rule compiler.
@@ -89,17 +195,56 @@ type CompiledRule struct {
 	// rule unless it has a Date header before the OkayDate.
 	OkayDate time.Time
 
+	// NewerDate is the date calculated from NewerThan. A message does not
+	// match this rule unless it has a Date header after the NewerDate.
+	NewerDate time.Time
+
+	// Location is the time zone loaded from Timezone. It is nil when the local
+	// time zone is used.
+	Location *time.Location
+
 	// Clear lists the labels to clear from the message.
 	Clear []string
 
 	// Label lists the lables to add to the message.
 	Label []string
 
+	// LabelThread lists the labels to add to every message in the same
+	// conversation as the message.
+	LabelThread []string
+
 	// Move lists the folder to move the message into.
 	Move string
 
 	// Forward gives the addresses to send the message to.
 	Forward addr.AddressList
+
+	// ForwardMode is the mode used for forwarding: inline, redirect, or
+	// attach. Empty means inline.
+	ForwardMode string
+
+	// Reply is the automatic reply to send to the sender of the message.
+	Reply *CompiledReply
+
+	// SMTP names the SMTP account to forward as. The default account is used
+	// when empty.
+	SMTP string
+
+	// Expanded holds the values of the address and domain match fields that
+	// refer to named lists, keyed by the YAML name of the field.
+	Expanded map[string][]string
+
+	origin *CompiledRule // the configured rule this rule was derived from
+}
+
+// values returns the values to match for the named address or domain field.
+// This is the expanded list if the field refers to a named list or the value
+// itself otherwise.
+func (c *CompiledRule) values(field, value string) []string {
+	if vs, ok := c.Expanded[field]; ok {
+		return vs
+	}
+	return []string{value}
 }
 
 // IsClearing returns true if the message lists labels to clear.
@@ -108,15 +253,33 @@ func (c *CompiledRule) IsClearing() bool { return len(c.Clear) != 0 }
 // IsLabeling returns true if the message lists labels to add.
 func (c *CompiledRule) IsLabeling() bool { return len(c.Label) != 0 }
 
+// IsLabelingThread returns true if the message lists labels to add to the
+// conversation.
+func (c *CompiledRule) IsLabelingThread() bool { return len(c.LabelThread) != 0 }
+
 // IsMoving returns true if the message has a Move folder.
 func (c *CompiledRule) IsMoving() bool { return c.Move != "" }
 
 // IsForwarding returns true if the message has forwarding addresses.
 func (c *CompiledRule) IsForwarding() bool { return len(c.Forward) != 0 }
 
+// IsReplying returns true if the rule sends an automatic reply.
+func (c *CompiledRule) IsReplying() bool { return c.Reply != nil }
+
 // HasOkayDate returns true if the OkayDate is set.
 func (c *CompiledRule) HasOkayDate() bool { return c.OkayDate != time.Time{} }
 
+// HasNewerDate returns true if the NewerDate is set.
+func (c *CompiledRule) HasNewerDate() bool { return c.NewerDate != time.Time{} }
+
+// InLocation returns the time in the time zone of the rule.
+func (c *CompiledRule) InLocation(t time.Time) time.Time {
+	if c.Location == nil {
+		return t.Local()
+	}
+	return t.In(c.Location)
+}
+
 // NeedsOkayDate returns true if Days is set on the Match or if the rule adds
 // the Trash label or if the rule moves the message to the Trash.
 func (c *CompiledRule) NeedsOkayDate() bool {
@@ -153,12 +316,29 @@ type RawRule struct {
 	// matches.
 	Label interface{} `yaml:"label"`
 
+	// LabelThread is either a string or list containing labels to add to every
+	// message in the conversation when a message matches.
+	LabelThread interface{} `yaml:"label_thread"`
+
 	// Move is the name of the folder to move matching messages into.
 	Move string `yaml:"move"`
 
 	// Forward is the string or list containing email addresses to send the
 	// message to if it matches.
 	Forward interface{} `yaml:"forward"`
+
+	// ForwardMode selects how the message is forwarded: inline (the default)
+	// quotes the message in a new message, redirect resends the original
+	// message, and attach sends the original message as an attachment.
+	ForwardMode string `yaml:"forward_mode"`
+
+	// Reply is the automatic reply to send to the sender if the message
+	// matches.
+	Reply *RawReply `yaml:"reply"`
+
+	// SMTP names the account in the smtp configuration to forward the message
+	// as. If not given, the default account is used.
+	SMTP string `yaml:"smtp"`
 }
 
 // RawRules is a list of rules
@@ -170,21 +350,96 @@ type EnvRawRules map[string]RawRules
 // CompiledRules is a list of compiled rules sectioned by environment name.
 type CompiledRules []*CompiledRule
 
-// LoadEnvRawRules loads the standard rules file split up into into environment
-// sections.
-func LoadEnvRawRules(rulePath string) (EnvRawRules, error) {
-	var pr EnvRawRules
+// RulesFile is the content of the primary rules file. Most top-level keys name
+// environment sections holding rules, but a few keys are reserved for other
+// configuration.
+type RulesFile struct {
+	// SMTP is the configuration of the outgoing mail accounts, read from the
+	// smtp key.
+	SMTP *SMTPConfig
+
+	// Contacts is the path to the address book, read from the contacts key.
+	Contacts string
+
+	// Lists are the named lists of addresses and domains, read from the lists
+	// key.
+	Lists AddressLists
+
+	// Include lists other rules files to load after this one, read from the
+	// include key. Each may be a glob pattern and relative paths are relative
+	// to the directory of this file.
+	Include []string
+
+	// Rules holds the rules sectioned by environment name.
+	Rules EnvRawRules
+}
+
+// UnmarshalYAML pulls the reserved keys out of the primary rules file and
+// treats everything else as an environment section.
+func (rf *RulesFile) UnmarshalYAML(value *yaml.Node) error {
+	if value.Kind != yaml.MappingNode {
+		return fmt.Errorf("line %d: expected a mapping of environment sections", value.Line)
+	}
+
+	rf.Rules = make(EnvRawRules, len(value.Content)/2)
+	for i := 0; i+1 < len(value.Content); i += 2 {
+		key, val := value.Content[i].Value, value.Content[i+1]
+		switch key {
+		case "smtp":
+			rf.SMTP = &SMTPConfig{}
+			if err := val.Decode(rf.SMTP); err != nil {
+				return err
+			}
+		case "include":
+			if val.Kind == yaml.ScalarNode {
+				rf.Include = []string{val.Value}
+			} else if err := val.Decode(&rf.Include); err != nil {
+				return err
+			}
+		case "lists":
+			if err := val.Decode(&rf.Lists); err != nil {
+				return err
+			}
+		case "contacts":
+			if err := val.Decode(&rf.Contacts); err != nil {
+				return err
+			}
+		default:
+			var rs RawRules
+			if err := val.Decode(&rs); err != nil {
+				return err
+			}
+			rf.Rules[key] = rs
+		}
+	}
+
+	return nil
+}
+
+// LoadRulesFile loads the primary rules file.
+func LoadRulesFile(rulePath string) (*RulesFile, error) {
+	var rf RulesFile
 
 	lbs, err := os.ReadFile(rulePath)
 	if err != nil {
-		return pr, fmt.Errorf("failed to read env rule file %s: %w", rulePath, err)
+		return nil, fmt.Errorf("failed to read env rule file %s: %w", rulePath, err)
 	}
 
-	err = yaml.Unmarshal(lbs, &pr)
+	err = yaml.Unmarshal(lbs, &rf)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse YAML in env rule file %s: %w", rulePath, err)
+	}
+	return &rf, nil
+}
+
+// LoadEnvRawRules loads the standard rules file split up into into environment
+// sections.
+func LoadEnvRawRules(rulePath string) (EnvRawRules, error) {
+	rf, err := LoadRulesFile(rulePath)
 	if err != nil {
-		return pr, fmt.Errorf("failed to parse YAML in env rule file %s: %w", rulePath, err)
+		return nil, err
 	}
-	return pr, nil
+	return rf.Rules, nil
 }
 
 // LoadRawRules loads the rules as a single section. (No sections split out by
@@ -216,6 +471,23 @@ func DefaultLocalRulesConfigPath() string {
 	return path.Join(dotfiles.HomeDir, LocalLabelMailConf)
 }
 
+// Config is the complete configuration loaded from the rules files.
+type Config struct {
+	// Rules is the compiled list of rules.
+	Rules CompiledRules
+
+	// SMTP is the outgoing mail configuration. It is nil if the primary rules
+	// file has no smtp section.
+	SMTP *SMTPConfig
+
+	// Contacts is the path to the address book. It is empty if the primary
+	// rules file does not name one.
+	Contacts string
+
+	// Lists are the named lists of addresses and domains.
+	Lists AddressLists
+}
+
 // LoadRules will load the rules from the various configuration files, combine,
 // compile, and return them. Returns an error if something goes wrong.
 //
@@ -223,50 +495,57 @@ func DefaultLocalRulesConfigPath() string {
 // broken out (usually at located ~/.label-mail.yaml). The local file is the
 // localized configuration file with no environment sections (usually located at
 // ~/.label-mail.local.yaml).
+//
+// The rules in the primary file are followed by those of the files it names
+// under the include key, then by those of every file in the RulesDir of the
+// primary file (usually ~/.label-mail.d) in order by name, and then by the rules
+// of the local file. The "*" section and the sections for each of the active
+// environments (see dotfiles.Environments) are used from every file except the
+// local file.
 func LoadRules(primary, local string) (CompiledRules, error) {
-	env, err := dotfiles.Environment()
+	c, err := LoadConfig(primary, local)
+	if err != nil {
+		return nil, err
+	}
+	return c.Rules, nil
+}
+
+// LoadConfig works just like LoadRules, but returns the rest of the
+// configuration in the primary rules file along with the compiled rules.
+func LoadConfig(primary, local string) (*Config, error) {
+	envs, err := dotfiles.Environments()
 	if err != nil {
-		return nil, fmt.Errorf("failed to determine environment name while loading ruless: Tw", err)
+		return nil, fmt.Errorf("failed to determine environment names while loading rules: %w", err)
 	}
 
-	pr, err := LoadEnvRawRules(primary)
+	l := newRuleLoader(envs)
+	err = l.loadFile(primary)
 	if err != nil {
 		return nil, fmt.Errorf("failed to load env rules file %s: %w", primary, err)
 	}
 
+	err = l.loadDir(RulesDir(primary))
+	if err != nil {
+		return nil, fmt.Errorf("failed to load rules directory: %w", err)
+	}
+
 	lr, err := LoadRawRules(local)
 	if err != nil {
 		return nil, fmt.Errorf("failed to load rules file %s: %w", local, err)
 	}
 
-	ruleCount := len(lr)
-	if _, ok := pr["*"]; ok {
-		ruleCount += len(pr["*"])
-	}
-	if _, ok := pr[env]; ok {
-		ruleCount += len(pr[env])
-	}
+	rr := append(l.rules, lr...)
 
-	rr := make(RawRules, ruleCount)
-	i := 0
-	addRules := func(rs RawRules) {
-		for _, r := range rs {
-			rr[i] = r
-			i++
-		}
-	}
-	if rs, ok := pr["*"]; ok {
-		addRules(rs)
-	}
-	if rs, ok := pr[env]; ok {
-		addRules(rs)
+	err = l.lists.Check()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load lists: %w", err)
 	}
-	addRules(lr)
 
 	crs := make(CompiledRules, 0, len(rr))
 	for _, r := range rr {
 		compiledLabel := CompileLabel("label", r.Label)
 		compiledClear := CompileLabel("clear", r.Clear)
+		compiledLabelThread := CompileLabel("label_thread", r.LabelThread)
 
 		compiledMove := strings.TrimSpace(r.Move)
 		if compiledMove != "" {
@@ -276,28 +555,65 @@ func LoadRules(primary, local string) (CompiledRules, error) {
 			compiledMove = strings.ReplaceAll(compiledMove, "/", ".")
 		}
 
-		compiledForward, err := CompileAddress("forward", r.Forward)
+		compiledForward, err := CompileAddress("forward", r.Forward, l.lists)
+		if err != nil {
+			return nil, fmt.Errorf("filed to compile forwarding address: %w", err)
+		}
+
+		compiledForwardMode := strings.TrimSpace(r.ForwardMode)
+		if compiledForwardMode != "" && !IsForwardMode(compiledForwardMode) {
+			return nil, fmt.Errorf("rule has unknown forward_mode %q", r.ForwardMode)
+		}
+
+		compiledExpanded, err := expandMatchLists(&r.Match, l.lists)
+		if err != nil {
+			return nil, fmt.Errorf("failed to expand lists: %w", err)
+		}
+
+		var compiledLocation *time.Location
+		if r.Timezone != "" {
+			compiledLocation, err = time.LoadLocation(r.Timezone)
+			if err != nil {
+				return nil, fmt.Errorf("rule has unknown timezone %q: %w", r.Timezone, err)
+			}
+		}
+
+		if r.SpamScoreAbove < 0 || r.SpamScoreAbove >= 1 {
+			return nil, fmt.Errorf("rule has spam_score_above %v outside of the range 0 to 1", r.SpamScoreAbove)
+		}
+
+		if !l.smtp.HasAccount(r.SMTP) {
+			return nil, fmt.Errorf("rule names unknown SMTP account %q", r.SMTP)
+		}
+
+		compiledReply, err := CompileReply(r.Reply)
 		if err != nil {
-			return crs, fmt.Errorf("filed to compile forwarding address: %w", err)
+			return nil, fmt.Errorf("failed to compile reply: %w", err)
 		}
 
-		if len(compiledLabel) == 0 && len(compiledClear) == 0 && compiledMove == "" && len(compiledForward) == 0 {
+		if len(compiledLabel) == 0 && len(compiledClear) == 0 && len(compiledLabelThread) == 0 && compiledMove == "" && len(compiledForward) == 0 && compiledReply == nil {
 			pretty.Printf("RULE MISSING ACTION %# v\n", r)
 			continue
 		}
 
 		cr := CompiledRule{
-			Match:   r.Match,
-			Label:   compiledLabel,
-			Clear:   compiledClear,
-			Move:    compiledMove,
-			Forward: compiledForward,
+			Match:       r.Match,
+			Label:       compiledLabel,
+			Clear:       compiledClear,
+			LabelThread: compiledLabelThread,
+			Move:        compiledMove,
+			Forward:     compiledForward,
+			ForwardMode: compiledForwardMode,
+			Reply:       compiledReply,
+			SMTP:        r.SMTP,
+			Expanded:    compiledExpanded,
+			Location:    compiledLocation,
 		}
 
 		crs = append(crs, &cr)
 	}
 
-	return crs, nil
+	return &Config{crs, l.smtp, l.contacts, l.lists}, nil
 }
 
 // CompileField handles fields that can either be provided as a list of items or
@@ -330,15 +646,58 @@ func CompileField(name string, field interface{}) []string {
 	return r1
 }
 
+// expandMatchLists expands the address and domain fields of the match that
+// refer to named lists. It returns nil if no field refers to a list.
+func expandMatchLists(m *Match, lists AddressLists) (map[string][]string, error) {
+	fields := []struct {
+		name  string
+		value string
+	}{
+		{"from", m.From},
+		{"from_domain", m.FromDomain},
+		{"to", m.To},
+		{"to_domain", m.ToDomain},
+		{"cc", m.Cc},
+		{"cc_domain", m.CcDomain},
+		{"sender", m.Sender},
+		{"delivered_to", m.DeliveredTo},
+	}
+
+	var expanded map[string][]string
+	for _, f := range fields {
+		if !IsListRef(f.value) {
+			continue
+		}
+
+		vs, err := lists.Expand(f.value)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", f.name, err)
+		}
+
+		if expanded == nil {
+			expanded = make(map[string][]string)
+		}
+		expanded[f.name] = vs
+	}
+
+	return expanded, nil
+}
+
 // CompileAddress handles fields that can either be provided as a list of items
-// or a single string and turns them into an addr.AddressList. Returns an error
-// if there's a problem parsing the email address(es).
-func CompileAddress(name string, a interface{}) (addr.AddressList, error) {
+// or a single string and turns them into an addr.AddressList. Any item naming
+// one of the lists is replaced by the addresses in that list. Returns an error
+// if there's a problem parsing the email address(es) or expanding a list.
+func CompileAddress(name string, a interface{}, lists AddressLists) (addr.AddressList, error) {
 	r1 := CompileField(name, a)
 	if r1 == nil {
 		return nil, nil
 	}
 
+	r1, err := lists.ExpandAll(r1)
+	if err != nil {
+		return nil, err
+	}
+
 	r2 := make(addr.AddressList, len(r1))
 	for i, a := range r1 {
 		var err error
@@ -370,16 +729,21 @@ func CompileLabel(name string, label interface{}) []string {
 	}
 
 	for i, s := range r2 {
-		s = strings.ReplaceAll(s, ".", "/")
-		if ns, ok := boxLabels[s]; ok {
-			s = ns
-		}
-		r2[i] = s
+		r2[i] = CanonicalLabel(s)
 	}
 
 	return r2
 }
 
+// CanonicalLabel converts a single label name to its canonical form.
+func CanonicalLabel(s string) string {
+	s = strings.ReplaceAll(s, ".", "/")
+	if ns, ok := boxLabels[s]; ok {
+		s = ns
+	}
+	return s
+}
+
 // CompiledFolderRules are CompiledRules grouped by folder name.
 type CompiledFolderRules map[string]CompiledRules
 
@@ -400,6 +764,10 @@ func (crs CompiledRules) FolderRules(now time.Time) CompiledFolderRules {
 			cr.OkayDate = now.Add(time.Duration(-days) * time.Hour * 24)
 		}
 
+		if cr.NewerThan != 0 {
+			cr.NewerDate = now.Add(-time.Duration(cr.NewerThan))
+		}
+
 		folder := ""
 		if cr.Folder != "" {
 			folder = cr.Folder
@@ -412,6 +780,7 @@ func (crs CompiledRules) FolderRules(now time.Time) CompiledFolderRules {
 			andClearInbox.Move = ""
 			andClearInbox.Folder = cr.Move
 			andClearInbox.Clear = []string{"\\Inbox"}
+			andClearInbox.origin = cr
 
 			fcrs.Add(cr.Move, &andClearInbox)
 		}
@@ -0,0 +1,299 @@
+package mail
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"path"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/zostay/dotfiles-go/internal/dotfiles"
+	"github.com/zostay/dotfiles-go/internal/fssafe"
+)
+
+// RuleStatsFile is the name of the file in the home directory that records
+// how often each rule has matched.
+const RuleStatsFile = ".label-mail.rule-stats.yml"
+
+// DefaultRuleStatsPath returns the default location of the rule statistics.
+func DefaultRuleStatsPath() string {
+	return path.Join(dotfiles.HomeDir, RuleStatsFile)
+}
+
+// String returns the age as a duration, such as "36h0m0s".
+func (a Age) String() string { return time.Duration(a).String() }
+
+// Describe returns a one line summary of the rule, listing each match and
+// action that is set as "name=value" using the names used in configuration.
+func (c *CompiledRule) Describe() string {
+	if c.origin != nil {
+		return c.origin.Describe()
+	}
+
+	var parts []string
+	describeFields(&parts, reflect.ValueOf(c.Match))
+
+	add := func(name string, vs []string) {
+		if len(vs) > 0 {
+			parts = append(parts, name+"="+strings.Join(vs, ","))
+		}
+	}
+
+	add("label", c.Label)
+	add("clear", c.Clear)
+	add("label_thread", c.LabelThread)
+	if c.Move != "" {
+		add("move", []string{c.Move})
+	}
+	add("forward", AddressListStrings(c.Forward))
+	if c.ForwardMode != "" {
+		add("forward_mode", []string{c.ForwardMode})
+	}
+	if c.Reply != nil {
+		body, _, _ := strings.Cut(c.Reply.Body.Root.String(), "\n")
+		add("reply", []string{fmt.Sprintf("%q", body)})
+	}
+	if c.SMTP != "" {
+		add("smtp", []string{c.SMTP})
+	}
+
+	return strings.Join(parts, " ")
+}
+
+// describeFields appends "name=value" for every field of the struct that is
+// not the zero value, named by its YAML tag.
+func describeFields(parts *[]string, v reflect.Value) {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		name, _, _ := strings.Cut(t.Field(i).Tag.Get("yaml"), ",")
+		fv := v.Field(i)
+		if name == "" || name == "-" || fv.IsZero() {
+			continue
+		}
+
+		if fv.Kind() == reflect.Pointer {
+			fv = fv.Elem()
+		}
+
+		*parts = append(*parts, fmt.Sprintf("%s=%v", name, fv.Interface()))
+	}
+}
+
+// Key returns an identifier for the rule that stays the same from run to run
+// so long as the rule is not changed.
+func (c *CompiledRule) Key() string {
+	sum := sha1.Sum([]byte(c.Describe()))
+	return hex.EncodeToString(sum[:6])
+}
+
+// RuleStat records how often a single rule has matched.
+type RuleStat struct {
+	// Rule is the description of the rule.
+	Rule string `yaml:"rule"`
+
+	// Matched is the number of messages the rule has matched.
+	Matched int `yaml:"matched"`
+
+	// Acted is the number of messages the rule has matched and changed,
+	// excluding messages that matched, but needed no change.
+	Acted int `yaml:"acted"`
+
+	// FirstSeen is the first time the rule was recorded.
+	FirstSeen time.Time `yaml:"first_seen"`
+
+	// LastMatched is the last time the rule matched a message.
+	LastMatched time.Time `yaml:"last_matched,omitempty"`
+}
+
+// Since returns the time of the last match or, if the rule has never matched,
+// the time the rule was first recorded.
+func (s *RuleStat) Since() time.Time {
+	if s.LastMatched.IsZero() {
+		return s.FirstSeen
+	}
+	return s.LastMatched
+}
+
+// RuleStats holds the match counts of the rules across runs, keyed by
+// CompiledRule.Key.
+type RuleStats struct {
+	ls     fssafe.LoaderSaver
+	loaded bool
+	stats  map[string]*RuleStat
+}
+
+// NewRuleStats returns RuleStats kept with the given LoaderSaver.
+func NewRuleStats(ls fssafe.LoaderSaver) *RuleStats {
+	return &RuleStats{ls: ls}
+}
+
+// load reads the statistics on first use.
+func (rs *RuleStats) load() error {
+	if rs.loaded {
+		return nil
+	}
+
+	stats := make(map[string]*RuleStat)
+	if err := loadState(rs.ls, "rule stats", &stats); err != nil {
+		return err
+	}
+
+	rs.stats = stats
+	rs.loaded = true
+	return nil
+}
+
+// stat returns the entry for the rule, adding one first seen now if needed.
+func (rs *RuleStats) stat(c *CompiledRule, now time.Time) (*RuleStat, error) {
+	if err := rs.load(); err != nil {
+		return nil, err
+	}
+
+	key := c.Key()
+	s, ok := rs.stats[key]
+	if !ok {
+		s = &RuleStat{FirstSeen: now}
+		rs.stats[key] = s
+	}
+	s.Rule = c.Describe()
+
+	return s, nil
+}
+
+// Record counts a match of the rule. The match also counts as acted upon if
+// the rule changed the message.
+func (rs *RuleStats) Record(c *CompiledRule, acted bool, now time.Time) error {
+	s, err := rs.stat(c, now)
+	if err != nil {
+		return err
+	}
+
+	s.Matched++
+	if acted {
+		s.Acted++
+	}
+	if now.After(s.LastMatched) {
+		s.LastMatched = now
+	}
+
+	return nil
+}
+
+// Seen makes sure every rule has an entry, so that rules which never match can
+// be found later.
+func (rs *RuleStats) Seen(rules CompiledRules, now time.Time) error {
+	for _, c := range rules {
+		if _, err := rs.stat(c, now); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Save writes the statistics.
+func (rs *RuleStats) Save() error {
+	if err := rs.load(); err != nil {
+		return err
+	}
+	return saveState(rs.ls, "rule stats", rs.stats)
+}
+
+// RuleStatsItem is the statistics for a single rule in the current
+// configuration.
+type RuleStatsItem struct {
+	RuleStat
+
+	// Key is the rule's CompiledRule.Key.
+	Key string
+}
+
+// Report returns the statistics for each of the given rules, sorted with the
+// most matched first. Rules with no entry are reported with no matches.
+func (rs *RuleStats) Report(rules CompiledRules) ([]RuleStatsItem, error) {
+	if err := rs.load(); err != nil {
+		return nil, err
+	}
+
+	seen := make(map[string]struct{}, len(rules))
+	items := make([]RuleStatsItem, 0, len(rules))
+	for _, c := range rules {
+		key := c.Key()
+		if _, dup := seen[key]; dup {
+			continue
+		}
+		seen[key] = struct{}{}
+
+		item := RuleStatsItem{Key: key, RuleStat: RuleStat{Rule: c.Describe()}}
+		if s, ok := rs.stats[key]; ok {
+			item.RuleStat = *s
+		}
+		items = append(items, item)
+	}
+
+	sort.SliceStable(items, func(i, j int) bool {
+		return items[i].Matched > items[j].Matched
+	})
+
+	return items, nil
+}
+
+// Unused returns the statistics for the rules that have not matched since the
+// given time, oldest first.
+func (rs *RuleStats) Unused(rules CompiledRules, since time.Time) ([]RuleStatsItem, error) {
+	items, err := rs.Report(rules)
+	if err != nil {
+		return nil, err
+	}
+
+	unused := make([]RuleStatsItem, 0, len(items))
+	for _, item := range items {
+		if item.Since().Before(since) {
+			unused = append(unused, item)
+		}
+	}
+
+	sort.SliceStable(unused, func(i, j int) bool {
+		return unused[i].Since().Before(unused[j].Since())
+	})
+
+	return unused, nil
+}
+
+// SetRuleStats changes the RuleStats used to record rule matches. The default
+// is kept in the file named by DefaultRuleStatsPath.
+func (fi *Filter) SetRuleStats(rs *RuleStats) {
+	fi.ruleStats = rs
+}
+
+// RuleStats returns the RuleStats, opening the default one on first use.
+func (fi *Filter) RuleStats() *RuleStats {
+	if fi.ruleStats == nil {
+		fi.ruleStats = NewRuleStats(fssafe.NewFileSystemLoaderSaver(DefaultRuleStatsPath()))
+	}
+	return fi.ruleStats
+}
+
+// recordRuleMatch counts a match of the rule, unless this is a dry run.
+func (fi *Filter) recordRuleMatch(c *CompiledRule, acted bool) error {
+	if fi.dryRun {
+		return nil
+	}
+	return fi.RuleStats().Record(c, acted, fi.now)
+}
+
+// SaveRuleStats records every rule as seen and writes the rule statistics,
+// unless this is a dry run.
+func (fi *Filter) SaveRuleStats() error {
+	if fi.dryRun {
+		return nil
+	}
+
+	rs := fi.RuleStats()
+	if err := rs.Seen(fi.rules, fi.now); err != nil {
+		return err
+	}
+	return rs.Save()
+}
@@ -0,0 +1,107 @@
+package mail
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/zostay/dotfiles-go/internal/fssafe"
+)
+
+func TestCompiledRule_Describe(t *testing.T) {
+	t.Parallel()
+
+	yes := true
+	cr := &CompiledRule{
+		Match: Match{
+			Folder:        "INBOX",
+			From:          "sterling@example.com",
+			HasAttachment: &yes,
+			NewerThan:     Age(36 * time.Hour),
+		},
+		Label:    []string{"Other", "Work"},
+		OkayDate: time.Now(),
+	}
+
+	assert.Equal(t,
+		"folder=INBOX from=sterling@example.com newer_than=36h0m0s has_attachment=true label=Other,Work",
+		cr.Describe(),
+	)
+
+	other := *cr
+	other.OkayDate = time.Time{}
+	assert.Equal(t, cr.Key(), other.Key(), "computed dates do not change the key")
+
+	other.Label = []string{"Other"}
+	assert.NotEqual(t, cr.Key(), other.Key())
+
+	moving := &CompiledRule{Match: Match{Folder: "INBOX"}, Move: "Work"}
+	folders := CompiledRules{moving}.FolderRules(time.Now())
+	require.Len(t, folders["Work"], 1)
+	assert.Equal(t, moving.Key(), folders["Work"][0].Key(), "derived rules count toward their origin")
+}
+
+func TestRuleStats(t *testing.T) {
+	t.Parallel()
+
+	ls := fssafe.NewTestingLoaderSaver()
+	rs := NewRuleStats(ls)
+
+	used := &CompiledRule{Match: Match{From: "a@example.com"}, Label: []string{"A"}}
+	stale := &CompiledRule{Match: Match{From: "b@example.com"}, Label: []string{"B"}}
+	unseen := &CompiledRule{Match: Match{From: "c@example.com"}, Label: []string{"C"}}
+
+	start := time.Date(2022, 8, 1, 12, 0, 0, 0, time.UTC)
+	now := time.Date(2022, 11, 22, 23, 11, 59, 0, time.UTC)
+
+	require.NoError(t, rs.Seen(CompiledRules{used, stale}, start))
+	require.NoError(t, rs.Record(stale, true, start.Add(24*time.Hour)))
+	require.NoError(t, rs.Record(used, true, now))
+	require.NoError(t, rs.Record(used, false, now))
+	require.NoError(t, rs.Save())
+
+	// reload from the saved state
+	rs = NewRuleStats(ls)
+
+	rules := CompiledRules{stale, used, unseen}
+	items, err := rs.Report(rules)
+	require.NoError(t, err)
+	require.Len(t, items, 3)
+	assert.Equal(t, used.Key(), items[0].Key)
+	assert.Equal(t, 2, items[0].Matched)
+	assert.Equal(t, 1, items[0].Acted)
+	assert.Equal(t, now, items[0].LastMatched.UTC())
+	assert.Equal(t, stale.Describe(), items[1].Rule)
+	assert.Equal(t, 0, items[2].Matched)
+
+	unused, err := rs.Unused(rules, now.Add(-30*24*time.Hour))
+	require.NoError(t, err)
+	require.Len(t, unused, 2)
+	assert.Equal(t, unseen.Key(), unused[0].Key)
+	assert.Equal(t, stale.Key(), unused[1].Key)
+}
+
+func TestFilter_ApplyRule_RecordsStats(t *testing.T) {
+	t.Parallel()
+
+	f, _ := mkTempFilter(t)
+
+	msg, err := f.Message("INBOX", "1:2,S")
+	require.NoError(t, err)
+
+	cr := &CompiledRule{Match: Match{Subject: "Foo"}, Label: []string{"Test"}}
+	_, err = f.ApplyRule(msg, cr)
+	require.NoError(t, err)
+
+	miss := &CompiledRule{Match: Match{Subject: "Bar"}, Label: []string{"Test"}}
+	_, err = f.ApplyRule(msg, miss)
+	require.NoError(t, err)
+
+	items, err := f.RuleStats().Report(CompiledRules{cr, miss})
+	require.NoError(t, err)
+	assert.Equal(t, 1, items[0].Matched)
+	assert.Equal(t, 1, items[0].Acted)
+	assert.Equal(t, 0, items[1].Matched)
+}
@@ -0,0 +1,290 @@
+package mail
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+
+	"gopkg.in/yaml.v3"
+)
+
+// SearchQuery is an ad hoc match to run against the messages in the mail root.
+type SearchQuery struct {
+	// Match holds the tests to apply, which work the same as in rules.
+	Match
+
+	// Words is matched anywhere in the message without regard to case, in
+	// addition to the tests of Match.
+	Words string
+
+	// Keywords lists keywords every matching message must carry.
+	Keywords []string
+}
+
+// matchFieldNames returns the YAML names of the fields of Match, each mapped
+// to true if the field takes a list of values.
+func matchFieldNames() map[string]bool {
+	t := reflect.TypeOf(Match{})
+	names := make(map[string]bool, t.NumField())
+	for i := 0; i < t.NumField(); i++ {
+		name, _, _ := strings.Cut(t.Field(i).Tag.Get("yaml"), ",")
+		if name != "" && name != "-" {
+			names[name] = t.Field(i).Type.Kind() == reflect.Slice
+		}
+	}
+	return names
+}
+
+// splitSearchTerms splits a search expression on whitespace, keeping text in
+// double quotes together and removing the quotes.
+func splitSearchTerms(expr string) ([]string, error) {
+	var (
+		terms   []string
+		term    strings.Builder
+		inQuote bool
+		hasTerm bool
+	)
+
+	for _, c := range expr {
+		switch {
+		case c == '"':
+			inQuote = !inQuote
+			hasTerm = true
+		case unicode.IsSpace(c) && !inQuote:
+			if hasTerm {
+				terms = append(terms, term.String())
+				term.Reset()
+				hasTerm = false
+			}
+		default:
+			term.WriteRune(c)
+			hasTerm = true
+		}
+	}
+
+	if inQuote {
+		return nil, errors.New("search expression has an unclosed quote")
+	}
+
+	if hasTerm {
+		terms = append(terms, term.String())
+	}
+
+	return terms, nil
+}
+
+// ParseSearch parses a search expression made up of terms like
+// "from:someone@example.com" or `subject_contains:"lunch plans"`. Each term
+// names a match field as it is named in the rules file, followed by a colon
+// and the value to match. Only a field taking a list of values may be given
+// more than once (e.g., "weekdays:sat weekdays:sun"). The "keyword" term
+// requires the message to carry the named keyword and may be repeated. Words
+// without a field name are matched together anywhere in the message without
+// regard to case.
+func ParseSearch(exprs ...string) (*SearchQuery, error) {
+	fields := matchFieldNames()
+
+	var (
+		q     SearchQuery
+		order []string
+		words []string
+	)
+	values := make(map[string][]string)
+
+	for _, expr := range exprs {
+		terms, err := splitSearchTerms(expr)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, term := range terms {
+			name, value, ok := strings.Cut(term, ":")
+			if !ok {
+				words = append(words, term)
+				continue
+			}
+
+			name = strings.ToLower(name)
+			if name == "keyword" {
+				q.Keywords = append(q.Keywords, value)
+				continue
+			}
+
+			list, known := fields[name]
+			if !known {
+				return nil, fmt.Errorf("unknown search field %q", name)
+			}
+
+			if _, seen := values[name]; !seen {
+				order = append(order, name)
+			} else if !list {
+				return nil, fmt.Errorf("search field %q may only be given once", name)
+			}
+			values[name] = append(values[name], value)
+		}
+	}
+
+	q.Words = strings.Join(words, " ")
+
+	node := &yaml.Node{Kind: yaml.MappingNode}
+	for _, name := range order {
+		vs := values[name]
+
+		val := &yaml.Node{Kind: yaml.ScalarNode, Value: vs[0]}
+		if len(vs) > 1 {
+			val = &yaml.Node{Kind: yaml.SequenceNode}
+			for _, v := range vs {
+				val.Content = append(val.Content, &yaml.Node{Kind: yaml.ScalarNode, Value: v})
+			}
+		}
+
+		node.Content = append(node.Content, &yaml.Node{Kind: yaml.ScalarNode, Value: name}, val)
+	}
+
+	if err := node.Decode(&q.Match); err != nil {
+		return nil, fmt.Errorf("bad search expression: %w", err)
+	}
+
+	return &q, nil
+}
+
+// compile turns the query into the rules a message must pass, expanding named
+// lists and calculating dates relative to now.
+func (q *SearchQuery) compile(lists AddressLists, now time.Time) ([]*CompiledRule, error) {
+	expanded, err := expandMatchLists(&q.Match, lists)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &CompiledRule{Match: q.Match, Expanded: expanded}
+
+	if q.Timezone != "" {
+		c.Location, err = time.LoadLocation(q.Timezone)
+		if err != nil {
+			return nil, fmt.Errorf("unknown timezone %q: %w", q.Timezone, err)
+		}
+	}
+
+	if c.Days != 0 {
+		c.OkayDate = now.Add(time.Duration(-c.Days) * time.Hour * 24)
+	}
+
+	if c.NewerThan != 0 {
+		c.NewerDate = now.Add(-time.Duration(c.NewerThan))
+	}
+
+	cs := []*CompiledRule{c}
+	if q.Words != "" {
+		cs = append(cs, &CompiledRule{Match: Match{ContainsFold: q.Words}})
+	}
+
+	return cs, nil
+}
+
+// Search returns the messages matching the query, newest first. If the query
+// names a folder, only that folder is searched. Otherwise, the given folders
+// are searched or, if none are given, every folder that is not skipped.
+func (fi *Filter) Search(q *SearchQuery, onlyFolders []string) ([]*Message, error) {
+	cs, err := q.compile(fi.lists, fi.now)
+	if err != nil {
+		return nil, err
+	}
+
+	folders := onlyFolders
+	if q.Folder != "" {
+		folders = []string{q.Folder}
+	} else if len(folders) == 0 {
+		all, err := fi.AllFolders()
+		if err != nil {
+			return nil, fmt.Errorf("unable to get a list of folders to search: %w", err)
+		}
+
+		for _, f := range all {
+			if _, skip := SkipFolder[f]; !skip {
+				folders = append(folders, f)
+			}
+		}
+	}
+
+	type dated struct {
+		msg  *Message
+		date time.Time
+	}
+
+	var found []dated
+	for _, folder := range folders {
+		msgs, err := fi.Messages(folder)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get messages from folder %s: %w", folder, err)
+		}
+
+		var msg Message
+		for msgs.Next(&msg) {
+			m := NewMessage(msg.r)
+			if fi.searchMatches(m, cs, q.Keywords) {
+				date, _ := m.Date()
+				found = append(found, dated{m, date})
+			}
+		}
+
+		if err := msgs.Err(); err != nil {
+			return nil, fmt.Errorf("failed reading messages from folder %s: %w", folder, err)
+		}
+	}
+
+	sort.SliceStable(found, func(i, j int) bool { return found[i].date.After(found[j].date) })
+
+	result := make([]*Message, len(found))
+	for i, d := range found {
+		result[i] = d.msg
+	}
+
+	return result, nil
+}
+
+// searchMatches returns true if the message passes every test of the rules and
+// carries every keyword. Unlike MatchesRule, a rule with no tests matches every
+// message, and testing stops at the first failure, so a message ruled out by
+// the full-text index need not be read. As with ApplyRule, a message that
+// cannot be tested is warned about and does not match.
+func (fi *Filter) searchMatches(m *Message, cs []*CompiledRule, keywords []string) bool {
+	warn := func(err error) {
+		cp.Fcolor(os.Stderr,
+			"warn", "❗WARNING ",
+			"meh", fmt.Sprintf(": %s. (", err),
+			"file", m.Filename(),
+			"meh", ")\n",
+		)
+	}
+
+	tests := 0
+	for _, c := range cs {
+		for _, applies := range ruleTests {
+			r, err := applies(fi, m, c, &tests)
+			if err != nil {
+				warn(err)
+				return false
+			}
+
+			if !r.pass {
+				return false
+			}
+		}
+	}
+
+	if len(keywords) > 0 {
+		ok, err := m.HasKeyword(keywords...)
+		if err != nil {
+			warn(err)
+			return false
+		}
+		return ok
+	}
+
+	return true
+}
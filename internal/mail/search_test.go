@@ -0,0 +1,108 @@
+package mail
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseSearch(t *testing.T) {
+	t.Parallel()
+
+	q, err := ParseSearch(`from:sterling@example.com subject_contains:"lunch plans"`, "keyword:Friends")
+	require.NoError(t, err)
+	assert.Equal(t, "sterling@example.com", q.From)
+	assert.Equal(t, "lunch plans", q.SubjectContains)
+	assert.Equal(t, []string{"Friends"}, q.Keywords)
+
+	q, err = ParseSearch("weekdays:sat weekdays:sun", "days:7", "folder:INBOX")
+	require.NoError(t, err)
+	assert.Equal(t, Weekdays{time.Saturday, time.Sunday}, q.Weekdays)
+	assert.Equal(t, 7, q.Days)
+	assert.Equal(t, "INBOX", q.Folder)
+
+	q, err = ParseSearch("simple", "message")
+	require.NoError(t, err)
+	assert.Equal(t, "simple message", q.Words)
+	assert.Empty(t, q.ContainsFold)
+
+	q, err = ParseSearch("lunch icontains:plans")
+	require.NoError(t, err)
+	assert.Equal(t, "lunch", q.Words)
+	assert.Equal(t, "plans", q.ContainsFold)
+
+	_, err = ParseSearch("from:a@x.com from:b@x.com")
+	assert.ErrorContains(t, err, `search field "from" may only be given once`)
+
+	_, err = ParseSearch("colour:blue")
+	assert.ErrorContains(t, err, `unknown search field "colour"`)
+
+	_, err = ParseSearch(`subject:"unfinished`)
+	assert.ErrorContains(t, err, "unclosed quote")
+}
+
+func TestFilter_Search(t *testing.T) {
+	t.Parallel()
+
+	f, root := mkTempFilter(t)
+	writeTestMessage(t, root, "INBOX", "80:2,S", listMessage)
+	writeTestMessage(t, root, "Archive", "81:2,S",
+		"From: Gopher <gopher@example.org>\r\n"+
+			"Subject: Re: Generics\r\n"+
+			"Keywords: Friends Go\r\n"+
+			"Date: Tue, 22 Nov 2022 10:00:00 +0000\r\n"+
+			"\r\n"+
+			"Thanks.\r\n")
+
+	subjects := func(q *SearchQuery, folders ...string) []string {
+		t.Helper()
+
+		msgs, err := f.Search(q, folders)
+		require.NoError(t, err)
+
+		var ss []string
+		for _, m := range msgs {
+			s, err := m.Subject()
+			require.NoError(t, err)
+			ss = append(ss, s)
+		}
+		return ss
+	}
+
+	search := func(exprs ...string) *SearchQuery {
+		t.Helper()
+
+		q, err := ParseSearch(exprs...)
+		require.NoError(t, err)
+		return q
+	}
+
+	assert.Equal(t,
+		[]string{"Re: Generics", "[go-nuts] Generics"},
+		subjects(search("from_domain:example.org")))
+	assert.Equal(t,
+		[]string{"[go-nuts] Generics"},
+		subjects(search("from_domain:example.org", "folder:INBOX")))
+	assert.Equal(t,
+		[]string{"[go-nuts] Generics"},
+		subjects(search("from_domain:example.org"), "INBOX"))
+	assert.Equal(t,
+		[]string{"Re: Generics"},
+		subjects(search("keyword:Friends")))
+	assert.Equal(t,
+		[]string{"Foo"},
+		subjects(search("simple message")))
+	assert.Equal(t,
+		[]string{"Foo"},
+		subjects(search("simple icontains:message")))
+	assert.Empty(t, subjects(search("simple icontains:thanks")))
+	assert.Empty(t, subjects(search("keyword:Friends", "keyword:Family")))
+
+	// a message that cannot be tested does not stop the search
+	writeTestMessage(t, root, "INBOX", "82:2,S", "From: gopher@example.org\r\n\r\nNo subject.\r\n")
+	assert.Equal(t,
+		[]string{"Re: Generics", "[go-nuts] Generics"},
+		subjects(search("subject_contains:Generics")))
+}
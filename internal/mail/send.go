@@ -2,73 +2,119 @@ package mail
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
 	"html"
 	"io"
+	"os"
 	"sort"
 	"strings"
+	"sync/atomic"
 	"time"
 	"unicode"
 
-	"github.com/emersion/go-sasl"
-	"github.com/emersion/go-smtp"
 	"github.com/zostay/go-addr/pkg/addr"
 	"github.com/zostay/go-email/v2/message"
+	"github.com/zostay/go-email/v2/message/header"
 	"github.com/zostay/go-email/v2/message/walk"
-
-	"github.com/zostay/dotfiles-go/internal/keeper"
 )
 
 const (
-	// FromName is the name to use when sending mail. This should be
-	// configuration.
+	// FromName is the name to use when sending mail from an SMTP account that
+	// does not configure its from address.
 	FromName = "Andrew Sterling Hanenkamp"
 
 	// ForwardedMessagePrefix is line to put at the top of a forwarded message.
 	ForwardedMessagePrefix = "---------- Forwarded message ---------"
 )
 
-var (
-	// FromEmail is the email address to use as the from email address.
-	FromEmail = keeper.MustGetSecret("GIT_EMAIL_HOME").Password()
-
-	// FromEmailAddress is the addr.Address created from FromEmail.
-	FromEmailAddress addr.AddressList
+// Forwarding modes supported by ForwardTo.
+const (
+	ForwardInline   = "inline"   // quote the message content inline
+	ForwardRedirect = "redirect" // resend the original message with Resent-* headers
+	ForwardAttach   = "attach"   // attach the original message as message/rfc822
 )
 
-func init() {
-	var err error
-	FromEmailAddress = make(addr.AddressList, 1)
-	FromEmailAddress[0], err = addr.NewMailboxStr(FromName, FromEmail, "")
+// IsForwardMode returns true if the given string names a forwarding mode.
+func IsForwardMode(mode string) bool {
+	switch mode {
+	case ForwardInline, ForwardRedirect, ForwardAttach:
+		return true
+	}
+	return false
+}
+
+// messageIDCount is used to keep message IDs unique within a single process.
+var messageIDCount uint64
+
+// NewMessageID generates a new unique Message-ID header value in the domain of
+// the given address.
+func NewMessageID(from addr.AddressList, now time.Time) string {
+	domain := "localhost"
+	if len(from) > 0 {
+		if i := strings.LastIndexByte(from[0].Address(), '@'); i >= 0 {
+			domain = from[0].Address()[i+1:]
+		}
+	}
+
+	n := atomic.AddUint64(&messageIDCount, 1)
+	return fmt.Sprintf("<%d.%d.%d@%s>", now.UnixNano(), os.Getpid(), n, domain)
+}
+
+// forwardSubject returns the subject of the message with a "Fwd: " prefix.
+func forwardSubject(h *header.Header) (string, error) {
+	subject, err := h.GetSubject()
 	if err != nil {
-		panic(err)
+		return "", err
 	}
+
+	if !strings.HasPrefix(subject, "Fwd: ") {
+		subject = "Fwd: " + subject
+	}
+
+	return subject, nil
 }
 
-// ForwardMessage builds and formats the current message as a message forwarded
-// to the given address.
-func (m *Message) ForwardMessage(to addr.AddressList, now time.Time) (io.WriterTo, error) {
-	mm, err := m.MultipartEmailMessage()
+// FromAddress returns the default from address for the given email address.
+func FromAddress(email string) (addr.AddressList, error) {
+	a, err := addr.NewMailboxStr(FromName, email, "")
 	if err != nil {
 		return nil, err
 	}
+	return addr.AddressList{a}, nil
+}
+
+// ForwardMessage builds and formats the current message as a message forwarded
+// from the given address to the given address.
+func (m *Message) ForwardMessage(from, to addr.AddressList, now time.Time) (io.WriterTo, error) {
+	r, err := m.r.Reader()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the message to forward: %w", err)
+	}
+
+	// Transfer encodings are decoded so that the forwarding prefix can be
+	// inserted before each text part and then everything is encoded again on
+	// output.
+	mm, err := message.Parse(r,
+		message.WithUnlimitedRecursion(),
+		message.WithMaxPartLength(message.DefaultMaxPartLength*1_000),
+		message.DecodeTransferEncoding())
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse the message to forward: %w", err)
+	}
 
 	fm := &message.Buffer{}
 	fm.SetDate(now)
-	_ = fm.SetTo(to)
-	_ = fm.SetFrom(FromEmailAddress)
+	fm.SetAddressList(header.To, to...)
+	fm.SetAddressList(header.From, from...)
 	fm.SetAddressList("X-Forwarded-To", to...)
-	fm.SetAddressList("X-Forwarded-For", FromEmailAddress...)
+	fm.SetAddressList("X-Forwarded-For", from...)
 
-	fwdSubject, err := mm.GetHeader().GetSubject()
+	fwdSubject, err := forwardSubject(mm.GetHeader())
 	if err != nil {
 		return nil, err
 	}
 
-	if !strings.HasPrefix(fwdSubject, "Fwd: ") {
-		fwdSubject = "Fwd: " + fwdSubject
-	}
-
 	fm.SetSubject(fwdSubject)
 
 	fwdFromList, err := mm.GetHeader().GetFrom()
@@ -120,21 +166,47 @@ func (m *Message) ForwardMessage(to addr.AddressList, now time.Time) (io.WriterT
 	// We will flatten a complex multipart message to a single level by doing this.
 	p, err := walk.AndTransform(
 		func(part message.Part, parents []message.Part, state []any) (stateInit any, err error) {
-			buf := message.NewBlankBuffer(part)
-
-			mt, _ := part.GetHeader().GetMediaType()
-			if mt == "text/plain" {
-				writeForwardMessageTextPrefix(buf)
-			} else if mt == "text/html" {
-				writeForwardMessageHtmlPrefix(buf)
+			var buf *message.Buffer
+			if len(parents) == 0 {
+				// The top-most part becomes the forwarded message itself.
+				buf = fm
+				for _, k := range []string{header.ContentType, header.ContentTransferEncoding} {
+					if v, err := part.GetHeader().Get(k); err == nil && v != "" {
+						buf.GetHeader().Set(k, v)
+					}
+				}
+
+				if part.IsMultipart() {
+					buf.SetMultipart(len(part.GetParts()))
+				} else {
+					buf.SetOpaque()
+				}
+			} else {
+				buf = message.NewBlankBuffer(part)
 			}
 
-			_, err = io.Copy(buf, part.GetReader())
-			if err != nil {
-				return nil, err
+			if !part.IsMultipart() {
+				// Without a Content-type, a part is plain text.
+				mt, err := part.GetHeader().GetMediaType()
+				if errors.Is(err, header.ErrNoSuchField) {
+					mt = "text/plain"
+				}
+
+				if mt == "text/plain" {
+					writeForwardMessageTextPrefix(buf)
+				} else if mt == "text/html" {
+					writeForwardMessageHtmlPrefix(buf)
+				}
+
+				_, err = io.Copy(buf, part.GetReader())
+				if err != nil {
+					return nil, err
+				}
 			}
 
-			state[len(state)-1].(*message.Buffer).Add(buf)
+			if len(state) > 0 {
+				state[len(state)-1].(*message.Buffer).Add(buf)
+			}
 
 			return buf, nil
 		}, mm,
@@ -147,25 +219,125 @@ func (m *Message) ForwardMessage(to addr.AddressList, now time.Time) (io.WriterT
 	return p.(*message.Buffer), nil
 }
 
-// ForwardTo performs message forwarding. It formats the message itself to prep
-// it for forwarding and contacts the SMTP to create the envelope and send it.
-func (m *Message) ForwardTo(tos addr.AddressList, now time.Time) error {
-	auth := sasl.NewPlainClient("", SASLUser, SASLPass)
+// privateHeaders lists the header fields kept on the messages in the mail
+// root for the use of this program only, which are not for anyone else to see.
+var privateHeaders = []string{header.Keywords, "X-Zostay-Forwarded", "X-Zostay-Replied"}
+
+// stripPrivateHeaders removes the private header fields from the header.
+func stripPrivateHeaders(h *header.Header) {
+	for i := len(h.ListFields()) - 1; i >= 0; i-- {
+		name := h.GetField(i).Name()
+		for _, p := range privateHeaders {
+			if strings.EqualFold(name, p) {
+				_ = h.DeleteField(i)
+				break
+			}
+		}
+	}
+}
 
+// RedirectMessage builds the current message as a message redirected from the
+// given address to the given address. The original message is left intact,
+// except that a block of Resent-* header fields is added to the top as
+// described in RFC 5322 and the private header fields are removed.
+func (m *Message) RedirectMessage(from, to addr.AddressList, now time.Time) (io.WriterTo, error) {
 	mm, err := m.OpaqueEmailMessage()
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	zfw, _ := mm.GetHeader().Get("X-Zostay-Forwarded")
+	h := mm.GetHeader()
+	stripPrivateHeaders(h)
+	h.InsertBeforeField(0, "Resent-Message-ID", NewMessageID(from, now))
+	h.InsertBeforeField(0, "Resent-To", to.String())
+	h.InsertBeforeField(0, "Resent-From", from.String())
+	h.InsertBeforeField(0, "Resent-Date", now.Format(time.RFC1123Z))
+
+	return mm, nil
+}
+
+// AttachMessage builds a new message from the given address to the given
+// address with the current message attached as a message/rfc822 part. The
+// private header fields are removed from the attached message.
+func (m *Message) AttachMessage(from, to addr.AddressList, now time.Time) (io.WriterTo, error) {
+	orig, err := m.OpaqueEmailMessage()
+	if err != nil {
+		return nil, err
+	}
+
+	stripPrivateHeaders(orig.GetHeader())
+
+	h, err := m.EmailHeader()
+	if err != nil {
+		return nil, err
+	}
+
+	fwdSubject, err := forwardSubject(h)
+	if err != nil {
+		return nil, err
+	}
+
+	fm := &message.Buffer{}
+	fm.SetDate(now)
+	fm.SetAddressList(header.To, to...)
+	fm.SetAddressList(header.From, from...)
+	fm.SetAddressList("X-Forwarded-To", to...)
+	fm.SetAddressList("X-Forwarded-For", from...)
+	fm.SetSubject(fwdSubject)
+	fm.SetMessageID(NewMessageID(from, now))
+	fm.SetMediaType("multipart/mixed")
+	fm.SetMultipart(2)
+
+	note := &message.Buffer{}
+	note.SetMediaType("text/plain")
+	_, _ = fmt.Fprintln(note, ForwardedMessagePrefix)
+	fm.Add(note)
+
+	att := &message.Buffer{}
+	att.SetMediaType("message/rfc822")
+	att.SetPresentation("attachment")
+	_ = att.SetFilename("forwarded.eml")
+	_, err = orig.WriteTo(att)
+	if err != nil {
+		return nil, err
+	}
+	fm.Add(att)
+
+	return fm, nil
+}
+
+// ForwardTo performs message forwarding. It formats the message itself to prep
+// it for forwarding using the given mode and hands it to the mailer's transport
+// to create the envelope and send it.
+//
+// Addresses listed in the X-Zostay-Forwarded header of the message are
+// skipped. The addresses the message is sent to are added to that header and
+// returned. The header is only modified in memory, so the message must be
+// saved to keep it. If the transport queued the message for later delivery,
+// the addresses are returned along with an error wrapping ErrQueued.
+func (m *Message) ForwardTo(
+	mailer *Mailer,
+	mode string,
+	tos addr.AddressList,
+	now time.Time,
+) ([]string, error) {
+	mh, err := m.EmailHeader()
+	if err != nil {
+		return nil, err
+	}
+
+	// Read the fields directly because the header caches values read by Get(),
+	// which go stale when the header is modified by Set() below.
 	zfwm := make(map[string]struct{})
 	zfws := make([]string, 0, len(tos))
-	if zfw != "" {
-		zfws = strings.FieldsFunc(zfw, func(c rune) bool {
+	for _, f := range mh.GetAllFieldsNamed("X-Zostay-Forwarded") {
+		for _, e := range strings.FieldsFunc(f.Body(), func(c rune) bool {
 			return unicode.IsSpace(c) || c == ','
-		})
-		for _, e := range zfws {
-			zfwm[e] = struct{}{}
+		}) {
+			if _, ok := zfwm[e]; !ok {
+				zfwm[e] = struct{}{}
+				zfws = append(zfws, e)
+			}
 		}
 	}
 
@@ -177,31 +349,40 @@ func (m *Message) ForwardTo(tos addr.AddressList, now time.Time) error {
 		}
 	}
 
-	fm, err := m.ForwardMessage(tos, now)
+	if len(finalTos) == 0 {
+		return nil, nil
+	}
+
+	var fm io.WriterTo
+	switch mode {
+	case ForwardInline, "":
+		fm, err = m.ForwardMessage(mailer.From, tos, now)
+	case ForwardRedirect:
+		fm, err = m.RedirectMessage(mailer.From, tos, now)
+	case ForwardAttach:
+		fm, err = m.AttachMessage(mailer.From, tos, now)
+	default:
+		err = fmt.Errorf("unknown forward mode %q", mode)
+	}
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	r := &bytes.Buffer{}
 	_, err = fm.WriteTo(r)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	err = smtp.SendMail(
-		"smtp.gmail.com:587",
-		auth,
-		FromEmail,
-		finalTos,
-		r,
-	)
-	if err != nil {
-		return err
+	// A queued message will be sent later, so it counts as forwarded.
+	sendErr := mailer.Transport.Send(mailer.EnvelopeFrom(), finalTos, r)
+	if sendErr != nil && !errors.Is(sendErr, ErrQueued) {
+		return nil, sendErr
 	}
 
 	sort.Strings(zfws)
 
-	mm.GetHeader().Set("X-Zostay-Forwarded", strings.Join(zfws, ", "))
+	mh.Set("X-Zostay-Forwarded", strings.Join(zfws, ", "))
 
-	return nil
+	return finalTos, sendErr
 }
@@ -0,0 +1,150 @@
+package mail
+
+import (
+	"bytes"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zostay/go-addr/pkg/addr"
+	"github.com/zostay/go-email/v2/message"
+)
+
+// forwardTestMessage builds a message from fm and parses it back again.
+func forwardTestMessage(t *testing.T, fm io.WriterTo) (string, message.Generic) {
+	t.Helper()
+
+	buf := &bytes.Buffer{}
+	_, err := fm.WriteTo(buf)
+	require.NoError(t, err)
+
+	raw := buf.String()
+	mm, err := message.Parse(buf, message.WithUnlimitedRecursion())
+	require.NoError(t, err)
+
+	return raw, mm
+}
+
+func forwardTestAddresses(t *testing.T) (addr.AddressList, addr.AddressList) {
+	t.Helper()
+
+	from, err := addr.ParseEmailAddressList("Me <me@example.com>")
+	require.NoError(t, err)
+
+	to, err := addr.ParseEmailAddressList("you@example.com")
+	require.NoError(t, err)
+
+	return from, to
+}
+
+func TestRedirectMessage(t *testing.T) {
+	t.Parallel()
+
+	from, to := forwardTestAddresses(t)
+	now := time.Date(2022, 11, 22, 23, 11, 59, 0, time.UTC)
+
+	m := NewFileMessage("test/maildir/INBOX/cur/1:2,S")
+	fm, err := m.RedirectMessage(from, to, now)
+	require.NoError(t, err)
+
+	raw, mm := forwardTestMessage(t, fm)
+	h := mm.GetHeader()
+
+	assert.Equal(t, "Resent-Date", h.GetField(0).Name())
+	for _, name := range []string{"Resent-From", "Resent-To", "Resent-Message-ID"} {
+		v, err := h.Get(name)
+		assert.NoError(t, err, name)
+		assert.NotEmpty(t, v, name)
+	}
+
+	subject, err := h.GetSubject()
+	require.NoError(t, err)
+	assert.Equal(t, "Foo", subject, "the original subject is kept")
+
+	orig, err := m.Raw()
+	require.NoError(t, err)
+	assert.Contains(t, raw, string(orig[bytes.Index(orig, []byte("Subject:")):]))
+}
+
+func TestAttachMessage(t *testing.T) {
+	t.Parallel()
+
+	from, to := forwardTestAddresses(t)
+	now := time.Date(2022, 11, 22, 23, 11, 59, 0, time.UTC)
+
+	m := NewFileMessage("test/maildir/INBOX/cur/1:2,S")
+	fm, err := m.AttachMessage(from, to, now)
+	require.NoError(t, err)
+
+	_, mm := forwardTestMessage(t, fm)
+
+	subject, err := mm.GetHeader().GetSubject()
+	require.NoError(t, err)
+	assert.Equal(t, "Fwd: Foo", subject)
+
+	mt, err := mm.GetHeader().GetMediaType()
+	require.NoError(t, err)
+	assert.Equal(t, "multipart/mixed", mt)
+
+	require.True(t, mm.IsMultipart())
+	parts := mm.GetParts()
+	require.Len(t, parts, 2)
+
+	mt, err = parts[1].GetHeader().GetMediaType()
+	require.NoError(t, err)
+	assert.Equal(t, "message/rfc822", mt)
+
+	bs, err := io.ReadAll(parts[1].GetReader())
+	require.NoError(t, err)
+	assert.Contains(t, string(bs), "Subject: Foo")
+	assert.Contains(t, string(bs), "Simple message")
+}
+
+func TestForwardTo_StripsPrivateHeaders(t *testing.T) {
+	t.Parallel()
+
+	from, to := forwardTestAddresses(t)
+	now := time.Date(2022, 11, 22, 23, 11, 59, 0, time.UTC)
+
+	f, root := mkTempFilter(t)
+	writeTestMessage(t, root, "INBOX", "50:2,S",
+		"From: sterling@example.com\n"+
+			"Keywords: Friends Secret\n"+
+			"X-Zostay-Forwarded: them@example.com\n"+
+			"Subject: Private\n"+
+			"\n"+
+			"Psst.\n")
+
+	m, err := f.Message("INBOX", "50:2,S")
+	require.NoError(t, err)
+
+	fm, err := m.RedirectMessage(from, to, now)
+	require.NoError(t, err)
+
+	raw, _ := forwardTestMessage(t, fm)
+	assert.NotContains(t, raw, "Keywords")
+	assert.NotContains(t, raw, "X-Zostay-Forwarded")
+	assert.Contains(t, raw, "Subject: Private")
+
+	fm, err = m.AttachMessage(from, to, now)
+	require.NoError(t, err)
+
+	raw, _ = forwardTestMessage(t, fm)
+	assert.NotContains(t, raw, "Keywords")
+	assert.NotContains(t, raw, "X-Zostay-Forwarded")
+	assert.Contains(t, raw, "Subject: Private")
+}
+
+func TestForwardToUnknownMode(t *testing.T) {
+	t.Parallel()
+
+	_, to := forwardTestAddresses(t)
+	mailer, err := SMTPAccount{From: "me@example.com", Outbox: t.TempDir()}.Mailer(NewTestingCredentials())
+	require.NoError(t, err)
+
+	m := NewFileMessage("test/maildir/INBOX/cur/1:2,S")
+	_, err = m.ForwardTo(mailer, "bounce", to, time.Now())
+	assert.Error(t, err)
+}
@@ -136,6 +136,18 @@ func (w *DirWriter) Close() error {
 	return os.Rename(w.tmp, w.r.Filename())
 }
 
+// Abort closes open file handles and removes the partially written file from
+// tmp, leaving the message file untouched. Use this instead of Close when
+// writing fails.
+func (w *DirWriter) Abort() error {
+	err := w.f.Close()
+	if rerr := os.Remove(w.tmp); rerr != nil && !os.IsNotExist(rerr) {
+		return rerr
+	}
+
+	return err
+}
+
 // Replace returns another *DirWriter for overwriting the current *DirWriter.
 func (r *DirSlurper) Replace() (*DirWriter, error) {
 	return NewMailDirWriter(r)
@@ -0,0 +1,178 @@
+package mail
+
+import (
+	"fmt"
+
+	"github.com/zostay/go-addr/pkg/addr"
+)
+
+// TLS modes for an SMTPAccount.
+const (
+	TLSStartTLS = "starttls" // connect in plain text and then upgrade with STARTTLS
+	TLSImplicit = "tls"      // connect with TLS from the start
+	TLSNone     = "none"     // never use TLS
+)
+
+// Authentication mechanisms for an SMTPAccount.
+const (
+	AuthPlain = "plain" // SASL PLAIN authentication
+	AuthLogin = "login" // SASL LOGIN authentication
+	AuthNone  = "none"  // no authentication
+)
+
+// SMTPAccount describes how to send mail as a particular identity.
+type SMTPAccount struct {
+	// Host is the SMTP server host name.
+	Host string `yaml:"host"`
+
+	// Port is the SMTP server port.
+	Port int `yaml:"port"`
+
+	// TLS is one of "starttls", "tls", or "none".
+	TLS string `yaml:"tls"`
+
+	// Auth is one of "plain", "login", or "none".
+	Auth string `yaml:"auth"`
+
+	// From is the address to send mail from, e.g., "Name <me@example.com>".
+	From string `yaml:"from"`
+
+	// Outbox, when set, names a maildir folder to write outgoing mail into
+	// instead of sending it via SMTP.
+	Outbox string `yaml:"outbox"`
+}
+
+// DefaultSMTPAccount provides the settings used for anything not set in the
+// configuration.
+var DefaultSMTPAccount = SMTPAccount{
+	Host: "smtp.gmail.com",
+	Port: 587,
+	TLS:  TLSStartTLS,
+	Auth: AuthPlain,
+}
+
+// merge returns a copy of the account with any unset settings copied from the
+// base account.
+func (a SMTPAccount) merge(base SMTPAccount) SMTPAccount {
+	if a.Host == "" {
+		a.Host = base.Host
+	}
+	if a.Port == 0 {
+		a.Port = base.Port
+	}
+	if a.TLS == "" {
+		a.TLS = base.TLS
+	}
+	if a.Auth == "" {
+		a.Auth = base.Auth
+	}
+	if a.From == "" {
+		a.From = base.From
+	}
+	if a.Outbox == "" {
+		a.Outbox = base.Outbox
+	}
+	return a
+}
+
+// SMTPConfig is the smtp section of the primary rules file. The top-level
+// settings describe the default account. Named accounts may be given under
+// accounts and selected per rule. Any setting not given on a named account is
+// taken from the default account.
+//
+//	smtp:
+//	  host: smtp.example.com
+//	  port: 465
+//	  tls: tls
+//	  from: Me <me@example.com>
+//	  accounts:
+//	    work:
+//	      from: Me <me@work.example.com>
+type SMTPConfig struct {
+	SMTPAccount `yaml:",inline"`
+
+	// Accounts lists the named accounts.
+	Accounts map[string]SMTPAccount `yaml:"accounts"`
+}
+
+// HasAccount returns true if the named account is configured. The default
+// account, named by the empty string, always exists.
+func (c *SMTPConfig) HasAccount(name string) bool {
+	if name == "" {
+		return true
+	}
+
+	if c == nil {
+		return false
+	}
+
+	_, ok := c.Accounts[name]
+	return ok
+}
+
+// Account returns the named account with all defaults filled in. The empty
+// string names the default account. It returns an error if no such account is
+// configured.
+func (c *SMTPConfig) Account(name string) (SMTPAccount, error) {
+	var base SMTPAccount
+	if c != nil {
+		base = c.SMTPAccount
+	}
+	base = base.merge(DefaultSMTPAccount)
+
+	if name == "" {
+		return base, nil
+	}
+
+	if !c.HasAccount(name) {
+		return SMTPAccount{}, fmt.Errorf("no SMTP account named %q is configured", name)
+	}
+
+	return c.Accounts[name].merge(base), nil
+}
+
+// Mailer builds the Mailer that sends mail as the named account.
+func (c *SMTPConfig) Mailer(name string, creds CredentialsProvider) (*Mailer, error) {
+	acct, err := c.Account(name)
+	if err != nil {
+		return nil, err
+	}
+
+	return acct.Mailer(creds)
+}
+
+// Mailer builds the Mailer for sending as this account. If the account does not
+// configure a from address, the from email is taken from the credentials.
+func (a SMTPAccount) Mailer(creds CredentialsProvider) (*Mailer, error) {
+	var (
+		from addr.AddressList
+		err  error
+	)
+	if a.From != "" {
+		from, err = addr.ParseEmailAddressList(a.From)
+		if err != nil {
+			return nil, fmt.Errorf("unable to parse SMTP from address %q: %w", a.From, err)
+		}
+	} else {
+		c, err := creds.Credentials()
+		if err != nil {
+			return nil, fmt.Errorf("unable to get from address from credentials: %w", err)
+		}
+
+		from, err = FromAddress(c.FromEmail)
+		if err != nil {
+			return nil, fmt.Errorf("unable to use from address %q: %w", c.FromEmail, err)
+		}
+	}
+
+	if a.Outbox != "" {
+		return &Mailer{from, NewOutboxTransport(a.Outbox)}, nil
+	}
+
+	t, err := NewSMTPTransport(a, creds)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Mailer{from, t}, nil
+}
@@ -0,0 +1,289 @@
+package mail
+
+import (
+	"errors"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/emersion/go-smtp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zostay/go-addr/pkg/addr"
+)
+
+const smtpRulesFile = `---
+smtp:
+  host: mail.example.com
+  port: 465
+  tls: tls
+  from: Home <home@example.com>
+  accounts:
+    work:
+      from: Work <work@example.com>
+      auth: login
+
+home:
+  - folder: INBOX
+    from: boss@example.com
+    forward: me@example.com
+    smtp: work
+`
+
+func TestSMTPConfigAccount(t *testing.T) {
+	t.Parallel()
+
+	var c *SMTPConfig
+	acct, err := c.Account("")
+	require.NoError(t, err)
+	assert.Equal(t, DefaultSMTPAccount, acct)
+
+	_, err = c.Account("work")
+	assert.Error(t, err)
+
+	c = &SMTPConfig{
+		SMTPAccount: SMTPAccount{Host: "mail.example.com", From: "home@example.com"},
+		Accounts: map[string]SMTPAccount{
+			"work": {Port: 2525, From: "work@example.com"},
+		},
+	}
+
+	acct, err = c.Account("")
+	require.NoError(t, err)
+	assert.Equal(t, SMTPAccount{
+		Host: "mail.example.com",
+		Port: 587,
+		TLS:  TLSStartTLS,
+		Auth: AuthPlain,
+		From: "home@example.com",
+	}, acct)
+
+	acct, err = c.Account("work")
+	require.NoError(t, err)
+	assert.Equal(t, SMTPAccount{
+		Host: "mail.example.com",
+		Port: 2525,
+		TLS:  TLSStartTLS,
+		Auth: AuthPlain,
+		From: "work@example.com",
+	}, acct)
+}
+
+func TestLoadConfigSMTP(t *testing.T) {
+	t.Parallel()
+
+	rulesFile := filepath.Join(t.TempDir(), "rules.yml")
+	require.NoError(t, os.WriteFile(rulesFile, []byte(smtpRulesFile), 0600))
+
+	c, err := LoadConfig(rulesFile, "test/local.yml")
+	require.NoError(t, err)
+
+	require.NotNil(t, c.SMTP)
+	assert.Equal(t, "mail.example.com", c.SMTP.Host)
+	assert.Equal(t, 465, c.SMTP.Port)
+	assert.Equal(t, TLSImplicit, c.SMTP.TLS)
+
+	require.NotEmpty(t, c.Rules)
+	assert.Equal(t, "work", c.Rules[0].SMTP)
+
+	acct, err := c.SMTP.Account("work")
+	require.NoError(t, err)
+	assert.Equal(t, AuthLogin, acct.Auth)
+	assert.Equal(t, 465, acct.Port)
+
+	bad := filepath.Join(t.TempDir(), "rules.yml")
+	require.NoError(t, os.WriteFile(bad, []byte(`---
+home:
+  - folder: INBOX
+    forward: me@example.com
+    smtp: nope
+`), 0600))
+
+	_, err = LoadConfig(bad, "test/local.yml")
+	assert.Error(t, err)
+}
+
+// testSMTPBackend records every message delivered to a testing SMTP server.
+type testSMTPBackend struct {
+	user, pass string
+	received   chan testSMTPDelivery
+}
+
+type testSMTPDelivery struct {
+	from string
+	to   []string
+	data []byte
+}
+
+func (be *testSMTPBackend) Login(_ *smtp.ConnectionState, user, pass string) (smtp.Session, error) {
+	if user != be.user || pass != be.pass {
+		return nil, smtp.ErrAuthRequired
+	}
+	return &testSMTPSession{be: be}, nil
+}
+
+func (be *testSMTPBackend) AnonymousLogin(_ *smtp.ConnectionState) (smtp.Session, error) {
+	return nil, smtp.ErrAuthRequired
+}
+
+// testSMTPBusyRcpt is a recipient the testing SMTP server rejects with a
+// temporary failure.
+const testSMTPBusyRcpt = "busy@example.com"
+
+type testSMTPSession struct {
+	be *testSMTPBackend
+	d  testSMTPDelivery
+}
+
+func (s *testSMTPSession) Reset()        { s.d = testSMTPDelivery{} }
+func (s *testSMTPSession) Logout() error { return nil }
+
+func (s *testSMTPSession) Mail(from string, _ smtp.MailOptions) error {
+	s.d.from = from
+	return nil
+}
+
+func (s *testSMTPSession) Rcpt(to string) error {
+	if to == testSMTPBusyRcpt {
+		return &smtp.SMTPError{Code: 452, EnhancedCode: smtp.EnhancedCode{4, 2, 2}, Message: "mailbox full"}
+	}
+	s.d.to = append(s.d.to, to)
+	return nil
+}
+
+func (s *testSMTPSession) Data(r io.Reader) error {
+	bs, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	s.d.data = bs
+	s.be.received <- s.d
+	return nil
+}
+
+// startTestSMTPServer starts a plain text SMTP server on a local port and
+// returns the account for talking to it.
+func startTestSMTPServer(t *testing.T) (SMTPAccount, chan testSMTPDelivery) {
+	t.Helper()
+
+	creds := NewTestingCredentials().Creds
+	be := &testSMTPBackend{creds.Username, creds.Password, make(chan testSMTPDelivery, 10)}
+	s := smtp.NewServer(be)
+	s.Domain = "localhost"
+	s.AllowInsecureAuth = true
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	go func() { _ = s.Serve(l) }()
+	t.Cleanup(func() { _ = s.Close() })
+
+	host, port, err := net.SplitHostPort(l.Addr().String())
+	require.NoError(t, err)
+	p, err := strconv.Atoi(port)
+	require.NoError(t, err)
+
+	return SMTPAccount{
+		Host: host,
+		Port: p,
+		TLS:  TLSNone,
+		Auth: AuthPlain,
+		From: "Forwarder <forwarder@example.com>",
+	}, be.received
+}
+
+func TestSMTPTransport(t *testing.T) {
+	t.Parallel()
+
+	acct, received := startTestSMTPServer(t)
+	creds := NewTestingCredentials()
+	mailer, err := acct.Mailer(creds)
+	require.NoError(t, err)
+	assert.Equal(t, 0, creds.Calls, "credentials are not needed until sending")
+
+	m := NewFileMessage("test/maildir/INBOX/cur/1:2,S")
+	to, err := addr.ParseEmailAddressList("someone@example.com")
+	require.NoError(t, err)
+
+	_, err = m.ForwardTo(mailer, ForwardInline, to, time.Date(2022, 11, 22, 23, 11, 59, 0, time.UTC))
+	require.NoError(t, err)
+
+	select {
+	case d := <-received:
+		assert.Equal(t, "forwarder@example.com", d.from)
+		assert.Equal(t, []string{"someone@example.com"}, d.to)
+		assert.Contains(t, string(d.data), "From: Forwarder <forwarder@example.com>")
+		assert.Contains(t, string(d.data), ForwardedMessagePrefix)
+		assert.Equal(t, 1, creds.Calls)
+	case <-time.After(5 * time.Second):
+		t.Fatal("no message was delivered")
+	}
+}
+
+func TestNewSMTPTransport(t *testing.T) {
+	t.Parallel()
+
+	creds := NewTestingCredentials()
+	_, err := NewSMTPTransport(SMTPAccount{Host: "x", Port: 25, TLS: "ssl", Auth: AuthNone}, creds)
+	assert.Error(t, err)
+
+	_, err = NewSMTPTransport(SMTPAccount{Host: "x", Port: 25, TLS: TLSNone, Auth: "cram-md5"}, creds)
+	assert.Error(t, err)
+
+	tr, err := NewSMTPTransport(SMTPAccount{Host: "x", Port: 25, TLS: TLSNone, Auth: AuthNone}, creds)
+	require.NoError(t, err)
+	assert.Equal(t, "x:25", tr.Addr())
+}
+
+func TestSMTPAccountMailerFromCredentials(t *testing.T) {
+	t.Parallel()
+
+	creds := NewTestingCredentials()
+	mailer, err := SMTPAccount{Outbox: t.TempDir()}.Mailer(creds)
+	require.NoError(t, err)
+
+	assert.Equal(t, 1, creds.Calls)
+	assert.Equal(t, "test@example.com", mailer.EnvelopeFrom())
+	assert.Equal(t, FromName, mailer.From[0].DisplayName())
+}
+
+func TestSMTPTransport_TemporaryRecipientFailure(t *testing.T) {
+	t.Parallel()
+
+	acct, received := startTestSMTPServer(t)
+	tr, err := NewSMTPTransport(acct, NewTestingCredentials())
+	require.NoError(t, err)
+
+	now := time.Date(2022, 11, 22, 23, 11, 59, 0, time.UTC)
+	q := NewQueue(filepath.Join(t.TempDir(), "queue"))
+	qt := &QueueingTransport{q, "work", tr, func() time.Time { return now }}
+
+	err = qt.Send("me@example.com", []string{"someone@example.com", testSMTPBusyRcpt}, strings.NewReader(queueTestMessage))
+	var qerr *QueuedError
+	require.ErrorAs(t, err, &qerr)
+	assert.Equal(t, []string{testSMTPBusyRcpt}, qerr.To)
+
+	select {
+	case d := <-received:
+		assert.Equal(t, []string{"someone@example.com"}, d.to)
+	case <-time.After(5 * time.Second):
+		t.Fatal("no message was delivered")
+	}
+
+	// only the recipient that was rejected is queued
+	es, err := q.Entries()
+	require.NoError(t, err)
+	require.Len(t, es, 1)
+	assert.Equal(t, []string{testSMTPBusyRcpt}, es[0].To)
+
+	// a message no recipient accepts is queued whole
+	err = qt.Send("me@example.com", []string{testSMTPBusyRcpt}, strings.NewReader(queueTestMessage))
+	require.ErrorAs(t, err, &qerr)
+	var rerr *RecipientsError
+	assert.False(t, errors.As(err, &rerr))
+}
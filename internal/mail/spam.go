@@ -0,0 +1,391 @@
+package mail
+
+import (
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"io/fs"
+	"math"
+	"path"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/zostay/dotfiles-go/internal/dotfiles"
+	"github.com/zostay/dotfiles-go/internal/fssafe"
+)
+
+const (
+	// SpamModelFile is the name of the file in the home directory that holds
+	// the trained spam classifier.
+	SpamModelFile = ".label-mail.spam.gob"
+
+	// spamInterestingTokens is the number of tokens that most strongly
+	// indicate spam or ham used to score a message.
+	spamInterestingTokens = 15
+
+	// spamMinTokenLength and spamMaxTokenLength bound the length of words
+	// used as tokens.
+	spamMinTokenLength = 3
+	spamMaxTokenLength = 40
+)
+
+// DefaultSpamFolders lists the folders used as examples of spam when training
+// the classifier. Every other folder that is not skipped is used as examples of
+// ham.
+var DefaultSpamFolders = []string{"gmail.Spam"}
+
+// ErrNoSpamModel is returned when a spam score is needed, but the classifier
+// has never been trained.
+var ErrNoSpamModel = errors.New("no spam model has been trained (run label-mail train)")
+
+// SpamModel is a naive Bayesian classifier trained on the tokens found in
+// known spam and ham (i.e., not spam) messages.
+type SpamModel struct {
+	// SpamMessages is the number of spam messages trained.
+	SpamMessages int
+
+	// HamMessages is the number of ham messages trained.
+	HamMessages int
+
+	// Spam counts the number of spam messages each token was found in.
+	Spam map[string]int
+
+	// Ham counts the number of ham messages each token was found in.
+	Ham map[string]int
+}
+
+// NewSpamModel returns an untrained SpamModel.
+func NewSpamModel() *SpamModel {
+	return &SpamModel{
+		Spam: make(map[string]int),
+		Ham:  make(map[string]int),
+	}
+}
+
+// Train adds the tokens of a single message to the model as spam or ham.
+func (sm *SpamModel) Train(tokens []string, spam bool) {
+	counts := sm.Ham
+	if spam {
+		counts = sm.Spam
+		sm.SpamMessages++
+	} else {
+		sm.HamMessages++
+	}
+
+	for _, t := range uniqueTokens(tokens) {
+		counts[t]++
+	}
+}
+
+// tokenProbability returns the probability that a message containing the
+// token is spam. Tokens seen rarely are pulled toward a neutral 0.5 as
+// described by Gary Robinson.
+func (sm *SpamModel) tokenProbability(t string) float64 {
+	const (
+		strength = 1.0 // how strongly to weigh the neutral guess
+		neutral  = 0.5 // the guess for a token never seen
+	)
+
+	var spamFreq, hamFreq float64
+	if sm.SpamMessages > 0 {
+		spamFreq = float64(sm.Spam[t]) / float64(sm.SpamMessages)
+	}
+	if sm.HamMessages > 0 {
+		hamFreq = float64(sm.Ham[t]) / float64(sm.HamMessages)
+	}
+
+	n := float64(sm.Spam[t] + sm.Ham[t])
+	if n == 0 {
+		return neutral
+	}
+
+	p := spamFreq / (spamFreq + hamFreq)
+	return (strength*neutral + n*p) / (strength + n)
+}
+
+// Score returns the probability, from 0 to 1, that a message with the given
+// tokens is spam. Only the tokens that most strongly indicate spam or ham are
+// considered.
+func (sm *SpamModel) Score(tokens []string) float64 {
+	ps := make([]float64, 0, len(tokens))
+	for _, t := range uniqueTokens(tokens) {
+		ps = append(ps, sm.tokenProbability(t))
+	}
+
+	sort.Slice(ps, func(i, j int) bool {
+		return math.Abs(ps[i]-0.5) > math.Abs(ps[j]-0.5)
+	})
+
+	if len(ps) > spamInterestingTokens {
+		ps = ps[:spamInterestingTokens]
+	}
+
+	// combine in log space to avoid underflow
+	var logSpam, logHam float64
+	for _, p := range ps {
+		p = math.Min(math.Max(p, 0.01), 0.99)
+		logSpam += math.Log(p)
+		logHam += math.Log(1 - p)
+	}
+
+	return 1 / (1 + math.Exp(logHam-logSpam))
+}
+
+// uniqueTokens returns the tokens with duplicates removed.
+func uniqueTokens(tokens []string) []string {
+	seen := make(map[string]struct{}, len(tokens))
+	us := make([]string, 0, len(tokens))
+	for _, t := range tokens {
+		if _, ok := seen[t]; !ok {
+			seen[t] = struct{}{}
+			us = append(us, t)
+		}
+	}
+	return us
+}
+
+// Tokenize splits text into lowercase word tokens for the spam classifier.
+// Very short and very long words are ignored.
+func Tokenize(text string) []string {
+	words := strings.FieldsFunc(text, func(c rune) bool {
+		return !unicode.IsLetter(c) && !unicode.IsDigit(c) && c != '\'' && c != '$'
+	})
+
+	tokens := make([]string, 0, len(words))
+	for _, w := range words {
+		w = strings.Trim(w, "'")
+		if n := len([]rune(w)); n < spamMinTokenLength || n > spamMaxTokenLength {
+			continue
+		}
+		tokens = append(tokens, strings.ToLower(w))
+	}
+
+	return tokens
+}
+
+// SpamTokens returns the tokens used to classify the message, which are drawn
+// from the subject, the sender's domain, and the text of the body.
+func (m *Message) SpamTokens() ([]string, error) {
+	var tokens []string
+
+	subject, _ := m.Subject()
+	for _, t := range Tokenize(subject) {
+		tokens = append(tokens, "subject:"+t)
+	}
+
+	if from, err := m.AddressList("From"); err == nil {
+		for _, a := range from {
+			if i := strings.LastIndexByte(a.Address(), '@'); i >= 0 {
+				tokens = append(tokens, "from:"+strings.ToLower(a.Address()[i+1:]))
+			}
+		}
+	}
+
+	body, err := m.BodyText(true)
+	if err != nil {
+		return tokens, err
+	}
+
+	return append(tokens, Tokenize(body)...), nil
+}
+
+// DefaultSpamModelPath returns the default location of the spam model.
+func DefaultSpamModelPath() string {
+	return path.Join(dotfiles.HomeDir, SpamModelFile)
+}
+
+// LoadSpamModel reads a SpamModel. It returns ErrNoSpamModel if the model has
+// never been saved.
+func LoadSpamModel(ls fssafe.LoaderSaver) (*SpamModel, error) {
+	r, err := ls.Loader()
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, ErrNoSpamModel
+	} else if err != nil {
+		return nil, fmt.Errorf("unable to open spam model: %w", err)
+	}
+
+	defer r.Close()
+
+	sm := NewSpamModel()
+	err = gob.NewDecoder(r).Decode(sm)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read spam model: %w", err)
+	}
+
+	return sm, nil
+}
+
+// Save writes the SpamModel.
+func (sm *SpamModel) Save(ls fssafe.LoaderSaver) error {
+	w, err := ls.Saver()
+	if err != nil {
+		return fmt.Errorf("unable to save spam model: %w", err)
+	}
+
+	err = gob.NewEncoder(w).Encode(sm)
+	if err != nil {
+		_ = w.Close()
+		return fmt.Errorf("unable to write spam model: %w", err)
+	}
+
+	return w.Close()
+}
+
+// SpamTrainReport describes the outcome of Filter.TrainSpam.
+type SpamTrainReport struct {
+	// SpamMessages is the number of spam messages trained.
+	SpamMessages int
+
+	// HamMessages is the number of ham messages trained.
+	HamMessages int
+
+	// Tokens is the number of distinct tokens known to the model.
+	Tokens int
+
+	// Skipped is the number of messages that could not be read.
+	Skipped int
+}
+
+// String summarizes the report.
+func (r *SpamTrainReport) String() string {
+	s := fmt.Sprintf("Trained on %d spam and %d ham messages with %d distinct tokens.\n",
+		r.SpamMessages, r.HamMessages, r.Tokens)
+	if r.Skipped > 0 {
+		s += fmt.Sprintf("Skipped %d messages that could not be read.\n", r.Skipped)
+	}
+	return s
+}
+
+// SetSpamModelLoaderSaver changes where the spam model is kept. The default is
+// the file named by DefaultSpamModelPath.
+func (fi *Filter) SetSpamModelLoaderSaver(ls fssafe.LoaderSaver) {
+	fi.spamStore = ls
+	fi.spam = nil
+}
+
+// spamModelStore returns the LoaderSaver for the spam model.
+func (fi *Filter) spamModelStore() fssafe.LoaderSaver {
+	if fi.spamStore == nil {
+		fi.spamStore = fssafe.NewFileSystemLoaderSaver(DefaultSpamModelPath())
+	}
+	return fi.spamStore
+}
+
+// SpamModel returns the trained spam model, loading it on first use.
+func (fi *Filter) SpamModel() (*SpamModel, error) {
+	if fi.spam != nil {
+		return fi.spam, nil
+	}
+
+	sm, err := LoadSpamModel(fi.spamModelStore())
+	if err != nil {
+		return nil, err
+	}
+
+	fi.spam = sm
+	return sm, nil
+}
+
+// TrainSpam builds a new spam model from the messages in the mail root. The
+// messages in the given spam folders (or DefaultSpamFolders, if none are
+// given) are spam and messages in every other folder that is not skipped are
+// ham. Copies of a ham message in several folders, as identified by
+// Message.DuplicateKey, are only trained once. Messages that cannot be read are
+// skipped. The model is saved unless this is a dry run.
+func (fi *Filter) TrainSpam(spamFolders []string) (*SpamTrainReport, error) {
+	if len(spamFolders) == 0 {
+		spamFolders = DefaultSpamFolders
+	}
+
+	isSpam := make(map[string]struct{}, len(spamFolders))
+	for _, f := range spamFolders {
+		isSpam[f] = struct{}{}
+	}
+
+	folders, err := fi.AllFolders()
+	if err != nil {
+		return nil, fmt.Errorf("unable to get a list of folders for spam training: %w", err)
+	}
+
+	sm := NewSpamModel()
+	skipped := 0
+	seenHam := make(map[string]struct{})
+	for _, folder := range folders {
+		_, spam := isSpam[folder]
+		if _, skip := SkipFolder[folder]; skip && !spam {
+			continue
+		}
+
+		msgs, err := fi.folder(folder).Messages()
+		if err != nil {
+			return nil, fmt.Errorf("failed to get messages from folder %s: %w", folder, err)
+		}
+
+		var msg Message
+		for msgs.Next(&msg) {
+			m := NewMessage(msg.r)
+			if !spam {
+				key, err := m.DuplicateKey()
+				if err != nil {
+					skipped++
+					continue
+				}
+
+				if _, seen := seenHam[key]; seen {
+					continue
+				}
+				seenHam[key] = struct{}{}
+			}
+
+			tokens, err := m.SpamTokens()
+			if err != nil {
+				skipped++
+				continue
+			}
+
+			sm.Train(tokens, spam)
+		}
+
+		if err := msgs.Err(); err != nil {
+			return nil, fmt.Errorf("failed reading messages from folder %s: %w", folder, err)
+		}
+	}
+
+	tokens := make(map[string]struct{}, len(sm.Spam)+len(sm.Ham))
+	for t := range sm.Spam {
+		tokens[t] = struct{}{}
+	}
+	for t := range sm.Ham {
+		tokens[t] = struct{}{}
+	}
+
+	report := &SpamTrainReport{sm.SpamMessages, sm.HamMessages, len(tokens), skipped}
+
+	if !fi.dryRun {
+		err = sm.Save(fi.spamModelStore())
+		if err != nil {
+			return report, err
+		}
+	}
+
+	fi.spam = sm
+
+	return report, nil
+}
+
+// SpamScore returns the probability, from 0 to 1, that the message is spam
+// according to the trained spam model.
+func (fi *Filter) SpamScore(m *Message) (float64, error) {
+	sm, err := fi.SpamModel()
+	if err != nil {
+		return 0, err
+	}
+
+	tokens, err := m.SpamTokens()
+	if err != nil {
+		return 0, err
+	}
+
+	return sm.Score(tokens), nil
+}
@@ -0,0 +1,95 @@
+package mail
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/zostay/dotfiles-go/internal/fssafe"
+)
+
+func TestTokenize(t *testing.T) {
+	t.Parallel()
+
+	assert.Equal(t,
+		[]string{"win", "$1000", "now", "don't", "miss", "out"},
+		Tokenize("WIN $1000 now!!! Don't miss out, ok? a"),
+	)
+}
+
+func TestSpamModel_Score(t *testing.T) {
+	t.Parallel()
+
+	sm := NewSpamModel()
+	for i := 0; i < 10; i++ {
+		sm.Train(Tokenize("cheap pills viagra discount offer"), true)
+		sm.Train(Tokenize("meeting agenda tomorrow lunch project"), false)
+	}
+
+	assert.Greater(t, sm.Score(Tokenize("discount viagra offer")), 0.9)
+	assert.Less(t, sm.Score(Tokenize("project meeting tomorrow")), 0.1)
+	assert.InDelta(t, 0.5, sm.Score(Tokenize("something unknown")), 0.01)
+
+	// round trip through the saved model
+	ls := fssafe.NewTestingLoaderSaver()
+	_, err := LoadSpamModel(ls)
+	assert.ErrorIs(t, err, ErrNoSpamModel)
+
+	require.NoError(t, sm.Save(ls))
+	sm2, err := LoadSpamModel(ls)
+	require.NoError(t, err)
+	assert.Equal(t, sm, sm2)
+}
+
+func TestFilter_TrainSpam(t *testing.T) {
+	t.Parallel()
+
+	f, root := mkTempFilter(t)
+	f.SetSpamModelLoaderSaver(fssafe.NewTestingLoaderSaver())
+
+	const spamMsg = "From: prize@lottery.example\r\nSubject: You are a WINNER\r\n\r\nClaim your lottery prize money now, winner!\r\n"
+	for i := 0; i < 5; i++ {
+		writeTestMessage(t, root, "gmail.Spam", fmt.Sprintf("%d:2,S", 50+i), spamMsg)
+	}
+
+	// a copy of a ham message in another folder is only trained once
+	bs, err := os.ReadFile(filepath.Join(root, "INBOX", "cur", "1:2,S"))
+	require.NoError(t, err)
+	writeTestMessage(t, root, "gmail.All_Mail", "1:2,S", string(bs))
+
+	// a message that cannot be decoded is skipped
+	writeTestMessage(t, root, "INBOX", "59:2,S",
+		"Subject: Garbled\r\nContent-Transfer-Encoding: base64\r\n\r\n!!!not base64!!!\r\n")
+
+	_, err = f.SpamModel()
+	assert.ErrorIs(t, err, ErrNoSpamModel)
+
+	report, err := f.TrainSpam(nil)
+	require.NoError(t, err)
+	assert.Equal(t, 5, report.SpamMessages)
+	assert.Equal(t, 3, report.HamMessages)
+	assert.Equal(t, 1, report.Skipped)
+	assert.Contains(t, report.String(), "Skipped 1 messages")
+
+	writeTestMessage(t, root, "INBOX", "60:2,S", spamMsg)
+	spam, err := f.Message("INBOX", "60:2,S")
+	require.NoError(t, err)
+
+	ham, err := f.Message("INBOX", "1:2,S")
+	require.NoError(t, err)
+
+	f.SetDryRun(true)
+	cr := &CompiledRule{Match: Match{SpamScoreAbove: 0.9}, Label: []string{"Test"}}
+
+	actions, err := f.ApplyRule(spam, cr)
+	assert.NoError(t, err)
+	assert.NotEmpty(t, actions)
+
+	actions, err = f.ApplyRule(ham, cr)
+	assert.NoError(t, err)
+	assert.Empty(t, actions)
+}
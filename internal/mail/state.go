@@ -0,0 +1,59 @@
+package mail
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/zostay/dotfiles-go/internal/fssafe"
+)
+
+// loadState reads the YAML state file from the loader into v. A missing state
+// file leaves v untouched and is not an error.
+func loadState(ls fssafe.LoaderSaver, name string, v interface{}) error {
+	r, err := ls.Loader()
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("unable to open %s state file: %w", name, err)
+	}
+
+	defer r.Close()
+
+	bs, err := io.ReadAll(r)
+	if err != nil {
+		return fmt.Errorf("unable to read %s state file: %w", name, err)
+	}
+
+	err = yaml.Unmarshal(bs, v)
+	if err != nil {
+		return fmt.Errorf("unable to parse %s state file: %w", name, err)
+	}
+
+	return nil
+}
+
+// saveState writes v as YAML through the saver.
+func saveState(ls fssafe.LoaderSaver, name string, v interface{}) error {
+	bs, err := yaml.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("unable to format %s state file: %w", name, err)
+	}
+
+	w, err := ls.Saver()
+	if err != nil {
+		return fmt.Errorf("unable to save %s state file: %w", name, err)
+	}
+
+	_, err = io.Copy(w, bytes.NewReader(bs))
+	if err != nil {
+		_ = w.Close()
+		return fmt.Errorf("unable to write %s state file: %w", name, err)
+	}
+
+	return w.Close()
+}
@@ -0,0 +1,272 @@
+package mail
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+// StaleTempAge is how long a file may sit in the tmp directory of a folder
+// before it is reported as an anomaly. Delivery is supposed to move files out
+// of tmp almost immediately.
+const StaleTempAge = 24 * time.Hour
+
+// The kinds of anomaly reported by Filter.Stats.
+const (
+	AnomalyBadDate       = "missing or unparseable Date"
+	AnomalyBadKeywords   = "nonconforming Keywords"
+	AnomalyStaleTempFile = "stale file in tmp"
+	AnomalyUnreadable    = "unreadable message"
+)
+
+// Anomaly describes a problem found with a single file in the mail root.
+type Anomaly struct {
+	// Filename is the path to the file with the problem.
+	Filename string `json:"filename"`
+
+	// Problem is the kind of problem found.
+	Problem string `json:"problem"`
+
+	// Detail gives more information about the problem, if any.
+	Detail string `json:"detail,omitempty"`
+}
+
+// String describes the anomaly.
+func (a Anomaly) String() string {
+	if a.Detail == "" {
+		return fmt.Sprintf("%s: %s", a.Problem, a.Filename)
+	}
+	return fmt.Sprintf("%s: %s (%s)", a.Problem, a.Filename, a.Detail)
+}
+
+// FolderStats summarizes the messages of a single folder.
+type FolderStats struct {
+	// Folder is the name of the folder.
+	Folder string `json:"folder"`
+
+	// Total is the number of messages in the folder.
+	Total int `json:"total"`
+
+	// New and Cur count the messages in the new and cur directories.
+	New int `json:"new"`
+	Cur int `json:"cur"`
+
+	// Unread is the number of messages that are new or lack the seen flag.
+	Unread int `json:"unread"`
+
+	// Size is the number of bytes used by the message files.
+	Size int64 `json:"size"`
+
+	// Oldest and Newest are the earliest and latest message dates, if any.
+	Oldest *time.Time `json:"oldest,omitempty"`
+	Newest *time.Time `json:"newest,omitempty"`
+
+	// Keywords counts the messages carrying each keyword.
+	Keywords map[string]int `json:"keywords"`
+
+	// Anomalies lists the problems found in the folder.
+	Anomalies []Anomaly `json:"anomalies"`
+}
+
+// newFolderStats returns empty stats for the named folder.
+func newFolderStats(folder string) *FolderStats {
+	return &FolderStats{
+		Folder:    folder,
+		Keywords:  make(map[string]int),
+		Anomalies: []Anomaly{},
+	}
+}
+
+// addDate widens the range of message dates to include the given date.
+func (s *FolderStats) addDate(date time.Time) {
+	if s.Oldest == nil || date.Before(*s.Oldest) {
+		d := date
+		s.Oldest = &d
+	}
+
+	if s.Newest == nil || date.After(*s.Newest) {
+		d := date
+		s.Newest = &d
+	}
+}
+
+// add includes the counts of the other stats in these stats.
+func (s *FolderStats) add(o *FolderStats) {
+	s.Total += o.Total
+	s.New += o.New
+	s.Cur += o.Cur
+	s.Unread += o.Unread
+	s.Size += o.Size
+
+	if o.Oldest != nil {
+		s.addDate(*o.Oldest)
+	}
+	if o.Newest != nil {
+		s.addDate(*o.Newest)
+	}
+
+	for k, n := range o.Keywords {
+		s.Keywords[k] += n
+	}
+
+	s.Anomalies = append(s.Anomalies, o.Anomalies...)
+}
+
+// KeywordCount is the number of messages carrying a keyword.
+type KeywordCount struct {
+	Keyword string
+	Count   int
+}
+
+// KeywordCounts returns the keywords, most common first.
+func (s *FolderStats) KeywordCounts() []KeywordCount {
+	kcs := make([]KeywordCount, 0, len(s.Keywords))
+	for k, n := range s.Keywords {
+		kcs = append(kcs, KeywordCount{k, n})
+	}
+
+	sort.Slice(kcs, func(i, j int) bool {
+		if kcs[i].Count != kcs[j].Count {
+			return kcs[i].Count > kcs[j].Count
+		}
+		return kcs[i].Keyword < kcs[j].Keyword
+	})
+
+	return kcs
+}
+
+// MailStats is the outcome of Filter.Stats.
+type MailStats struct {
+	// Folders holds the stats of each folder, sorted by name.
+	Folders []*FolderStats `json:"folders"`
+
+	// Totals sums the stats of every folder.
+	Totals *FolderStats `json:"totals"`
+}
+
+// Stats counts the messages in the given folders, or every folder if none are
+// given, and looks for problems, such as messages with a Date that cannot be
+// parsed, Keywords that do not conform, and files left behind in tmp.
+func (fi *Filter) Stats(onlyFolders []string) (*MailStats, error) {
+	folders := onlyFolders
+	if len(folders) == 0 {
+		var err error
+		folders, err = fi.AllFolders()
+		if err != nil {
+			return nil, fmt.Errorf("unable to get a list of folders for stats: %w", err)
+		}
+	}
+
+	sort.Strings(folders)
+
+	stats := &MailStats{
+		Folders: make([]*FolderStats, 0, len(folders)),
+		Totals:  newFolderStats(""),
+	}
+
+	for _, folder := range folders {
+		fs, err := fi.folderStats(folder)
+		if err != nil {
+			return nil, err
+		}
+
+		stats.Folders = append(stats.Folders, fs)
+		stats.Totals.add(fs)
+	}
+
+	return stats, nil
+}
+
+// folderStats implements Stats for a single folder.
+func (fi *Filter) folderStats(folder string) (*FolderStats, error) {
+	fs := newFolderStats(folder)
+	anomaly := func(fn, problem, detail string) {
+		fs.Anomalies = append(fs.Anomalies, Anomaly{fn, problem, detail})
+	}
+
+	f := fi.folder(folder)
+	msgs, err := f.Messages()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get messages from folder %s: %w", folder, err)
+	}
+
+	var msg Message
+	for msgs.Next(&msg) {
+		m := NewMessage(msg.r)
+		fn := m.Filename()
+
+		fs.Total++
+
+		read := false
+		if ds, ok := m.r.(*DirSlurper); ok {
+			if ds.rd == "new" {
+				fs.New++
+			} else {
+				fs.Cur++
+				read = strings.HasPrefix(ds.flags, "2,") && strings.ContainsRune(ds.flags[2:], 'S')
+			}
+		}
+
+		if !read {
+			fs.Unread++
+		}
+
+		if info, err := m.Stat(); err == nil {
+			fs.Size += info.Size()
+		} else {
+			anomaly(fn, AnomalyUnreadable, err.Error())
+			continue
+		}
+
+		if _, err := m.EmailHeader(); err != nil {
+			anomaly(fn, AnomalyUnreadable, err.Error())
+			continue
+		}
+
+		if date, err := m.Date(); err == nil {
+			fs.addDate(date)
+		} else {
+			anomaly(fn, AnomalyBadDate, err.Error())
+		}
+
+		ks, err := m.Keywords()
+		if err != nil {
+			anomaly(fn, AnomalyUnreadable, err.Error())
+			continue
+		}
+
+		for _, k := range ks {
+			fs.Keywords[k]++
+		}
+
+		if bad, _ := m.HasNonconformingKeywords(); bad {
+			anomaly(fn, AnomalyBadKeywords, strings.Join(ks, " "))
+		}
+	}
+
+	if err := msgs.Err(); err != nil {
+		return nil, fmt.Errorf("failed reading messages from folder %s: %w", folder, err)
+	}
+
+	tmp, err := os.ReadDir(f.TempDirPath())
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("unable to read tmp of folder %s: %w", folder, err)
+	}
+
+	for _, e := range tmp {
+		info, err := e.Info()
+		if err != nil || e.IsDir() || strings.HasPrefix(e.Name(), ".") {
+			continue
+		}
+
+		if age := fi.now.Sub(info.ModTime()); age > StaleTempAge {
+			anomaly(path.Join(f.TempDirPath(), e.Name()), AnomalyStaleTempFile,
+				fmt.Sprintf("%s old", age.Round(time.Hour)))
+		}
+	}
+
+	return fs, nil
+}
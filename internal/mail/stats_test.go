@@ -0,0 +1,80 @@
+package mail
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFilter_Stats(t *testing.T) {
+	t.Parallel()
+
+	f, root := mkTempFilter(t)
+
+	require.NoError(t, os.WriteFile(filepath.Join(root, "INBOX", "new", "90"), []byte(
+		"From: gopher@example.org\r\n"+
+			"Date: Mon, 21 Nov 2022 10:00:00 +0000\r\n"+
+			"Keywords: Friends Go\r\n"+
+			"\r\n"+
+			"Hello.\r\n"), 0600))
+	writeTestMessage(t, root, "INBOX", "91:2,F",
+		"From: gopher@example.org\r\n"+
+			"Date: the day after tomorrow\r\n"+
+			"Keywords: Friends Oops!\r\n"+
+			"\r\n"+
+			"Hello again.\r\n")
+
+	stale := filepath.Join(root, "INBOX", "tmp", "92")
+	require.NoError(t, os.WriteFile(stale, []byte("Subject: Never delivered\r\n\r\n"), 0600))
+	old := time.Date(2022, 11, 20, 0, 0, 0, 0, time.Local)
+	require.NoError(t, os.Chtimes(stale, old, old))
+
+	stats, err := f.Stats(nil)
+	require.NoError(t, err)
+	require.Len(t, stats.Folders, 2)
+
+	inbox := stats.Folders[0]
+	assert.Equal(t, "INBOX", inbox.Folder)
+	assert.Equal(t, 3, inbox.Total)
+	assert.Equal(t, 1, inbox.New)
+	assert.Equal(t, 2, inbox.Cur)
+	assert.Equal(t, 2, inbox.Unread)
+	assert.Equal(t, map[string]int{"Friends": 2, "Go": 1, "Oops!": 1}, inbox.Keywords)
+	assert.Equal(t, []KeywordCount{{"Friends", 2}, {"Go", 1}, {"Oops!", 1}}, inbox.KeywordCounts())
+
+	require.NotNil(t, inbox.Oldest)
+	require.NotNil(t, inbox.Newest)
+	assert.Equal(t, "2022-11-01", inbox.Oldest.Format("2006-01-02"))
+	assert.Equal(t, "2022-11-21", inbox.Newest.Format("2006-01-02"))
+
+	var problems []string
+	for _, a := range inbox.Anomalies {
+		problems = append(problems, a.Problem+" "+filepath.Base(a.Filename))
+	}
+	assert.Equal(t, []string{
+		AnomalyBadDate + " 91:2,F",
+		AnomalyBadKeywords + " 91:2,F",
+		AnomalyStaleTempFile + " 92",
+	}, problems)
+
+	other := stats.Folders[1]
+	assert.Equal(t, "Other", other.Folder)
+	assert.Equal(t, 2, other.Total)
+	assert.Equal(t, 0, other.Unread)
+	assert.Nil(t, other.Oldest)
+	assert.Len(t, other.Anomalies, 2)
+
+	assert.Equal(t, 5, stats.Totals.Total)
+	assert.Equal(t, 2, stats.Totals.Unread)
+	assert.Equal(t, inbox.Size+other.Size, stats.Totals.Size)
+	assert.Len(t, stats.Totals.Anomalies, 5)
+
+	stats, err = f.Stats([]string{"Other"})
+	require.NoError(t, err)
+	require.Len(t, stats.Folders, 1)
+	assert.Equal(t, 2, stats.Totals.Total)
+}
@@ -0,0 +1,301 @@
+package mail
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+const (
+	// DefaultSuggestMinSupport is the fewest labeled messages needed before a
+	// rule is suggested.
+	DefaultSuggestMinSupport = 5
+
+	// DefaultSuggestMinConfidence is the smallest share of the messages with a
+	// feature that must carry the label before a rule is suggested.
+	DefaultSuggestMinConfidence = 0.9
+)
+
+// suggestFields lists the features of a message that rules are suggested for,
+// from the most general to the most specific.
+var suggestFields = []string{"list_id", "from_domain", "from"}
+
+// Suggestion is a rule proposed from the labels already on messages.
+type Suggestion struct {
+	// Rule is the proposed rule.
+	Rule *RawRule
+
+	// Field is the name of the match field the rule is based on (list_id,
+	// from_domain, or from).
+	Field string
+
+	// Value is the value the rule matches.
+	Value string
+
+	// Label is the label the rule adds.
+	Label string
+
+	// Support is the number of messages with the feature that carry the
+	// label.
+	Support int
+
+	// Total is the number of messages with the feature.
+	Total int
+}
+
+// Confidence is the share of the messages with the feature that carry the
+// label.
+func (s *Suggestion) Confidence() float64 {
+	if s.Total == 0 {
+		return 0
+	}
+	return float64(s.Support) / float64(s.Total)
+}
+
+// String describes the evidence for the suggestion.
+func (s *Suggestion) String() string {
+	return fmt.Sprintf("label %s for %s %s: %d of %d messages (%.0f%%)",
+		s.Label, s.Field, s.Value, s.Support, s.Total, 100*s.Confidence())
+}
+
+// messageFeatures returns the values of each of the suggestFields for the
+// message.
+func messageFeatures(m *Message) (map[string]string, error) {
+	features := make(map[string]string, len(suggestFields))
+
+	id, err := m.ListID()
+	if err != nil {
+		return nil, err
+	}
+	if id != "" {
+		features["list_id"] = strings.ToLower(id)
+	}
+
+	from, err := m.AddressList("From")
+	if err != nil {
+		return nil, err
+	}
+	if mbs := from.Flatten(); len(mbs) > 0 {
+		features["from"] = strings.ToLower(mbs[0].Address())
+		features["from_domain"] = strings.ToLower(mbs[0].Domain())
+	}
+
+	return features, nil
+}
+
+// covers returns true if the rule already adds the label to messages with the
+// given feature.
+func (c *CompiledRule) covers(field, value, label string) bool {
+	labeled := false
+	for _, l := range c.Label {
+		if l == label {
+			labeled = true
+		}
+	}
+	if !labeled {
+		return false
+	}
+
+	matches := func(f, v, want string) bool {
+		for _, e := range c.values(f, v) {
+			if e != "" && strings.EqualFold(e, want) {
+				return true
+			}
+		}
+		return false
+	}
+
+	switch field {
+	case "list_id":
+		return strings.EqualFold(c.ListID, value)
+	case "from_domain":
+		return matches("from_domain", c.FromDomain, value)
+	case "from":
+		_, domain, _ := strings.Cut(value, "@")
+		return matches("from", c.From, value) || matches("from_domain", c.FromDomain, domain)
+	}
+
+	return false
+}
+
+// SuggestRules looks at which senders, sender domains, and mailing lists
+// consistently carry which labels across every folder that is not skipped and
+// proposes rules for those not already covered by the configured rules. Only
+// patterns with at least minSupport labeled messages where at least
+// minConfidence of the messages carry the label are proposed. Labels starting
+// with a backslash are system labels and are ignored. Copies of the same
+// message in several folders, as identified by Message.DuplicateKey, are only
+// counted once.
+func (fi *Filter) SuggestRules(minSupport int, minConfidence float64) ([]*Suggestion, error) {
+	folders, err := fi.AllFolders()
+	if err != nil {
+		return nil, fmt.Errorf("unable to get a list of folders for rule suggestions: %w", err)
+	}
+
+	// gather each message once, with the labels of all its copies
+	type sample struct {
+		features map[string]string
+		labels   map[string]struct{}
+	}
+	samples := make(map[string]*sample)
+
+	for _, folder := range folders {
+		if _, skip := SkipFolder[folder]; skip {
+			continue
+		}
+
+		msgs, err := fi.folder(folder).Messages()
+		if err != nil {
+			return nil, fmt.Errorf("failed to get messages from folder %s: %w", folder, err)
+		}
+
+		var msg Message
+		for msgs.Next(&msg) {
+			m := NewMessage(msg.r)
+			fs, err := messageFeatures(m)
+			if err != nil {
+				continue
+			}
+
+			ks, err := m.Keywords()
+			if err != nil {
+				continue
+			}
+
+			key, err := m.DuplicateKey()
+			if err != nil {
+				continue
+			}
+
+			s, ok := samples[key]
+			if !ok {
+				s = &sample{fs, make(map[string]struct{}, len(ks))}
+				samples[key] = s
+			}
+
+			for _, k := range ks {
+				if !strings.HasPrefix(k, "\\") {
+					s.labels[k] = struct{}{}
+				}
+			}
+		}
+
+		if err := msgs.Err(); err != nil {
+			return nil, fmt.Errorf("failed reading messages from folder %s: %w", folder, err)
+		}
+	}
+
+	type feature struct{ field, value string }
+	totals := make(map[feature]int)
+	labeled := make(map[feature]map[string]int)
+	for _, s := range samples {
+		for field, value := range s.features {
+			f := feature{field, value}
+			totals[f]++
+			for k := range s.labels {
+				if labeled[f] == nil {
+					labeled[f] = make(map[string]int)
+				}
+				labeled[f][k]++
+			}
+		}
+	}
+
+	var suggestions []*Suggestion
+	suggested := make(map[string]map[string]struct{}) // label -> domains
+	for _, field := range suggestFields {
+		var found []*Suggestion
+		for f, labels := range labeled {
+			if f.field != field {
+				continue
+			}
+
+			for label, support := range labels {
+				s := &Suggestion{
+					Field:   f.field,
+					Value:   f.value,
+					Label:   label,
+					Support: support,
+					Total:   totals[f],
+				}
+
+				if support < minSupport || s.Confidence() < minConfidence {
+					continue
+				}
+
+				if fi.coveredRule(f.field, f.value, label) {
+					continue
+				}
+
+				// a suggestion for the whole domain already covers the sender
+				if field == "from" {
+					_, domain, _ := strings.Cut(f.value, "@")
+					if _, ok := suggested[label][domain]; ok {
+						continue
+					}
+				}
+
+				found = append(found, s)
+			}
+		}
+
+		for _, s := range found {
+			if s.Field == "from_domain" {
+				if suggested[s.Label] == nil {
+					suggested[s.Label] = make(map[string]struct{})
+				}
+				suggested[s.Label][s.Value] = struct{}{}
+			}
+
+			r := &RawRule{Label: s.Label}
+			switch s.Field {
+			case "list_id":
+				r.ListID = s.Value
+			case "from_domain":
+				r.FromDomain = s.Value
+			case "from":
+				r.From = s.Value
+			}
+			s.Rule = r
+		}
+
+		suggestions = append(suggestions, found...)
+	}
+
+	sort.SliceStable(suggestions, func(i, j int) bool {
+		a, b := suggestions[i], suggestions[j]
+		if a.Support != b.Support {
+			return a.Support > b.Support
+		}
+		if a.Field != b.Field {
+			return fieldRank(a.Field) < fieldRank(b.Field)
+		}
+		if a.Value != b.Value {
+			return a.Value < b.Value
+		}
+		return a.Label < b.Label
+	})
+
+	return suggestions, nil
+}
+
+// fieldRank returns the position of the field in suggestFields.
+func fieldRank(field string) int {
+	for i, f := range suggestFields {
+		if f == field {
+			return i
+		}
+	}
+	return len(suggestFields)
+}
+
+// coveredRule returns true if any configured rule already adds the label to
+// messages with the feature.
+func (fi *Filter) coveredRule(field, value, label string) bool {
+	for _, c := range fi.rules {
+		if c.covers(field, value, label) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,81 @@
+package mail
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFilter_SuggestRules(t *testing.T) {
+	t.Parallel()
+
+	f, root := mkTempFilter(t)
+
+	n := 100
+	msg := func(from, keywords, extra string) string {
+		return fmt.Sprintf("Message-ID: <%d@example.com>\r\nFrom: %s\r\nSubject: Hello\r\nKeywords: %s\r\n%s\r\nHello.\r\n",
+			n+1, from, keywords, extra)
+	}
+
+	write := func(folder, m string) {
+		n++
+		writeTestMessage(t, root, folder, fmt.Sprintf("%d:2,S", n), m)
+	}
+
+	// every sender at the vendor is labeled
+	for i := 0; i < 4; i++ {
+		write("INBOX", msg("billing@vendor.example", "Vendor \\Inbox", ""))
+		write("INBOX", msg("support@vendor.example", "Vendor", ""))
+	}
+
+	// only one sender at the mixed domain is consistently labeled
+	for i := 0; i < 5; i++ {
+		write("INBOX", msg("boss@mixed.example", "Work", ""))
+		write("INBOX", msg("friend@mixed.example", "", ""))
+	}
+
+	// mailing list posts are labeled, from many senders
+	for i := 0; i < 6; i++ {
+		write("Other", msg(fmt.Sprintf("gopher%d@example.org", i), "Go", "List-Id: Go Nuts <golang-nuts.googlegroups.com>\r\n"))
+	}
+
+	// too few to suggest
+	write("INBOX", msg("rare@example.net", "Rare", ""))
+
+	// spam is ignored
+	for i := 0; i < 6; i++ {
+		write("gmail.Spam", msg("spammer@spam.example", "Junk", ""))
+	}
+
+	// copies of the boss's messages in another folder are only counted once
+	for i := 0; i < 5; i++ {
+		writeTestMessage(t, root, "gmail.All_Mail", fmt.Sprintf("%d:2,S", 200+i),
+			fmt.Sprintf("Message-ID: <%d@example.com>\r\nFrom: boss@mixed.example\r\nKeywords: Work\r\n\r\nHello.\r\n", 109+2*i))
+	}
+
+	// already covered by the rules in test/rules.yml
+	for i := 0; i < 6; i++ {
+		write("INBOX", msg("sterling@example.com", "Other", ""))
+	}
+
+	suggestions, err := f.SuggestRules(5, 0.9)
+	require.NoError(t, err)
+
+	var got []string
+	for _, s := range suggestions {
+		got = append(got, s.String())
+	}
+
+	assert.Equal(t, []string{
+		"label Vendor for from_domain vendor.example: 8 of 8 messages (100%)",
+		"label Go for list_id golang-nuts.googlegroups.com: 6 of 6 messages (100%)",
+		"label Go for from_domain example.org: 6 of 6 messages (100%)",
+		"label Work for from boss@mixed.example: 5 of 5 messages (100%)",
+	}, got)
+
+	assert.Equal(t, &RawRule{Match: Match{FromDomain: "vendor.example"}, Label: "Vendor"}, suggestions[0].Rule)
+	assert.Equal(t, &RawRule{Match: Match{ListID: "golang-nuts.googlegroups.com"}, Label: "Go"}, suggestions[1].Rule)
+	assert.Equal(t, &RawRule{Match: Match{From: "boss@mixed.example"}, Label: "Work"}, suggestions[3].Rule)
+}
@@ -0,0 +1,30 @@
+package mail
+
+// TestingCredentials is a CredentialsProvider that returns fixed credentials
+// without consulting any secret store. It counts how many times the
+// credentials have been requested.
+type TestingCredentials struct {
+	Creds Credentials
+	Calls int
+}
+
+var _ CredentialsProvider = &TestingCredentials{}
+
+// NewTestingCredentials returns a TestingCredentials with some harmless
+// credentials.
+func NewTestingCredentials() *TestingCredentials {
+	return &TestingCredentials{
+		Creds: Credentials{
+			Username:  "test@example.com",
+			Password:  "secret",
+			FromEmail: "test@example.com",
+		},
+	}
+}
+
+// Credentials returns the fixed credentials.
+func (t *TestingCredentials) Credentials() (*Credentials, error) {
+	t.Calls++
+	creds := t.Creds
+	return &creds, nil
+}
@@ -0,0 +1,387 @@
+package mail
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"regexp"
+)
+
+// ThreadMember locates a single message file belonging to a thread.
+type ThreadMember struct {
+	Folder string // the folder containing the message
+	Name   string // the file name of the message within the folder
+}
+
+// ThreadIndex tracks which messages in the mail root belong to the same
+// conversation and which labels are carried by each conversation. Messages are
+// joined into threads using the Message-ID, In-Reply-To, and References
+// headers.
+type ThreadIndex struct {
+	parent  map[string]string              // union-find parent of each message ID
+	labels  map[string]map[string]struct{} // labels carried by each thread, keyed by thread ID
+	members map[string][]ThreadMember      // messages in each thread, keyed by thread ID
+	added   map[ThreadMember]struct{}      // message files already added to the index
+}
+
+// NewThreadIndex returns an empty ThreadIndex.
+func NewThreadIndex() *ThreadIndex {
+	return &ThreadIndex{
+		parent:  make(map[string]string),
+		labels:  make(map[string]map[string]struct{}),
+		members: make(map[string][]ThreadMember),
+		added:   make(map[ThreadMember]struct{}),
+	}
+}
+
+// find returns the thread ID of the given message ID, adding the message ID to
+// the index if it is not already present.
+func (ti *ThreadIndex) find(id string) string {
+	p, ok := ti.parent[id]
+	if !ok {
+		ti.parent[id] = id
+		return id
+	}
+
+	if p == id {
+		return id
+	}
+
+	root := ti.find(p)
+	ti.parent[id] = root
+	return root
+}
+
+// union joins the threads of the two message IDs into a single thread.
+func (ti *ThreadIndex) union(a, b string) {
+	ra, rb := ti.find(a), ti.find(b)
+	if ra == rb {
+		return
+	}
+
+	ti.parent[rb] = ra
+
+	if lbs, ok := ti.labels[rb]; ok {
+		if _, ok := ti.labels[ra]; !ok {
+			ti.labels[ra] = make(map[string]struct{}, len(lbs))
+		}
+		for l := range lbs {
+			ti.labels[ra][l] = struct{}{}
+		}
+		delete(ti.labels, rb)
+	}
+
+	if ms, ok := ti.members[rb]; ok {
+		ti.members[ra] = append(ti.members[ra], ms...)
+		delete(ti.members, rb)
+	}
+}
+
+// threadMessageID returns the ID used to place the message in the index. This
+// is the Message-ID header or, if the message has none, an ID made from the
+// file name.
+func threadMessageID(m *Message) (string, error) {
+	id, err := m.MessageID()
+	if err != nil {
+		return "", err
+	}
+
+	if id == "" {
+		id = "file:" + m.Filename()
+	}
+
+	return id, nil
+}
+
+// threadMember returns the location of the message file.
+func threadMember(m *Message) ThreadMember {
+	return ThreadMember{
+		Folder: m.r.Folder(),
+		Name:   path.Base(m.Filename()),
+	}
+}
+
+// Add places the message into the index, joining it to the threads of any
+// messages it references. Adding the same message file more than once has no
+// effect.
+func (ti *ThreadIndex) Add(m *Message) error {
+	tm := threadMember(m)
+	if _, added := ti.added[tm]; added {
+		return nil
+	}
+
+	id, err := threadMessageID(m)
+	if err != nil {
+		return fmt.Errorf("unable to identify message for threading: %w", err)
+	}
+
+	refs, err := m.References()
+	if err != nil {
+		return fmt.Errorf("unable to read references for threading: %w", err)
+	}
+
+	ks, err := m.Keywords()
+	if err != nil {
+		return fmt.Errorf("unable to read keywords for threading: %w", err)
+	}
+
+	ti.find(id)
+	for _, ref := range refs {
+		ti.union(id, ref)
+	}
+
+	root := ti.find(id)
+	ti.members[root] = append(ti.members[root], tm)
+
+	ti.added[tm] = struct{}{}
+
+	ti.addLabels(root, ks...)
+
+	return nil
+}
+
+// addLabels records the labels as being carried by the given thread.
+func (ti *ThreadIndex) addLabels(root string, labels ...string) {
+	if len(labels) == 0 {
+		return
+	}
+
+	if _, ok := ti.labels[root]; !ok {
+		ti.labels[root] = make(map[string]struct{}, len(labels))
+	}
+
+	for _, l := range labels {
+		ti.labels[root][l] = struct{}{}
+	}
+}
+
+// Move updates the index after the message has been moved from the given
+// location, so the members of its thread are not left pointing at a file that
+// no longer exists.
+func (ti *ThreadIndex) Move(m *Message, from ThreadMember) error {
+	if _, added := ti.added[from]; !added {
+		return nil
+	}
+
+	delete(ti.added, from)
+
+	root, err := ti.threadOf(m)
+	if err != nil {
+		return err
+	}
+
+	ms := ti.members[root]
+	for i, tm := range ms {
+		if tm == from {
+			ti.members[root] = append(ms[:i:i], ms[i+1:]...)
+			break
+		}
+	}
+
+	return nil
+}
+
+// threadOf returns the thread ID of the given message, adding it to the index
+// first, if necessary.
+func (ti *ThreadIndex) threadOf(m *Message) (string, error) {
+	err := ti.Add(m)
+	if err != nil {
+		return "", err
+	}
+
+	id, err := threadMessageID(m)
+	if err != nil {
+		return "", err
+	}
+
+	return ti.find(id), nil
+}
+
+// HasLabel returns true if any message in the same thread as the given message
+// carries the named label.
+func (ti *ThreadIndex) HasLabel(m *Message, label string) (bool, error) {
+	root, err := ti.threadOf(m)
+	if err != nil {
+		return false, err
+	}
+
+	_, has := ti.labels[root][label]
+	return has, nil
+}
+
+// AddLabels records that the given labels have been added to the thread of the
+// given message.
+func (ti *ThreadIndex) AddLabels(m *Message, labels ...string) error {
+	root, err := ti.threadOf(m)
+	if err != nil {
+		return err
+	}
+
+	ti.addLabels(root, labels...)
+	return nil
+}
+
+// Members returns the locations of all the messages in the same thread as the
+// given message, including the message itself.
+func (ti *ThreadIndex) Members(m *Message) ([]ThreadMember, error) {
+	root, err := ti.threadOf(m)
+	if err != nil {
+		return nil, err
+	}
+
+	return ti.members[root], nil
+}
+
+// msgIDs matches the message IDs found in the References and In-Reply-To
+// headers.
+var msgIDs = regexp.MustCompile(`<[^<>\s]+>`)
+
+// References returns the message IDs found in the In-Reply-To and References
+// headers of the message.
+func (m *Message) References() ([]string, error) {
+	mh, err := m.EmailHeader()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read email message while pulling references: %w", err)
+	}
+
+	refs := make([]string, 0)
+	for _, name := range []string{"In-Reply-To", "References"} {
+		for _, f := range mh.GetAllFieldsNamed(name) {
+			refs = append(refs, msgIDs.FindAllString(f.Body(), -1)...)
+		}
+	}
+
+	return refs, nil
+}
+
+// ThreadIndex returns the thread index for the mail root, building it the
+// first time it is needed. It covers every folder except those in SkipFolder.
+func (fi *Filter) ThreadIndex() (*ThreadIndex, error) {
+	if fi.threads != nil {
+		return fi.threads, nil
+	}
+
+	folders, err := fi.AllFolders()
+	if err != nil {
+		return nil, fmt.Errorf("unable to list folders for thread index: %w", err)
+	}
+
+	ti := NewThreadIndex()
+	for _, folder := range folders {
+		if _, skip := SkipFolder[folder]; skip {
+			continue
+		}
+
+		msgs, err := fi.folder(folder).Messages()
+		if err != nil {
+			return nil, fmt.Errorf("unable to read folder %s for thread index: %w", folder, err)
+		}
+
+		var msg Message
+		for msgs.Next(&msg) {
+			// a fresh message does not keep the parsed header in memory
+			err := ti.Add(NewMessage(msg.r))
+			if err != nil {
+				cp.Fcolor(os.Stderr,
+					"warn", "❗WARNING ",
+					"meh", fmt.Sprintf(": %s. (", err),
+					"file", msg.Filename(),
+					"meh", ")\n",
+				)
+			}
+		}
+
+		if err := msgs.Err(); err != nil {
+			return nil, fmt.Errorf("failed reading folder %s for thread index: %w", folder, err)
+		}
+	}
+
+	fi.threads = ti
+	return ti, nil
+}
+
+// labelThread adds the labels to every other message in the same thread as the
+// given message and saves them. The given message itself is not modified.
+func (fi *Filter) labelThread(m *Message, labels []string) error {
+	ti, err := fi.ThreadIndex()
+	if err != nil {
+		return err
+	}
+
+	members, err := ti.Members(m)
+	if err != nil {
+		return err
+	}
+
+	for _, tm := range members {
+		other, err := fi.Message(tm.Folder, tm.Name)
+		if err != nil {
+			// the message may have been moved or removed since indexing
+			cp.Fcolor(os.Stderr,
+				"warn", "❗WARNING ",
+				"meh", fmt.Sprintf(": %s. (", err),
+				"file", path.Join(tm.Folder, tm.Name),
+				"meh", ")\n",
+			)
+			continue
+		}
+
+		if tm == threadMember(m) {
+			continue
+		}
+
+		missing, err := other.MissingKeywords(labels...)
+		if err != nil {
+			return err
+		}
+
+		if len(missing) == 0 {
+			continue
+		}
+
+		err = other.AddKeyword(missing...)
+		if err != nil {
+			return err
+		}
+
+		err = other.Save()
+		if err != nil {
+			return err
+		}
+	}
+
+	return ti.AddLabels(m, labels...)
+}
+
+// threadLabeled returns true if every message in the same thread as the given
+// message carries all the labels. A message that cannot be found is treated as
+// missing the labels.
+func (fi *Filter) threadLabeled(m *Message, labels []string) (bool, error) {
+	ti, err := fi.ThreadIndex()
+	if err != nil {
+		return false, err
+	}
+
+	members, err := ti.Members(m)
+	if err != nil {
+		return false, err
+	}
+
+	for _, tm := range members {
+		if tm == threadMember(m) {
+			continue
+		}
+
+		other, err := fi.Message(tm.Folder, tm.Name)
+		if err != nil {
+			return false, nil
+		}
+
+		ok, err := other.HasKeyword(labels...)
+		if err != nil || !ok {
+			return false, err
+		}
+	}
+
+	return true, nil
+}
@@ -0,0 +1,214 @@
+package mail
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	threadStartMessage = `Message-ID: <start@example.com>
+From: boss@example.com
+Keywords: Project
+Subject: Project
+
+Starting a thread.
+`
+
+	threadReplyMessage = `Message-ID: <reply@example.com>
+In-Reply-To: <start@example.com>
+From: peon@example.com
+Subject: Re: Project
+
+Replying to the thread.
+`
+
+	threadReplyReplyMessage = `Message-ID: <reply2@example.com>
+References: <start@example.com> <reply@example.com>
+From: boss@example.com
+Subject: Re: Project
+
+Replying again.
+`
+)
+
+func mkThreadFilter(t *testing.T) *Filter {
+	t.Helper()
+
+	f, root := mkTempFilter(t)
+	writeTestMessage(t, root, "INBOX", "20:2,S", threadStartMessage)
+	writeTestMessage(t, root, "Other", "21:2,S", threadReplyMessage)
+	writeTestMessage(t, root, "Other", "22:2,S", threadReplyReplyMessage)
+	return f
+}
+
+func TestThreadIndex(t *testing.T) {
+	t.Parallel()
+
+	f := mkThreadFilter(t)
+
+	ti, err := f.ThreadIndex()
+	require.NoError(t, err)
+
+	reply, err := f.Message("Other", "21:2,S")
+	require.NoError(t, err)
+
+	has, err := ti.HasLabel(reply, "Project")
+	require.NoError(t, err)
+	assert.True(t, has)
+
+	members, err := ti.Members(reply)
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []ThreadMember{
+		{"INBOX", "20:2,S"},
+		{"Other", "21:2,S"},
+		{"Other", "22:2,S"},
+	}, members)
+
+	unrelated, err := f.Message("Other", "2:2,S")
+	require.NoError(t, err)
+
+	has, err = ti.HasLabel(unrelated, "Project")
+	require.NoError(t, err)
+	assert.False(t, has)
+
+	members, err = ti.Members(unrelated)
+	require.NoError(t, err)
+	assert.Len(t, members, 1)
+}
+
+func TestFilter_ApplyRule_ThreadHasLabel(t *testing.T) {
+	t.Parallel()
+
+	f := mkThreadFilter(t)
+
+	cr := &CompiledRule{
+		Match: Match{ThreadHasLabel: "Project"},
+		Label: []string{"Project"},
+	}
+
+	reply, err := f.Message("Other", "21:2,S")
+	require.NoError(t, err)
+
+	actions, err := f.ApplyRule(reply, cr)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"Labeled Project"}, actions)
+
+	unrelated, err := f.Message("Other", "2:2,S")
+	require.NoError(t, err)
+
+	actions, err = f.ApplyRule(unrelated, cr)
+	require.NoError(t, err)
+	assert.Empty(t, actions)
+}
+
+func TestFilter_ApplyRule_LabelThread(t *testing.T) {
+	t.Parallel()
+
+	f := mkThreadFilter(t)
+
+	cr := &CompiledRule{
+		Match:       Match{From: "peon@example.com"},
+		LabelThread: []string{"Watched"},
+	}
+
+	reply, err := f.Message("Other", "21:2,S")
+	require.NoError(t, err)
+
+	actions, err := f.ApplyRule(reply, cr)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"Labeled thread Watched"}, actions)
+
+	for _, tm := range []ThreadMember{{"INBOX", "20:2,S"}, {"Other", "21:2,S"}, {"Other", "22:2,S"}} {
+		msg, err := f.Message(tm.Folder, tm.Name)
+		require.NoError(t, err)
+
+		has, err := msg.HasKeyword("Watched")
+		require.NoError(t, err)
+		assert.True(t, has, "%s/%s is labeled", tm.Folder, tm.Name)
+	}
+
+	unrelated, err := f.Message("Other", "2:2,S")
+	require.NoError(t, err)
+
+	has, err := unrelated.HasKeyword("Watched")
+	require.NoError(t, err)
+	assert.False(t, has)
+}
+
+func TestFilter_ApplyRule_LabelThread_LateMembers(t *testing.T) {
+	t.Parallel()
+
+	f, root := mkTempFilter(t)
+	writeTestMessage(t, root, "INBOX", "20:2,S", threadStartMessage)
+	writeTestMessage(t, root, "Other", "21:2,S", "Keywords: Watched\n"+threadReplyMessage)
+	writeTestMessage(t, root, "Other", "22:2,S", threadReplyReplyMessage)
+
+	cr := &CompiledRule{
+		Match:       Match{From: "peon@example.com"},
+		LabelThread: []string{"Watched"},
+	}
+
+	// the matching message is labeled, but the rest of the thread is not
+	reply, err := f.Message("Other", "21:2,S")
+	require.NoError(t, err)
+
+	actions, err := f.ApplyRule(reply, cr)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"Labeled thread Watched"}, actions)
+
+	for _, tm := range []ThreadMember{{"INBOX", "20:2,S"}, {"Other", "22:2,S"}} {
+		msg, err := f.Message(tm.Folder, tm.Name)
+		require.NoError(t, err)
+
+		has, err := msg.HasKeyword("Watched")
+		require.NoError(t, err)
+		assert.True(t, has, "%s/%s is labeled", tm.Folder, tm.Name)
+	}
+
+	// once the whole thread is labeled, the rule is skipped
+	reply, err = f.Message("Other", "21:2,S")
+	require.NoError(t, err)
+
+	actions, err = f.ApplyRule(reply, cr)
+	require.NoError(t, err)
+	assert.Empty(t, actions)
+}
+
+func TestFilter_ApplyRule_LabelThread_Moved(t *testing.T) {
+	t.Parallel()
+
+	f := mkThreadFilter(t)
+
+	// build the index before the start of the thread is moved
+	_, err := f.ThreadIndex()
+	require.NoError(t, err)
+
+	start, err := f.Message("INBOX", "20:2,S")
+	require.NoError(t, err)
+
+	actions, err := f.ApplyRule(start, &CompiledRule{
+		Match: Match{From: "boss@example.com"},
+		Move:  "Other",
+	})
+	require.NoError(t, err)
+	assert.NotEmpty(t, actions)
+
+	reply, err := f.Message("Other", "21:2,S")
+	require.NoError(t, err)
+
+	actions, err = f.ApplyRule(reply, &CompiledRule{
+		Match:       Match{From: "peon@example.com"},
+		LabelThread: []string{"Watched"},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"Labeled thread Watched"}, actions)
+
+	moved, err := f.Message("Other", "20:2,S")
+	require.NoError(t, err)
+
+	has, err := moved.HasKeyword("Watched")
+	require.NoError(t, err)
+	assert.True(t, has)
+}
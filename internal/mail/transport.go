@@ -0,0 +1,277 @@
+package mail
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/emersion/go-sasl"
+	"github.com/emersion/go-smtp"
+	"github.com/zostay/go-addr/pkg/addr"
+	"github.com/zostay/go-email/v2/message"
+)
+
+// Transport is the interface for anything that can deliver an outgoing email
+// message.
+type Transport interface {
+	// Send delivers the message in r from the envelope sender to the envelope
+	// recipients.
+	Send(from string, to []string, r io.Reader) error
+}
+
+// Mailer pairs a sender identity with the Transport used to deliver the mail
+// sent as that identity.
+type Mailer struct {
+	// From is the identity to send mail as.
+	From addr.AddressList
+
+	// Transport is the mechanism used to deliver mail.
+	Transport Transport
+}
+
+// EnvelopeFrom returns the address to use as the envelope sender.
+func (m *Mailer) EnvelopeFrom() string {
+	if len(m.From) == 0 {
+		return ""
+	}
+	return m.From[0].Address()
+}
+
+// SMTPTransport is a Transport that delivers mail to an SMTP server.
+type SMTPTransport struct {
+	host  string
+	port  int
+	tls   string
+	auth  string
+	creds CredentialsProvider
+}
+
+var _ Transport = &SMTPTransport{}
+
+// NewSMTPTransport returns a Transport that delivers mail to the SMTP server
+// described by the given account, authenticating with credentials from the
+// given provider. It returns an error if the account has an unknown TLS mode or
+// authentication mechanism.
+func NewSMTPTransport(acct SMTPAccount, creds CredentialsProvider) (*SMTPTransport, error) {
+	switch acct.TLS {
+	case TLSStartTLS, TLSImplicit, TLSNone:
+	default:
+		return nil, fmt.Errorf("unknown SMTP TLS mode %q", acct.TLS)
+	}
+
+	switch acct.Auth {
+	case AuthPlain, AuthLogin, AuthNone:
+	default:
+		return nil, fmt.Errorf("unknown SMTP auth mechanism %q", acct.Auth)
+	}
+
+	return &SMTPTransport{acct.Host, acct.Port, acct.TLS, acct.Auth, creds}, nil
+}
+
+// Addr returns the host:port of the SMTP server.
+func (t *SMTPTransport) Addr() string {
+	return net.JoinHostPort(t.host, strconv.Itoa(t.port))
+}
+
+// saslClient returns the SASL client to use for authentication or nil if no
+// authentication is to be performed.
+func (t *SMTPTransport) saslClient() (sasl.Client, error) {
+	if t.auth == AuthNone {
+		return nil, nil
+	}
+
+	creds, err := t.creds.Credentials()
+	if err != nil {
+		return nil, fmt.Errorf("unable to get SMTP credentials: %w", err)
+	}
+
+	switch t.auth {
+	case AuthPlain:
+		return sasl.NewPlainClient("", creds.Username, creds.Password), nil
+	case AuthLogin:
+		return sasl.NewLoginClient(creds.Username, creds.Password), nil
+	}
+	return nil, nil
+}
+
+// Send connects to the SMTP server and delivers the message.
+func (t *SMTPTransport) Send(from string, to []string, r io.Reader) error {
+	a, err := t.saslClient()
+	if err != nil {
+		return err
+	}
+
+	var c *smtp.Client
+	if t.tls == TLSImplicit {
+		c, err = smtp.DialTLS(t.Addr(), &tls.Config{ServerName: t.host})
+	} else {
+		c, err = smtp.Dial(t.Addr())
+	}
+	if err != nil {
+		return fmt.Errorf("unable to connect to SMTP server %s: %w", t.Addr(), err)
+	}
+
+	defer c.Close()
+
+	if t.tls == TLSStartTLS {
+		err = c.StartTLS(&tls.Config{ServerName: t.host})
+		if err != nil {
+			return fmt.Errorf("unable to start TLS with SMTP server %s: %w", t.Addr(), err)
+		}
+	}
+
+	if a != nil {
+		err = c.Auth(a)
+		if err != nil {
+			return fmt.Errorf("unable to authenticate with SMTP server %s: %w", t.Addr(), err)
+		}
+	}
+
+	err = c.Mail(from, nil)
+	if err != nil {
+		return fmt.Errorf("SMTP server %s rejected sender %q: %w", t.Addr(), from, err)
+	}
+
+	// a temporary rejection of a recipient need not stop delivery to the rest
+	var (
+		rejected []string
+		rcptErr  error
+	)
+	for _, rcpt := range to {
+		err = c.Rcpt(rcpt)
+		if err == nil {
+			continue
+		}
+
+		err = fmt.Errorf("SMTP server %s rejected recipient %q: %w", t.Addr(), rcpt, err)
+		if !isTemporarySendError(err) {
+			return err
+		}
+
+		rejected = append(rejected, rcpt)
+		if rcptErr == nil {
+			rcptErr = err
+		}
+	}
+
+	if len(rejected) > 0 && len(rejected) == len(to) {
+		return rcptErr
+	}
+
+	w, err := c.Data()
+	if err != nil {
+		return fmt.Errorf("SMTP server %s refused message data: %w", t.Addr(), err)
+	}
+
+	_, err = io.Copy(w, r)
+	if err != nil {
+		return fmt.Errorf("failed sending message data to SMTP server %s: %w", t.Addr(), err)
+	}
+
+	err = w.Close()
+	if err != nil {
+		return fmt.Errorf("SMTP server %s failed to accept message: %w", t.Addr(), err)
+	}
+
+	err = c.Quit()
+	if err != nil {
+		return err
+	}
+
+	if len(rejected) > 0 {
+		return &RecipientsError{rejected, rcptErr}
+	}
+
+	return nil
+}
+
+// RecipientsError is returned by SMTPTransport when the message was delivered
+// to some of the recipients, but the SMTP server temporarily rejected the
+// others.
+type RecipientsError struct {
+	// Failed lists the recipients the message was not delivered to.
+	Failed []string
+
+	// Err is the error for the first recipient rejected.
+	Err error
+}
+
+// Error describes the recipients the message was not delivered to.
+func (e *RecipientsError) Error() string {
+	return fmt.Sprintf("message not delivered to %s: %v", strings.Join(e.Failed, ", "), e.Err)
+}
+
+// Unwrap returns the error for the first recipient rejected.
+func (e *RecipientsError) Unwrap() error {
+	return e.Err
+}
+
+// failedRecipients returns the recipients the message was not delivered to
+// when sending it to the given recipients failed with the given error.
+func failedRecipients(to []string, err error) []string {
+	var rerr *RecipientsError
+	if errors.As(err, &rerr) {
+		return rerr.Failed
+	}
+	return to
+}
+
+const (
+	// EnvelopeFromHeader is the header used by OutboxTransport to record the
+	// envelope sender of an outgoing message.
+	EnvelopeFromHeader = "X-Envelope-From"
+
+	// EnvelopeToHeader is the header used by OutboxTransport to record the
+	// envelope recipients of an outgoing message.
+	EnvelopeToHeader = "X-Envelope-To"
+)
+
+// OutboxTransport is a Transport that does not deliver mail, but writes each
+// message into the new directory of a maildir folder instead. The envelope is
+// recorded at the top of the message header. This is useful for testing or for
+// handing mail off to some other process for delivery.
+type OutboxTransport struct {
+	folder *DirFolder
+}
+
+var _ Transport = &OutboxTransport{}
+
+// NewOutboxTransport returns a Transport that writes mail into the maildir
+// folder at the given path.
+func NewOutboxTransport(dir string) *OutboxTransport {
+	return &OutboxTransport{NewMailDirFolder(dir, "")}
+}
+
+// Send writes the message into the outbox folder.
+func (t *OutboxTransport) Send(from string, to []string, r io.Reader) error {
+	mm, err := message.Parse(r, message.WithoutMultipart())
+	if err != nil {
+		return fmt.Errorf("unable to parse outgoing message: %w", err)
+	}
+
+	h := mm.GetHeader()
+	h.InsertBeforeField(0, EnvelopeToHeader, strings.Join(to, ", "))
+	h.InsertBeforeField(0, EnvelopeFromHeader, from)
+
+	err = t.folder.EnsureExists()
+	if err != nil {
+		return err
+	}
+
+	w, err := NewMailDirWriter(NewMailDirSlurper(NewMailDirKey(), "", "new", t.folder))
+	if err != nil {
+		return fmt.Errorf("unable to create outbox message: %w", err)
+	}
+
+	_, err = mm.WriteTo(w)
+	if err != nil {
+		_ = w.Abort()
+		return fmt.Errorf("unable to write outbox message: %w", err)
+	}
+
+	return w.Close()
+}
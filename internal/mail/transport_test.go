@@ -0,0 +1,54 @@
+package mail
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zostay/go-addr/pkg/addr"
+)
+
+func TestOutboxTransport(t *testing.T) {
+	t.Parallel()
+
+	outbox := filepath.Join(t.TempDir(), "outbox")
+	mailer, err := SMTPAccount{From: "me@example.com", Outbox: outbox}.Mailer(NewTestingCredentials())
+	require.NoError(t, err)
+	require.IsType(t, &OutboxTransport{}, mailer.Transport)
+
+	m := NewFileMessage("test/maildir/INBOX/cur/1:2,S")
+	to, err := addr.ParseEmailAddressList("one@example.com, two@example.com")
+	require.NoError(t, err)
+
+	_, err = m.ForwardTo(mailer, ForwardInline, to, time.Date(2022, 11, 22, 23, 11, 59, 0, time.UTC))
+	require.NoError(t, err)
+
+	des, err := os.ReadDir(filepath.Join(outbox, "new"))
+	require.NoError(t, err)
+	require.Len(t, des, 1)
+
+	bs, err := os.ReadFile(filepath.Join(outbox, "new", des[0].Name()))
+	require.NoError(t, err)
+
+	msg := string(bs)
+	assert.True(t, strings.HasPrefix(msg, "X-Envelope-From: me@example.com"), msg)
+	assert.Contains(t, msg, "X-Envelope-To: one@example.com, two@example.com")
+	assert.Contains(t, msg, ForwardedMessagePrefix)
+
+	tmp, err := os.ReadDir(filepath.Join(outbox, "tmp"))
+	require.NoError(t, err)
+	assert.Empty(t, tmp)
+}
+
+func TestNewMailDirKey(t *testing.T) {
+	t.Parallel()
+
+	a, b := NewMailDirKey(), NewMailDirKey()
+	assert.NotEqual(t, a, b)
+	assert.NotContains(t, a, "/")
+	assert.NotContains(t, a, ":")
+}
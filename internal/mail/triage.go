@@ -0,0 +1,215 @@
+package mail
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// RecentMessages returns up to limit of the messages in the folder, newest
+// first by Date. A limit of zero returns every message.
+func (fi *Filter) RecentMessages(folder string, limit int) ([]*Message, error) {
+	msgs, err := fi.Messages(folder)
+	if err != nil {
+		return nil, err
+	}
+
+	type dated struct {
+		msg  *Message
+		date int64
+	}
+
+	var all []dated
+	var msg Message
+	for msgs.Next(&msg) {
+		m := NewMessage(msg.r)
+		date, _ := m.Date()
+		all = append(all, dated{m, date.Unix()})
+	}
+
+	if err := msgs.Err(); err != nil {
+		return nil, fmt.Errorf("failed reading messages from folder %s: %w", folder, err)
+	}
+
+	sort.SliceStable(all, func(i, j int) bool { return all[i].date > all[j].date })
+
+	if limit > 0 && len(all) > limit {
+		all = all[:limit]
+	}
+
+	recent := make([]*Message, len(all))
+	for i, d := range all {
+		recent[i] = d.msg
+	}
+
+	return recent, nil
+}
+
+// MatchesRule returns true if the message passes every test of the rule.
+// Unlike ApplyRule, no action is taken and nothing is recorded. As with
+// ApplyRule, a test that cannot be made is warned about and fails.
+func (fi *Filter) MatchesRule(m *Message, c *CompiledRule) bool {
+	tests := 0
+	for _, applies := range ruleTests {
+		r, err := applies(fi, m, c, &tests)
+		if err != nil {
+			cp.Fcolor(os.Stderr,
+				"warn", "❗WARNING ",
+				"meh", fmt.Sprintf(": %s. (", err),
+				"file", m.Filename(),
+				"meh", ")\n",
+			)
+			return false
+		}
+
+		if !r.pass {
+			return false
+		}
+	}
+
+	return tests > 0
+}
+
+// MatchingRules returns the rules for the message's folder that match the
+// message.
+func (fi *Filter) MatchingRules(m *Message) (CompiledRules, error) {
+	folder, err := m.Folder()
+	if err != nil {
+		return nil, err
+	}
+
+	var matched CompiledRules
+	for _, c := range fi.RulesForFolder(folder) {
+		if fi.MatchesRule(m, c) {
+			matched = append(matched, c)
+		}
+	}
+
+	return matched, nil
+}
+
+// RuleFromMessage returns a rule prefilled to match the message. The rule
+// matches the List-Id when the message came from a mailing list and the From
+// address otherwise. No actions are set.
+func RuleFromMessage(m *Message) (*RawRule, error) {
+	r := &RawRule{}
+
+	id, err := m.ListID()
+	if err != nil {
+		return nil, err
+	}
+	r.ListID = id
+
+	if id == "" {
+		from, err := m.AddressList("From")
+		if err != nil {
+			return nil, err
+		}
+
+		if len(from) > 0 {
+			r.From = from.Flatten()[0].Address()
+		}
+	}
+
+	return r, nil
+}
+
+// MarshalYAML writes only the fields of the rule that are set, so that rules
+// written by label-mail look like rules written by hand.
+func (r RawRule) MarshalYAML() (interface{}, error) {
+	node := &yaml.Node{Kind: yaml.MappingNode}
+
+	var add func(v reflect.Value) error
+	add = func(v reflect.Value) error {
+		t := v.Type()
+		for i := 0; i < t.NumField(); i++ {
+			name, opts, _ := strings.Cut(t.Field(i).Tag.Get("yaml"), ",")
+			fv := v.Field(i)
+
+			if opts == "inline" {
+				if err := add(fv); err != nil {
+					return err
+				}
+				continue
+			}
+
+			if name == "" || name == "-" || fv.IsZero() {
+				continue
+			}
+
+			var val yaml.Node
+			if err := val.Encode(fv.Interface()); err != nil {
+				return fmt.Errorf("unable to encode rule field %s: %w", name, err)
+			}
+
+			node.Content = append(node.Content,
+				&yaml.Node{Kind: yaml.ScalarNode, Value: name},
+				&val,
+			)
+		}
+		return nil
+	}
+
+	if err := add(reflect.ValueOf(r)); err != nil {
+		return nil, err
+	}
+
+	return node, nil
+}
+
+// AppendRawRule adds the rule to the end of a rules file without environment
+// sections, such as the local rules file. The file is created if it does not
+// exist. The rest of the file, including comments, is left as it is.
+func AppendRawRule(rulePath string, r *RawRule) error {
+	content, err := os.ReadFile(rulePath)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("failed to read rule file %s: %w", rulePath, err)
+	}
+
+	bs, err := yaml.Marshal([]*RawRule{r})
+	if err != nil {
+		return fmt.Errorf("failed to format rule: %w", err)
+	}
+
+	var existing RawRules
+	if err := yaml.Unmarshal(content, &existing); err != nil {
+		return fmt.Errorf("failed to parse YAML in rule file %s: %w", rulePath, err)
+	}
+
+	// a file with no rules in block style (e.g., "[]" or only comments) cannot
+	// simply be appended to, so start over keeping only the comments
+	if len(existing) == 0 {
+		var kept bytes.Buffer
+		for _, line := range strings.SplitAfter(string(content), "\n") {
+			if strings.HasPrefix(strings.TrimSpace(line), "#") || line == "---\n" {
+				kept.WriteString(line)
+			}
+		}
+		content = kept.Bytes()
+	}
+
+	if len(content) > 0 && !bytes.HasSuffix(content, []byte("\n")) {
+		content = append(content, '\n')
+	}
+	content = append(content, bs...)
+
+	var check RawRules
+	if err := yaml.Unmarshal(content, &check); err != nil || len(check) != len(existing)+1 {
+		return fmt.Errorf("unable to append a rule to %s without breaking it", rulePath)
+	}
+
+	info, err := os.Stat(rulePath)
+	mode := fs.FileMode(0644)
+	if err == nil {
+		mode = info.Mode().Perm()
+	}
+
+	return os.WriteFile(rulePath, content, mode)
+}
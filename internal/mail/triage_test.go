@@ -0,0 +1,151 @@
+package mail
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const listMessage = "From: Gopher <gopher@example.org>\r\n" +
+	"To: golang-nuts@googlegroups.com\r\n" +
+	"Subject: [go-nuts] Generics\r\n" +
+	"List-Id: Go Nuts <golang-nuts.googlegroups.com>\r\n" +
+	"Date: Mon, 21 Nov 2022 10:00:00 +0000\r\n" +
+	"\r\n" +
+	"Hello, gophers.\r\n"
+
+func TestFilter_ApplyRule_ListID(t *testing.T) {
+	t.Parallel()
+
+	f, root := mkTempFilter(t)
+	f.SetDryRun(true)
+	writeTestMessage(t, root, "INBOX", "80:2,S", listMessage)
+
+	msg, err := f.Message("INBOX", "80:2,S")
+	require.NoError(t, err)
+
+	id, err := msg.ListID()
+	require.NoError(t, err)
+	assert.Equal(t, "golang-nuts.googlegroups.com", id)
+
+	tests := []struct {
+		match  Match
+		passes bool
+	}{
+		{Match{ListID: "golang-nuts.googlegroups.com"}, true},
+		{Match{ListID: "Golang-Nuts.GoogleGroups.com"}, true},
+		{Match{ListID: "golang-dev.googlegroups.com"}, false},
+	}
+
+	for _, test := range tests {
+		cr := &CompiledRule{Match: test.match, Label: []string{"Test"}}
+
+		actions, err := f.ApplyRule(msg, cr)
+		assert.NoError(t, err)
+		assert.Equal(t, test.passes, len(actions) > 0, "%+v", test.match)
+	}
+}
+
+func TestFilter_RecentMessages(t *testing.T) {
+	t.Parallel()
+
+	f, root := mkTempFilter(t)
+	writeTestMessage(t, root, "INBOX", "80:2,S", listMessage)
+
+	msgs, err := f.RecentMessages("INBOX", 1)
+	require.NoError(t, err)
+	require.Len(t, msgs, 1)
+	assert.Equal(t, "80:2,S", filepath.Base(msgs[0].Filename()))
+
+	matched, err := f.MatchingRules(msgs[0])
+	require.NoError(t, err)
+	assert.Empty(t, matched)
+
+	msg, err := f.Message("INBOX", "1:2,S")
+	require.NoError(t, err)
+
+	matched, err = f.MatchingRules(msg)
+	require.NoError(t, err)
+	require.Len(t, matched, 1)
+	assert.Equal(t, "sterling@example.com", matched[0].From)
+
+	// a message that cannot be tested matches nothing, without error
+	writeTestMessage(t, root, "INBOX", "81:2,S", "Subject: No sender\r\n\r\nWho sent this?\r\n")
+	msg, err = f.Message("INBOX", "81:2,S")
+	require.NoError(t, err)
+
+	matched, err = f.MatchingRules(msg)
+	require.NoError(t, err)
+	assert.Empty(t, matched)
+}
+
+func TestRuleFromMessage(t *testing.T) {
+	t.Parallel()
+
+	f, root := mkTempFilter(t)
+	writeTestMessage(t, root, "INBOX", "80:2,S", listMessage)
+
+	msg, err := f.Message("INBOX", "80:2,S")
+	require.NoError(t, err)
+
+	r, err := RuleFromMessage(msg)
+	require.NoError(t, err)
+	assert.Equal(t, &RawRule{Match: Match{ListID: "golang-nuts.googlegroups.com"}}, r)
+
+	msg, err = f.Message("INBOX", "1:2,S")
+	require.NoError(t, err)
+
+	r, err = RuleFromMessage(msg)
+	require.NoError(t, err)
+	assert.Equal(t, &RawRule{Match: Match{From: "sterling@example.com"}}, r)
+}
+
+func TestAppendRawRule(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	fn := filepath.Join(dir, "local.yml")
+
+	r := &RawRule{
+		Match: Match{
+			Folder:    "INBOX",
+			ListID:    "golang-nuts.googlegroups.com",
+			NewerThan: Age(7 * 24 * time.Hour),
+			Weekdays:  Weekdays{time.Saturday, time.Sunday},
+		},
+		Label: []string{"Go"},
+	}
+
+	// a new file
+	require.NoError(t, AppendRawRule(fn, r))
+	bs, err := os.ReadFile(fn)
+	require.NoError(t, err)
+	assert.Equal(t, `- folder: INBOX
+  list_id: golang-nuts.googlegroups.com
+  newer_than: 1w
+  weekdays:
+    - sat
+    - sun
+  label:
+    - Go
+`, string(bs))
+
+	// an empty list keeps its comments
+	require.NoError(t, os.WriteFile(fn, []byte("# my rules\n[]\n"), 0600))
+	require.NoError(t, AppendRawRule(fn, &RawRule{Match: Match{From: "a@example.com"}, Move: "Spam"}))
+	bs, err = os.ReadFile(fn)
+	require.NoError(t, err)
+	assert.Equal(t, "# my rules\n- from: a@example.com\n  move: Spam\n", string(bs))
+
+	// an existing list is appended to and round trips
+	require.NoError(t, AppendRawRule(fn, r))
+	rules, err := LoadRawRules(fn)
+	require.NoError(t, err)
+	require.Len(t, rules, 2)
+	assert.Equal(t, "a@example.com", rules[0].From)
+	assert.Equal(t, r.Match, rules[1].Match)
+}
@@ -74,148 +74,332 @@ func hasUnwantedKeyword(msg *Message) ([]string, string, error) {
 	return []string{}, "", nil
 }
 
+// VacuumMove records a message moved out of an unwanted folder.
+type VacuumMove struct {
+	Filename string // the name of the message file before it was moved
+	From     string // the unwanted folder the message was in
+	To       string // the folder the message was moved to
+}
+
+// VacuumKeywordFix records the keyword repairs made to a single message.
+type VacuumKeywordFix struct {
+	Filename      string   // the name of the message file
+	Folder        string   // the folder containing the message
+	Nonconforming bool     // true when non-conforming keywords were cleaned up
+	Removed       []string // the unwanted keywords removed
+	Added         string   // the keyword added in place of those removed
+}
+
+// VacuumFailure records a problem encountered during vacuuming that did not
+// stop the vacuum from continuing on.
+type VacuumFailure struct {
+	Path string // the folder or message file with the problem
+	Err  error  // the problem encountered
+}
+
+// VacuumReport is the structured summary of everything a vacuum did (or would
+// have done, during a dry run).
+type VacuumReport struct {
+	// DryRun is true if no changes were made to the mail root.
+	DryRun bool
+
+	// DroppedFolders lists the unwanted folders emptied and removed.
+	DroppedFolders []string
+
+	// Moved lists the messages moved out of the dropped folders.
+	Moved []VacuumMove
+
+	// KeywordFixes lists the messages that had their keywords repaired.
+	KeywordFixes []VacuumKeywordFix
+
+	// Failures lists the problems encountered along the way.
+	Failures []VacuumFailure
+}
+
+// fail is a helper that records a failure in the report.
+func (r *VacuumReport) fail(path string, err error) {
+	r.Failures = append(r.Failures, VacuumFailure{path, err})
+}
+
+// String returns a summary of the vacuum report suitable for display on the
+// console.
+//
+//	fmt.Print(report)
+func (r *VacuumReport) String() string {
+	var out strings.Builder
+
+	if r.DryRun {
+		fmt.Fprintln(&out, "Dry run: no changes were made.")
+	}
+
+	if len(r.DroppedFolders) > 0 {
+		fmt.Fprintln(&out, "Dropped folders:")
+		for _, f := range r.DroppedFolders {
+			fmt.Fprintf(&out, " %s\n", f)
+		}
+	}
+
+	if len(r.Moved) > 0 {
+		fmt.Fprintln(&out, "Moved messages:")
+		for _, mv := range r.Moved {
+			fmt.Fprintf(&out, " %s => %s : %s\n", mv.From, mv.To, mv.Filename)
+		}
+	}
+
+	if len(r.KeywordFixes) > 0 {
+		fmt.Fprintln(&out, "Fixed keywords:")
+		for _, kf := range r.KeywordFixes {
+			fixes := make([]string, 0, 2)
+			if kf.Nonconforming {
+				fixes = append(fixes, "cleaned non-conforming keywords")
+			}
+			if len(kf.Removed) > 0 {
+				fixes = append(fixes, fmt.Sprintf("replaced %s with %s", strings.Join(kf.Removed, ", "), kf.Added))
+			}
+			fmt.Fprintf(&out, " %s: %s\n", kf.Filename, strings.Join(fixes, "; "))
+		}
+	}
+
+	if len(r.Failures) > 0 {
+		fmt.Fprintln(&out, "Failures:")
+		for _, f := range r.Failures {
+			fmt.Fprintf(&out, " %s: %v\n", f.Path, f.Err)
+		}
+	}
+
+	if len(r.DroppedFolders)+len(r.Moved)+len(r.KeywordFixes)+len(r.Failures) == 0 {
+		fmt.Fprintln(&out, "Nothing to vacuum.")
+		return out.String()
+	}
+
+	fmt.Fprintf(&out, "Dropped %d folders, moved %d messages, fixed keywords on %d messages, %d failures.\n",
+		len(r.DroppedFolders), len(r.Moved), len(r.KeywordFixes), len(r.Failures))
+
+	return out.String()
+}
+
 // Vacuum performs the vacuum operation which attempts to clean up undesirable
-// folder and keywords from my mail root.
-func (fi *Filter) Vacuum() error {
+// folder and keywords from my mail root. When the filter is set to dry run, no
+// changes are made, but the returned VacuumReport describes what would have
+// changed. Problems with individual folders and messages are recorded in the
+// report rather than stopping the vacuum. An error is returned only if the
+// vacuum cannot proceed at all.
+func (fi *Filter) Vacuum() (*VacuumReport, error) {
+	report := &VacuumReport{DryRun: fi.dryRun}
+
 	folders, err := fi.AllFolders()
 	if err != nil {
-		return err
+		return report, err
 	}
 
 	for _, folder := range folders {
 		if isUnwanted(folder) {
-			cp.Fcolor(os.Stderr,
-				"dropping", "🗑 DROPPING",
-				"meh", ": ",
-				"label", folder,
-				"meh", "\n",
-			)
+			fi.vacuumDropFolder(report, folder)
+		} else {
+			fi.vacuumFixKeywords(report, folder)
+		}
+	}
+
+	return report, nil
+}
 
-			msgs, err := fi.Messages(folder)
+// vacuumDropFolder moves every message in an unwanted folder to a better folder
+// and then removes the unwanted folder.
+func (fi *Filter) vacuumDropFolder(report *VacuumReport, folder string) {
+	cp.Fcolor(os.Stderr,
+		"dropping", "🗑 DROPPING",
+		"meh", ": ",
+		"label", folder,
+		"meh", "\n",
+	)
+
+	msgs, err := fi.Messages(folder)
+	if err != nil {
+		report.fail(folder, err)
+		return
+	}
+
+	moveFailed := false
+	var msg Message
+	for msgs.Next(&msg) {
+		fn := msg.Filename()
+		other, err := msg.BestAlternateFolder()
+		if err != nil {
+			report.fail(fn, err)
+			moveFailed = true
+			continue
+		}
+
+		if !fi.dryRun {
+			err = msg.MoveTo(fi.mailRoot, other)
 			if err != nil {
-				return err
+				report.fail(fn, err)
+				moveFailed = true
+				continue
 			}
+		}
 
-			var msg Message
-			for msgs.Next(&msg) {
-				other, err := msg.BestAlternateFolder()
-				if err != nil {
-					return err
-				}
+		err = msg.RemoveKeyword(other)
+		if err != nil {
+			report.fail(fn, err)
+			continue
+		}
 
-				err = msg.MoveTo(fi.mailRoot, other)
-				if err != nil {
-					return err
-				}
+		if !fi.dryRun {
+			err = msg.Save()
+			if err != nil {
+				report.fail(fn, err)
+				continue
+			}
+		}
 
-				err = msg.RemoveKeyword(other)
-				if err != nil {
-					return err
-				}
+		cp.Fcolor(os.Stderr,
+			"moving", "⇒ Moving ",
+			"label", folder,
+			"meh", " to ",
+			"label", other,
+			"meh", "\n",
+		)
 
-				err = msg.Save()
-				if err != nil {
-					return err
-				}
+		report.Moved = append(report.Moved, VacuumMove{fn, folder, other})
+	}
 
-				cp.Fcolor(os.Stderr,
-					"moving", "⇒ Moving ",
-					"label", folder,
-					"meh", " to ",
-					"label", other,
-					"meh", "\n",
-				)
-			}
+	if err := msgs.Err(); err != nil {
+		report.fail(folder, err)
+		moveFailed = true
+	}
 
-			deadFolder := path.Join(fi.mailRoot, folder)
-			for _, sd := range []string{"new", "cur", "tmp"} {
-				err = os.Remove(path.Join(deadFolder, sd))
-				if err != nil {
-					cp.Fcolor(os.Stderr,
-						"warn", "❗WARNING ",
-						"meh", ": cannot delete ",
-						"file", fmt.Sprintf("%s/%s", deadFolder, sd),
-						"meh", fmt.Sprintf(": %+v\n", err),
-					)
-				}
-			}
-			err = os.Remove(deadFolder)
+	// never delete a folder we failed to empty
+	if moveFailed {
+		return
+	}
+
+	report.DroppedFolders = append(report.DroppedFolders, folder)
+	if fi.dryRun {
+		return
+	}
+
+	deadFolder := path.Join(fi.mailRoot, folder)
+	for _, sd := range []string{"new", "cur", "tmp"} {
+		err = os.Remove(path.Join(deadFolder, sd))
+		if err != nil {
+			cp.Fcolor(os.Stderr,
+				"warn", "❗WARNING ",
+				"meh", ": cannot delete ",
+				"file", fmt.Sprintf("%s/%s", deadFolder, sd),
+				"meh", fmt.Sprintf(": %+v\n", err),
+			)
+			report.fail(path.Join(deadFolder, sd), err)
+		}
+	}
+	err = os.Remove(deadFolder)
+	if err != nil {
+		cp.Fcolor(os.Stderr,
+			"warn", "❗WARNING ",
+			"meh", ": cannot delete ",
+			"file", deadFolder,
+			"meh", fmt.Sprintf(": %+v\n", err),
+		)
+		report.fail(deadFolder, err)
+	}
+}
+
+// vacuumFixKeywords repairs broken keywords on every message in the folder.
+func (fi *Filter) vacuumFixKeywords(report *VacuumReport, folder string) {
+	cp.Fcolor(os.Stderr,
+		"searching", "🔍 Searching ",
+		"label", folder,
+		"meh", " for broken Keywords.\n",
+	)
+
+	msgs, err := fi.Messages(folder)
+	if err != nil {
+		report.fail(folder, err)
+		return
+	}
+
+	var msg Message
+	for msgs.Next(&msg) {
+		fn := msg.Filename()
+		fix := VacuumKeywordFix{Filename: fn, Folder: folder}
+
+		// Cleanup unwanted chars in keywords
+		nonconforming, err := msg.HasNonconformingKeywords()
+		if err != nil {
+			report.fail(fn, err)
+			continue
+		}
+		if nonconforming {
+			cp.Fcolor(os.Stderr,
+				"fixing", "🔧 Fixing ",
+				"meh", "non-conforming keywords.\n",
+			)
+			err := msg.CleanupKeywords()
 			if err != nil {
-				cp.Fcolor(os.Stderr,
-					"warn", "❗WARNING ",
-					"meh", ": cannot delete ",
-					"file", deadFolder,
-					"meh", fmt.Sprintf(": %+v\n", err),
-				)
+				report.fail(fn, err)
+				continue
 			}
-		} else {
+			fix.Nonconforming = true
+		}
+
+		// Something went wrong somewhere
+		unwanted, wanted, err := hasUnwantedKeyword(&msg)
+		if err != nil {
+			report.fail(fn, err)
+			continue
+		}
+		if len(unwanted) > 0 {
 			cp.Fcolor(os.Stderr,
-				"searching", "🔍 Searching ",
-				"label", folder,
-				"meh", " for broken Keywords.\n",
+				"fixing", "🔧 Fixing ",
+				"meh", "(",
+				"label", strings.Join(unwanted, ", "),
+				"meh", " to ",
+				"label", wanted,
+				"meh", ".\n",
 			)
 
-			msgs, err := fi.Messages(folder)
+			km, err := msg.KeywordsSet()
 			if err != nil {
-				return err
+				report.fail(fn, err)
+				continue
 			}
 
-			var msg Message
-			for msgs.Next(&msg) {
-				change := 0
-
-				// Cleanup unwanted chars in keywords
-				nonconforming, err := msg.HasNonconformingKeywords()
-				if err != nil {
-					return err
-				}
-				if nonconforming {
-					cp.Fcolor(os.Stderr,
-						"fixing", "🔧 Fixing ",
-						"meh", "non-conforming keywords.\n",
-					)
-					err := msg.CleanupKeywords()
-					if err != nil {
-						return err
-					}
-					change++
+			for _, uk := range unwanted {
+				if _, present := km[uk]; present {
+					fix.Removed = append(fix.Removed, uk)
 				}
+			}
+			fix.Added = wanted
 
-				// Something went wrong somewhere
-				unwanted, wanted, err := hasUnwantedKeyword(&msg)
-				if err != nil {
-					return err
-				}
-				if len(unwanted) > 0 {
-					cp.Fcolor(os.Stderr,
-						"fixing", "🔧 Fixing ",
-						"meh", "(",
-						"label", strings.Join(unwanted, ", "),
-						"meh", " to ",
-						"label", wanted,
-						"meh", ".\n",
-					)
-					change++
-					err := msg.RemoveKeyword(unwanted...)
-					if err != nil {
-						return err
-					}
-
-					err = msg.AddKeyword(wanted)
-					if err != nil {
-						return err
-					}
-				}
+			err = msg.RemoveKeyword(unwanted...)
+			if err != nil {
+				report.fail(fn, err)
+				continue
+			}
 
-				if change > 0 {
-					err := msg.Save()
-					if err != nil {
-						return err
-					}
-				}
+			err = msg.AddKeyword(wanted)
+			if err != nil {
+				report.fail(fn, err)
+				continue
 			}
 		}
+
+		if !fix.Nonconforming && len(fix.Removed) == 0 {
+			continue
+		}
+
+		if !fi.dryRun {
+			err := msg.Save()
+			if err != nil {
+				report.fail(fn, err)
+				continue
+			}
+		}
+
+		report.KeywordFixes = append(report.KeywordFixes, fix)
 	}
 
-	return nil
+	if err := msgs.Err(); err != nil {
+		report.fail(folder, err)
+	}
 }
@@ -0,0 +1,121 @@
+package mail
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	draftMessage = `Subject: Draft
+Keywords: Teamwork
+
+A message in an unwanted folder.
+`
+
+	discussionMessage = `Subject: Discussion
+Keywords: Discussion Other
+
+A message with an unwanted keyword.
+`
+)
+
+func mkVacuumFilter(t *testing.T) (*Filter, string) {
+	t.Helper()
+
+	f, root := mkTempFilter(t)
+	writeTestMessage(t, root, "Drafts", "4:2,S", draftMessage)
+	writeTestMessage(t, root, "Other", "5:2,S", discussionMessage)
+	return f, root
+}
+
+func TestFilter_Vacuum_DryRun(t *testing.T) {
+	t.Parallel()
+
+	f, root := mkVacuumFilter(t)
+	f.SetDryRun(true)
+
+	report, err := f.Vacuum()
+	require.NoError(t, err)
+
+	assert.True(t, report.DryRun)
+	assert.Equal(t, []string{"Drafts"}, report.DroppedFolders)
+	assert.Equal(t, []VacuumMove{{
+		Filename: filepath.Join(root, "Drafts", "cur", "4:2,S"),
+		From:     "Drafts",
+		To:       "Teamwork",
+	}}, report.Moved)
+	assert.Equal(t, []VacuumKeywordFix{{
+		Filename:      filepath.Join(root, "Other", "cur", "5:2,S"),
+		Folder:        "Other",
+		Nonconforming: false,
+		Removed:       []string{"Discussion"},
+		Added:         "Teamwork",
+	}}, report.KeywordFixes)
+	assert.Empty(t, report.Failures)
+
+	// nothing changed on disk
+	assert.FileExists(t, filepath.Join(root, "Drafts", "cur", "4:2,S"))
+	assert.NoDirExists(t, filepath.Join(root, "Teamwork"))
+
+	bs, err := os.ReadFile(filepath.Join(root, "Other", "cur", "5:2,S"))
+	require.NoError(t, err)
+	assert.Equal(t, discussionMessage, string(bs))
+}
+
+func TestFilter_Vacuum(t *testing.T) {
+	t.Parallel()
+
+	f, root := mkVacuumFilter(t)
+
+	report, err := f.Vacuum()
+	require.NoError(t, err)
+
+	assert.False(t, report.DryRun)
+	assert.Equal(t, []string{"Drafts"}, report.DroppedFolders)
+	assert.Len(t, report.Moved, 1)
+	assert.Len(t, report.KeywordFixes, 1)
+
+	assert.NoDirExists(t, filepath.Join(root, "Drafts"))
+	assert.FileExists(t, filepath.Join(root, "Teamwork", "cur", "4:2,S"))
+
+	msg, err := f.Message("Other", "5:2,S")
+	require.NoError(t, err)
+
+	ks, err := msg.Keywords()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"Other", "Teamwork"}, ks)
+}
+
+func TestVacuumReport_String(t *testing.T) {
+	t.Parallel()
+
+	assert.Equal(t, "Nothing to vacuum.\n", (&VacuumReport{}).String())
+
+	report := &VacuumReport{
+		DryRun:         true,
+		DroppedFolders: []string{"Drafts"},
+		Moved:          []VacuumMove{{"Drafts/cur/4", "Drafts", "Teamwork"}},
+		KeywordFixes: []VacuumKeywordFix{{
+			Filename: "Other/cur/5",
+			Folder:   "Other",
+			Removed:  []string{"Discussion"},
+			Added:    "Teamwork",
+		}},
+	}
+
+	const expected = `Dry run: no changes were made.
+Dropped folders:
+ Drafts
+Moved messages:
+ Drafts => Teamwork : Drafts/cur/4
+Fixed keywords:
+ Other/cur/5: replaced Discussion with Teamwork
+Dropped 1 folders, moved 1 messages, fixed keywords on 1 messages, 0 failures.
+`
+
+	assert.Equal(t, expected, report.String())
+}